@@ -0,0 +1,42 @@
+// Package backplane connects server nodes so that players on different nodes can find and play each
+// other. It offers publish/subscribe messaging, presence records that expire unless refreshed, and
+// shared queues. Memory serves a single process; Redis shares state through a Redis server or
+// anything that speaks its protocol.
+package backplane
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrClosed is returned once a backplane has been closed.
+var ErrClosed = errors.New("backplane closed")
+
+// Backplane is the state shared by every node of a cluster. Its methods are safe for concurrent use.
+type Backplane interface {
+	// Publish delivers msg to every subscriber of channel on every node, including this one.
+	Publish(ctx context.Context, channel string, msg []byte) error
+	// Subscribe calls handle with every message later published to channel until cancel is
+	// called. Messages on one channel are handled one at a time and in order, so handle must not
+	// block for long.
+	Subscribe(channel string, handle func(msg []byte)) (cancel func(), err error)
+
+	// Announce records that key, such as a user or game, is present on node until ttl passes.
+	// Announcing again extends the record.
+	Announce(ctx context.Context, key, node string, ttl time.Duration) error
+	// Withdraw removes the record that key is present on node.
+	Withdraw(ctx context.Context, key, node string) error
+	// Locate returns every node key is currently present on.
+	Locate(ctx context.Context, key string) ([]string, error)
+
+	// Push appends item to the end of a queue.
+	Push(ctx context.Context, queue string, item []byte) error
+	// Pop removes and returns the item at the front of a queue, or nil if it is empty.
+	Pop(ctx context.Context, queue string) ([]byte, error)
+	// Remove removes the first copy of item from a queue and reports whether there was one.
+	Remove(ctx context.Context, queue string, item []byte) (bool, error)
+
+	// Close releases the backplane's connections and stops every subscription.
+	Close() error
+}
@@ -0,0 +1,113 @@
+package backplane
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testBackplane checks the behaviour every Backplane must share. newNode returns a new handle on
+// the same shared state, as a second node of the cluster would see it.
+func testBackplane(t *testing.T, newNode func() Backplane) {
+	ctx := context.Background()
+	a, b := newNode(), newNode()
+	defer a.Close()
+	defer b.Close()
+
+	t.Run("PubSub", func(t *testing.T) {
+		received := make(chan string, 4)
+		cancel, err := b.Subscribe("events", func(msg []byte) { received <- string(msg) })
+		if err != nil {
+			t.Fatalf("Failed to subscribe: %v", err)
+		}
+		for _, msg := range []string{"one", "two"} {
+			if err := a.Publish(ctx, "events", []byte(msg)); err != nil {
+				t.Fatalf("Failed to publish: %v", err)
+			}
+		}
+		for _, want := range []string{"one", "two"} {
+			select {
+			case got := <-received:
+				if got != want {
+					t.Errorf("Expected %q, got %q", want, got)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Timed out waiting for %q", want)
+			}
+		}
+
+		cancel()
+		a.Publish(ctx, "events", []byte("three"))
+		select {
+		case got := <-received:
+			t.Errorf("Expected no message after cancel, got %q", got)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("Presence", func(t *testing.T) {
+		if err := a.Announce(ctx, "user:alice", "node-a", time.Minute); err != nil {
+			t.Fatalf("Failed to announce: %v", err)
+		}
+		b.Announce(ctx, "user:alice", "node-b", time.Minute)
+		b.Announce(ctx, "user:bob", "node-b", time.Millisecond)
+		time.Sleep(5 * time.Millisecond)
+
+		if nodes, _ := b.Locate(ctx, "user:alice"); !reflect.DeepEqual(nodes, []string{"node-a", "node-b"}) {
+			t.Errorf("Expected alice on both nodes, got %v", nodes)
+		}
+		if nodes, _ := a.Locate(ctx, "user:bob"); len(nodes) != 0 {
+			t.Errorf("Expected bob's presence to have expired, got %v", nodes)
+		}
+		a.Withdraw(ctx, "user:alice", "node-a")
+		if nodes, _ := b.Locate(ctx, "user:alice"); !reflect.DeepEqual(nodes, []string{"node-b"}) {
+			t.Errorf("Expected alice only on node-b, got %v", nodes)
+		}
+	})
+
+	t.Run("Queue", func(t *testing.T) {
+		for _, item := range []string{"first", "second", "third"} {
+			if err := a.Push(ctx, "waiting", []byte(item)); err != nil {
+				t.Fatalf("Failed to push: %v", err)
+			}
+		}
+		if removed, _ := b.Remove(ctx, "waiting", []byte("second")); !removed {
+			t.Error("Expected second to be removed")
+		}
+		if removed, _ := b.Remove(ctx, "waiting", []byte("second")); removed {
+			t.Error("Expected second to be removed only once")
+		}
+		for _, want := range []string{"first", "third"} {
+			if item, _ := b.Pop(ctx, "waiting"); string(item) != want {
+				t.Errorf("Expected %q, got %q", want, item)
+			}
+		}
+		if item, err := a.Pop(ctx, "waiting"); item != nil || err != nil {
+			t.Errorf("Expected an empty queue, got %q, %v", item, err)
+		}
+	})
+}
+
+// testClosed checks that a closed backplane refuses further use.
+func testClosed(t *testing.T, bp Backplane) {
+	bp.Close()
+	if err := bp.Push(context.Background(), "waiting", []byte("x")); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+	if _, err := bp.Subscribe("events", func([]byte) {}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+	testBackplane(t, func() Backplane { return sharedMemory{m} })
+	testClosed(t, NewMemory())
+}
+
+// sharedMemory lets several test nodes share one Memory while closing only their own handle.
+type sharedMemory struct{ *Memory }
+
+func (s sharedMemory) Close() error { return nil }
@@ -0,0 +1,204 @@
+package backplane
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// subscriptionBuffer is how many messages a Memory subscription holds before Publish waits for
+// its handler to catch up.
+const subscriptionBuffer = 256
+
+// Memory is a Backplane for nodes in a single process. It is the default when the server runs as
+// one node, and lets tests run several nodes side by side.
+type Memory struct {
+	subscriptions map[string][]*memorySubscription
+	presence      map[string]map[string]time.Time // Expiry by node, by key
+	queues        map[string][][]byte
+	closed        bool
+	mu            sync.Mutex
+}
+
+// memorySubscription hands messages to its handler on its own goroutine, so Publish never runs a
+// handler on the caller's goroutine.
+type memorySubscription struct {
+	messages chan []byte
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewMemory creates an empty in-process backplane.
+func NewMemory() *Memory {
+	return &Memory{
+		subscriptions: make(map[string][]*memorySubscription),
+		presence:      make(map[string]map[string]time.Time),
+		queues:        make(map[string][][]byte),
+	}
+}
+
+func (m *Memory) Publish(ctx context.Context, channel string, msg []byte) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrClosed
+	}
+	subscriptions := append([]*memorySubscription(nil), m.subscriptions[channel]...)
+	m.mu.Unlock()
+
+	msg = bytes.Clone(msg)
+	for _, s := range subscriptions {
+		select {
+		case s.messages <- msg:
+		case <-s.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(channel string, handle func(msg []byte)) (func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	s := &memorySubscription{messages: make(chan []byte, subscriptionBuffer), done: make(chan struct{})}
+	m.subscriptions[channel] = append(m.subscriptions[channel], s)
+	go func() {
+		for {
+			select {
+			case msg := <-s.messages:
+				handle(msg)
+			case <-s.done:
+				return
+			}
+		}
+	}()
+
+	cancel := func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		subscriptions := m.subscriptions[channel]
+		for i, other := range subscriptions {
+			if other == s {
+				m.subscriptions[channel] = append(subscriptions[:i:i], subscriptions[i+1:]...)
+				break
+			}
+		}
+		if len(m.subscriptions[channel]) == 0 {
+			delete(m.subscriptions, channel)
+		}
+		s.stop()
+	}
+	return cancel, nil
+}
+
+func (s *memorySubscription) stop() {
+	s.once.Do(func() { close(s.done) })
+}
+
+func (m *Memory) Announce(ctx context.Context, key, node string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	nodes, ok := m.presence[key]
+	if !ok {
+		nodes = make(map[string]time.Time)
+		m.presence[key] = nodes
+	}
+	nodes[node] = time.Now().Add(ttl)
+	return nil
+}
+
+func (m *Memory) Withdraw(ctx context.Context, key, node string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	delete(m.presence[key], node)
+	if len(m.presence[key]) == 0 {
+		delete(m.presence, key)
+	}
+	return nil
+}
+
+func (m *Memory) Locate(ctx context.Context, key string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrClosed
+	}
+	now := time.Now()
+	var nodes []string
+	for node, expires := range m.presence[key] {
+		if now.After(expires) {
+			delete(m.presence[key], node)
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes, nil
+}
+
+func (m *Memory) Push(ctx context.Context, queue string, item []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrClosed
+	}
+	m.queues[queue] = append(m.queues[queue], bytes.Clone(item))
+	return nil
+}
+
+func (m *Memory) Pop(ctx context.Context, queue string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrClosed
+	}
+	items := m.queues[queue]
+	if len(items) == 0 {
+		return nil, nil
+	}
+	m.queues[queue] = items[1:]
+	return items[0], nil
+}
+
+func (m *Memory) Remove(ctx context.Context, queue string, item []byte) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return false, ErrClosed
+	}
+	items := m.queues[queue]
+	for i, other := range items {
+		if bytes.Equal(other, item) {
+			m.queues[queue] = append(items[:i:i], items[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Close stops every subscription. Nodes sharing the backplane stop sharing it too.
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	for _, subscriptions := range m.subscriptions {
+		for _, s := range subscriptions {
+			s.stop()
+		}
+	}
+	m.subscriptions = make(map[string][]*memorySubscription)
+	return nil
+}
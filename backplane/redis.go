@@ -0,0 +1,335 @@
+package backplane
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Timeouts for talking to Redis when the caller's context has no deadline.
+const (
+	redisDialTimeout    = 5 * time.Second
+	redisCommandTimeout = 5 * time.Second
+	redisRetryInterval  = time.Second // Wait between attempts to restore the subscriber connection
+)
+
+// redisPrefix namespaces every key and channel, so the backplane can share a Redis server.
+const redisPrefix = "tictactoe:"
+
+// Redis is a Backplane shared through a Redis server, or any server speaking the same protocol.
+// Presence is kept in sorted sets scored by expiry, queues in lists, and messages travel over
+// Redis pub/sub. Commands share one connection and subscriptions another; both are re-dialed
+// after a failure.
+type Redis struct {
+	addr   string
+	conn   *respConn // Nil until the first command, and after a failure
+	closed bool
+	mu     sync.Mutex // Serializes commands on conn
+
+	subscriber *redisSubscriber
+}
+
+// NewRedis creates a backplane for the Redis server at addr, e.g. "localhost:6379". It connects
+// on first use.
+func NewRedis(addr string) *Redis {
+	r := &Redis{addr: addr}
+	r.subscriber = &redisSubscriber{
+		addr:      addr,
+		handlers:  make(map[string][]*redisHandler),
+		confirmed: make(map[string]chan struct{}),
+		done:      make(chan struct{}),
+	}
+	return r
+}
+
+// do sends one command and returns its reply. Error replies are returned as errors.
+func (r *Redis) do(ctx context.Context, args ...string) (any, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, ErrClosed
+	}
+	if r.conn == nil {
+		conn, err := dialRESP(r.addr, redisDialTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		r.conn = conn
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(redisCommandTimeout)
+	}
+	r.conn.conn.SetDeadline(deadline)
+	err := r.conn.writeCommand(args...)
+	var reply any
+	if err == nil {
+		reply, err = r.conn.readReply()
+	}
+	if err != nil {
+		// The connection may be out of step with its replies, so start afresh next time
+		r.conn.close()
+		r.conn = nil
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	if replyErr, ok := reply.(redisError); ok {
+		return nil, replyErr
+	}
+	return reply, nil
+}
+
+func (r *Redis) Publish(ctx context.Context, channel string, msg []byte) error {
+	_, err := r.do(ctx, "PUBLISH", redisPrefix+channel, string(msg))
+	return err
+}
+
+func (r *Redis) Subscribe(channel string, handle func(msg []byte)) (func(), error) {
+	r.mu.Lock()
+	closed := r.closed
+	r.mu.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+	return r.subscriber.subscribe(redisPrefix+channel, handle)
+}
+
+func (r *Redis) Announce(ctx context.Context, key, node string, ttl time.Duration) error {
+	expires := time.Now().Add(ttl).UnixMilli()
+	if _, err := r.do(ctx, "ZADD", presenceKey(key), strconv.FormatInt(expires, 10), node); err != nil {
+		return err
+	}
+	_, err := r.do(ctx, "PEXPIRE", presenceKey(key), strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+func (r *Redis) Withdraw(ctx context.Context, key, node string) error {
+	_, err := r.do(ctx, "ZREM", presenceKey(key), node)
+	return err
+}
+
+func (r *Redis) Locate(ctx context.Context, key string) ([]string, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	if _, err := r.do(ctx, "ZREMRANGEBYSCORE", presenceKey(key), "-inf", "("+now); err != nil {
+		return nil, err
+	}
+	reply, err := r.do(ctx, "ZRANGE", presenceKey(key), "0", "-1")
+	if err != nil {
+		return nil, err
+	}
+	items, _ := reply.([]any)
+	nodes := make([]string, 0, len(items))
+	for _, item := range items {
+		if node, ok := item.([]byte); ok {
+			nodes = append(nodes, string(node))
+		}
+	}
+	return nodes, nil
+}
+
+func (r *Redis) Push(ctx context.Context, queue string, item []byte) error {
+	_, err := r.do(ctx, "RPUSH", queueKey(queue), string(item))
+	return err
+}
+
+func (r *Redis) Pop(ctx context.Context, queue string) ([]byte, error) {
+	reply, err := r.do(ctx, "LPOP", queueKey(queue))
+	if err != nil {
+		return nil, err
+	}
+	item, _ := reply.([]byte)
+	return item, nil
+}
+
+func (r *Redis) Remove(ctx context.Context, queue string, item []byte) (bool, error) {
+	reply, err := r.do(ctx, "LREM", queueKey(queue), "1", string(item))
+	if err != nil {
+		return false, err
+	}
+	removed, _ := reply.(int64)
+	return removed > 0, nil
+}
+
+// Close closes both connections and stops every subscription.
+func (r *Redis) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if r.conn != nil {
+		r.conn.close()
+	}
+	r.subscriber.close()
+	return nil
+}
+
+func presenceKey(key string) string { return redisPrefix + "presence:" + key }
+
+func queueKey(queue string) string { return redisPrefix + "queue:" + queue }
+
+// redisSubscriber holds the connection that pub/sub messages arrive on. A connection in
+// subscribed mode cannot run other commands, so it is separate from the command connection.
+type redisSubscriber struct {
+	addr      string
+	handlers  map[string][]*redisHandler // By prefixed channel
+	confirmed map[string]chan struct{}   // Closed when the server confirms a new subscription
+	conn      *respConn                  // Nil while disconnected
+	started   bool
+	done      chan struct{}
+	mu        sync.Mutex
+}
+
+type redisHandler struct {
+	handle func(msg []byte)
+}
+
+// subscribe adds a handler and, for a channel not yet subscribed, waits for the server to confirm
+// the subscription so no message published after it returns is missed.
+func (s *redisSubscriber) subscribe(channel string, handle func(msg []byte)) (func(), error) {
+	h := &redisHandler{handle: handle}
+	s.mu.Lock()
+	first := len(s.handlers[channel]) == 0
+	s.handlers[channel] = append(s.handlers[channel], h)
+	var confirmed chan struct{}
+	if first {
+		confirmed = make(chan struct{})
+		s.confirmed[channel] = confirmed
+	}
+	conn := s.conn
+	if !s.started {
+		s.started = true
+		go s.run()
+	}
+	s.mu.Unlock()
+
+	cancel := func() { s.unsubscribe(channel, h) }
+	if !first {
+		return cancel, nil
+	}
+	if conn != nil {
+		// Otherwise run subscribes to every channel once it connects
+		conn.writeCommand("SUBSCRIBE", channel)
+	}
+	select {
+	case <-confirmed:
+		return cancel, nil
+	case <-s.done:
+		cancel()
+		return nil, ErrClosed
+	case <-time.After(redisDialTimeout + redisCommandTimeout):
+		cancel()
+		return nil, errors.New("redis: timed out subscribing to " + channel)
+	}
+}
+
+func (s *redisSubscriber) unsubscribe(channel string, h *redisHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	handlers := s.handlers[channel]
+	for i, other := range handlers {
+		if other == h {
+			s.handlers[channel] = append(handlers[:i:i], handlers[i+1:]...)
+			break
+		}
+	}
+	if len(s.handlers[channel]) > 0 {
+		return
+	}
+	delete(s.handlers, channel)
+	delete(s.confirmed, channel)
+	if s.conn != nil {
+		s.conn.writeCommand("UNSUBSCRIBE", channel)
+	}
+}
+
+// run keeps the subscriber connection open, resubscribing after every reconnect, and hands each
+// message to the channel's handlers.
+func (s *redisSubscriber) run() {
+	for {
+		conn, err := dialRESP(s.addr, redisDialTimeout)
+		if err == nil {
+			s.mu.Lock()
+			select {
+			case <-s.done:
+				s.mu.Unlock()
+				conn.close()
+				return
+			default:
+			}
+			s.conn = conn
+			channels := make([]string, 0, len(s.handlers))
+			for channel := range s.handlers {
+				channels = append(channels, channel)
+			}
+			s.mu.Unlock()
+
+			if len(channels) > 0 {
+				conn.writeCommand(append([]string{"SUBSCRIBE"}, channels...)...)
+			}
+			s.read(conn)
+
+			s.mu.Lock()
+			s.conn = nil
+			s.mu.Unlock()
+			conn.close()
+		}
+
+		select {
+		case <-s.done:
+			return
+		case <-time.After(redisRetryInterval):
+		}
+	}
+}
+
+// read handles replies until the connection fails or is closed.
+func (s *redisSubscriber) read(conn *respConn) {
+	for {
+		reply, err := conn.readReply()
+		if err != nil {
+			return
+		}
+		items, ok := reply.([]any)
+		if !ok || len(items) < 3 {
+			continue
+		}
+		kind, _ := items[0].([]byte)
+		channel, _ := items[1].([]byte)
+		switch string(kind) {
+		case "subscribe":
+			s.mu.Lock()
+			if confirmed, ok := s.confirmed[string(channel)]; ok {
+				close(confirmed)
+				delete(s.confirmed, string(channel))
+			}
+			s.mu.Unlock()
+		case "message":
+			msg, _ := items[2].([]byte)
+			s.mu.Lock()
+			handlers := append([]*redisHandler(nil), s.handlers[string(channel)]...)
+			s.mu.Unlock()
+			for _, h := range handlers {
+				h.handle(msg)
+			}
+		}
+	}
+}
+
+func (s *redisSubscriber) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	close(s.done)
+	if s.conn != nil {
+		s.conn.close()
+	}
+}
@@ -0,0 +1,215 @@
+package backplane
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRedis is a local stand-in for a Redis server that implements just the commands Redis uses.
+type fakeRedis struct {
+	listener    net.Listener
+	zsets       map[string]map[string]float64
+	lists       map[string][]string
+	subscribers map[string]map[*fakeRedisConn]bool
+	mu          sync.Mutex
+}
+
+type fakeRedisConn struct {
+	conn net.Conn
+	wmu  sync.Mutex
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	f := &fakeRedis{
+		listener:    listener,
+		zsets:       make(map[string]map[string]float64),
+		lists:       make(map[string][]string),
+		subscribers: make(map[string]map[*fakeRedisConn]bool),
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(&fakeRedisConn{conn: conn})
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) addr() string { return f.listener.Addr().String() }
+
+func (f *fakeRedis) serve(c *fakeRedisConn) {
+	defer func() {
+		f.mu.Lock()
+		for _, conns := range f.subscribers {
+			delete(conns, c)
+		}
+		f.mu.Unlock()
+		c.conn.Close()
+	}()
+
+	r := bufio.NewReader(c.conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		f.handle(c, args)
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func (f *fakeRedis) handle(c *fakeRedisConn, args []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "PUBLISH":
+		receivers := 0
+		for sub := range f.subscribers[args[1]] {
+			sub.write(array(bulk("message"), bulk(args[1]), bulk(args[2])))
+			receivers++
+		}
+		c.write(integer(receivers))
+	case "SUBSCRIBE", "UNSUBSCRIBE":
+		kind := strings.ToLower(args[0])
+		for _, channel := range args[1:] {
+			if kind == "subscribe" {
+				if f.subscribers[channel] == nil {
+					f.subscribers[channel] = make(map[*fakeRedisConn]bool)
+				}
+				f.subscribers[channel][c] = true
+			} else {
+				delete(f.subscribers[channel], c)
+			}
+			c.write(array(bulk(kind), bulk(channel), integer(1)))
+		}
+	case "ZADD":
+		if f.zsets[args[1]] == nil {
+			f.zsets[args[1]] = make(map[string]float64)
+		}
+		score, _ := strconv.ParseFloat(args[2], 64)
+		f.zsets[args[1]][args[3]] = score
+		c.write(integer(1))
+	case "ZREM":
+		delete(f.zsets[args[1]], args[2])
+		c.write(integer(1))
+	case "ZREMRANGEBYSCORE":
+		max, _ := strconv.ParseFloat(strings.TrimPrefix(args[3], "("), 64)
+		for member, score := range f.zsets[args[1]] {
+			if score < max {
+				delete(f.zsets[args[1]], member)
+			}
+		}
+		c.write(integer(0))
+	case "ZRANGE":
+		members := make([]string, 0, len(f.zsets[args[1]]))
+		for member := range f.zsets[args[1]] {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		items := make([]string, len(members))
+		for i, member := range members {
+			items[i] = bulk(member)
+		}
+		c.write(array(items...))
+	case "PEXPIRE":
+		c.write(integer(1))
+	case "RPUSH":
+		f.lists[args[1]] = append(f.lists[args[1]], args[2])
+		c.write(integer(len(f.lists[args[1]])))
+	case "LPOP":
+		list := f.lists[args[1]]
+		if len(list) == 0 {
+			c.write("$-1\r\n")
+			return
+		}
+		f.lists[args[1]] = list[1:]
+		c.write(bulk(list[0]))
+	case "LREM":
+		list := f.lists[args[1]]
+		for i, item := range list {
+			if item == args[3] {
+				f.lists[args[1]] = append(list[:i:i], list[i+1:]...)
+				c.write(integer(1))
+				return
+			}
+		}
+		c.write(integer(0))
+	default:
+		c.write("-ERR unknown command '" + args[0] + "'\r\n")
+	}
+}
+
+func (c *fakeRedisConn) write(reply string) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.conn.Write([]byte(reply))
+}
+
+func bulk(s string) string { return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s) }
+
+func integer(n int) string { return fmt.Sprintf(":%d\r\n", n) }
+
+func array(items ...string) string {
+	return fmt.Sprintf("*%d\r\n%s", len(items), strings.Join(items, ""))
+}
+
+func TestRedis(t *testing.T) {
+	server := newFakeRedis(t)
+	testBackplane(t, func() Backplane { return NewRedis(server.addr()) })
+	testClosed(t, NewRedis(server.addr()))
+}
+
+func TestRedisErrorReply(t *testing.T) {
+	server := newFakeRedis(t)
+	r := NewRedis(server.addr())
+	defer r.Close()
+
+	if _, err := r.do(context.Background(), "FLUSHALL"); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("Expected the server's error reply, got %v", err)
+	}
+	// The connection stays usable after an error reply
+	if err := r.Push(context.Background(), "q", []byte("x")); err != nil {
+		t.Errorf("Expected the next command to succeed, got %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+// Package codec encodes and decodes packets for the wire. The codec for a connection is negotiated
+// through the WebSocket subprotocol; clients that request none are served JSON.
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"tictactoe/models"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Subprotocol names a client can request in the Sec-WebSocket-Protocol header.
+const (
+	JSONSubprotocol    = "tictactoe.json"
+	MsgpackSubprotocol = "tictactoe.msgpack"
+)
+
+// Codec converts packets to and from WebSocket message payloads.
+type Codec interface {
+	Subprotocol() string
+	MessageType() int // websocket.TextMessage or websocket.BinaryMessage
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+	// UnmarshalStrict is like Unmarshal but rejects fields v does not declare. Unknown and
+	// mistyped fields are reported as a *models.ValidationError.
+	UnmarshalStrict(data []byte, v any) error
+	// PacketHeader reads a packet's type and request ID without decoding the rest of the packet.
+	PacketHeader(data []byte) (models.BasePacket, error)
+}
+
+// JSON is the default text codec.
+var JSON Codec = jsonCodec{}
+
+// Msgpack is the compact binary codec. Field names follow the packets' json tags, so both codecs
+// describe the same packets.
+var Msgpack Codec = msgpackCodec{}
+
+// all lists the codecs in the server's order of preference.
+var all = []Codec{JSON, Msgpack}
+
+// Subprotocols returns the subprotocol names the server accepts.
+func Subprotocols() []string {
+	names := make([]string, len(all))
+	for i, c := range all {
+		names[i] = c.Subprotocol()
+	}
+	return names
+}
+
+// ForSubprotocol returns the codec for a negotiated subprotocol, falling back to JSON when none was
+// negotiated.
+func ForSubprotocol(name string) Codec {
+	for _, c := range all {
+		if c.Subprotocol() == name {
+			return c
+		}
+	}
+	return JSON
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Subprotocol() string                { return JSONSubprotocol }
+func (jsonCodec) MessageType() int                   { return websocket.TextMessage }
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+func (jsonCodec) UnmarshalStrict(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return fieldError(typeErr.Field, "must be of type %s", typeErr.Type)
+	}
+	return strictError(err)
+}
+
+func (jsonCodec) PacketHeader(data []byte) (models.BasePacket, error) {
+	var header models.BasePacket
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return header, errors.New("packet is not an object")
+	}
+	for dec.More() && (header.Type == "" || header.RequestID == "") {
+		key, err := dec.Token()
+		if err != nil {
+			return header, err
+		}
+		switch key {
+		case "type":
+			err = dec.Decode(&header.Type)
+		case "requestId":
+			err = dec.Decode(&header.RequestID)
+		default:
+			err = dec.Decode(&json.RawMessage{})
+		}
+		if err != nil {
+			return header, err
+		}
+	}
+	return header, headerError(header)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Subprotocol() string { return MsgpackSubprotocol }
+func (msgpackCodec) MessageType() int    { return websocket.BinaryMessage }
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+func (msgpackCodec) UnmarshalStrict(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	dec.DisallowUnknownFields(true)
+	return strictError(dec.Decode(v))
+}
+
+func (msgpackCodec) PacketHeader(data []byte) (models.BasePacket, error) {
+	var header models.BasePacket
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	n, err := dec.DecodeMapLen()
+	if err != nil {
+		return header, errors.New("packet is not a map")
+	}
+	for i := 0; i < n && (header.Type == "" || header.RequestID == ""); i++ {
+		key, err := dec.DecodeString()
+		if err != nil {
+			return header, err
+		}
+		switch key {
+		case "type":
+			header.Type, err = dec.DecodeString()
+		case "requestId":
+			header.RequestID, err = dec.DecodeString()
+		default:
+			err = dec.Skip()
+		}
+		if err != nil {
+			return header, err
+		}
+	}
+	return header, headerError(header)
+}
+
+// headerError reports a packet without a type.
+func headerError(header models.BasePacket) error {
+	if header.Type == "" {
+		return errors.New("packet has no type")
+	}
+	return nil
+}
+
+// unknownField matches the unknown field errors of both encoding/json and msgpack.
+var unknownField = regexp.MustCompile(`^\w+: unknown field "(.*)"$`)
+
+// strictError converts an unknown field error into a validation error.
+func strictError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if m := unknownField.FindStringSubmatch(err.Error()); m != nil {
+		return fieldError(m[1], "is not a known field")
+	}
+	return err
+}
+
+func fieldError(field, format string, args ...any) error {
+	var v models.ValidationError
+	v.Add(field, format, args...)
+	return &v
+}
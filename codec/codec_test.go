@@ -0,0 +1,115 @@
+package codec
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"tictactoe/models"
+)
+
+func TestRoundTrip(t *testing.T) {
+	deadline := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	packets := []any{
+		&models.ConnectPacket{
+			BasePacket:      models.BasePacket{Type: "connect"},
+			Username:        "alice",
+			DeviceID:        "phone",
+			ProtocolVersion: 1,
+			Capabilities:    []string{"chat"},
+		},
+		&models.GameUpdatePacket{
+			BasePacket:  models.BasePacket{Type: "gameUpdate"},
+			GameID:      "game",
+			Board:       [3][3]string{{"X", "", ""}, {"", "O", ""}, {"", "", ""}},
+			CurrentTurn: true,
+			Status:      "in_progress",
+		},
+		&models.ServerShutdownPacket{
+			BasePacket: models.BasePacket{Type: "serverShutdown"},
+			Message:    "bye",
+			Deadline:   &deadline,
+		},
+	}
+
+	for _, c := range []Codec{JSON, Msgpack} {
+		for _, packet := range packets {
+			data, err := c.Marshal(packet)
+			if err != nil {
+				t.Fatalf("%s: Marshal(%T) failed: %v", c.Subprotocol(), packet, err)
+			}
+
+			// Packets are dispatched on their type before being decoded in full
+			var base models.BasePacket
+			if err := c.Unmarshal(data, &base); err != nil {
+				t.Fatalf("%s: Unmarshal base of %T failed: %v", c.Subprotocol(), packet, err)
+			}
+			if want := reflect.ValueOf(packet).Elem().FieldByName("Type").String(); base.Type != want {
+				t.Errorf("%s: expected type %q, got %q", c.Subprotocol(), want, base.Type)
+			}
+
+			decoded := reflect.New(reflect.TypeOf(packet).Elem()).Interface()
+			if err := c.Unmarshal(data, decoded); err != nil {
+				t.Fatalf("%s: Unmarshal(%T) failed: %v", c.Subprotocol(), packet, err)
+			}
+			if shutdown, ok := decoded.(*models.ServerShutdownPacket); ok && shutdown.Deadline != nil {
+				// Time zones are not part of the encoding, only the instant is
+				utc := shutdown.Deadline.UTC()
+				shutdown.Deadline = &utc
+			}
+			if !reflect.DeepEqual(decoded, packet) {
+				t.Errorf("%s: round trip changed the packet:\n got %+v\nwant %+v", c.Subprotocol(), decoded, packet)
+			}
+		}
+	}
+}
+
+func TestMsgpackUsesJSONFieldNames(t *testing.T) {
+	data, err := Msgpack.Marshal(models.ErrorPacket{BasePacket: models.BasePacket{Type: "error"}, Message: "oops"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]any
+	if err := Msgpack.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["type"] != "error" || fields["message"] != "oops" {
+		t.Errorf("Expected flat json-named fields, got %v", fields)
+	}
+}
+
+func TestForSubprotocol(t *testing.T) {
+	if ForSubprotocol(MsgpackSubprotocol) != Msgpack {
+		t.Errorf("Expected the msgpack codec")
+	}
+	if ForSubprotocol("") != JSON {
+		t.Errorf("Expected JSON when no subprotocol was negotiated")
+	}
+}
+
+func TestPacketHeaderAndStrictDecoding(t *testing.T) {
+	for _, c := range []Codec{JSON, Msgpack} {
+		// The type need not be the first field
+		data, err := c.Marshal(map[string]any{"gameId": "game", "row": 1, "type": "move", "col": 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header, err := c.PacketHeader(data); err != nil || header.Type != "move" {
+			t.Errorf("%s: expected type move, got %q (%v)", c.Subprotocol(), header.Type, err)
+		}
+
+		data, _ = c.Marshal(map[string]any{"type": "move", "gameId": "game", "requestId": "r1"})
+		if header, err := c.PacketHeader(data); err != nil || header.RequestID != "r1" {
+			t.Errorf("%s: expected request ID r1, got %q (%v)", c.Subprotocol(), header.RequestID, err)
+		}
+
+		data, _ = c.Marshal(map[string]any{"type": "move", "gameId": "game", "row": 1, "col": 2, "speed": 9})
+		var move models.MovePacket
+		err = c.UnmarshalStrict(data, &move)
+		var validationErr *models.ValidationError
+		if !errors.As(err, &validationErr) || validationErr.Fields[0].Field != "speed" {
+			t.Errorf("%s: expected the unknown field to be rejected, got %v", c.Subprotocol(), err)
+		}
+	}
+}
@@ -0,0 +1,646 @@
+// Package config loads server settings from a JSON file, environment variables and command-line flags.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds every server setting.
+type Config struct {
+	Server      Server      `json:"server"`
+	TLS         TLS         `json:"tls"`
+	WebSocket   WebSocket   `json:"websocket"`
+	Matchmaking Matchmaking `json:"matchmaking"`
+	Abandonment Abandonment `json:"abandonment"`
+	Log         Log         `json:"log"`
+	Moderation  Moderation  `json:"moderation"`
+	RateLimit   RateLimit   `json:"rateLimit"`
+	Backplane   Backplane   `json:"backplane"`
+}
+
+// Server holds HTTP listener and shutdown settings.
+type Server struct {
+	Addr              string   `json:"addr"`              // Listen address, e.g. ":8080"
+	ReadHeaderTimeout Duration `json:"readHeaderTimeout"` // Time allowed to read request headers
+	ShutdownTimeout   Duration `json:"shutdownTimeout"`   // Maximum time for a graceful shutdown
+	ShutdownWaitGames bool     `json:"shutdownWaitGames"` // Wait for in-progress games before shutting down
+}
+
+// TLS holds certificate settings. TLS is enabled when both files are set.
+type TLS struct {
+	CertFile       string   `json:"certFile"`
+	KeyFile        string   `json:"keyFile"`
+	ReloadInterval Duration `json:"reloadInterval"` // How often the files are checked for changes
+}
+
+// Enabled reports whether the server should serve TLS.
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
+
+// WebSocket holds connection upgrade settings.
+type WebSocket struct {
+	ReadBufferSize     int      `json:"readBufferSize"`     // Bytes; 0 uses the library default
+	WriteBufferSize    int      `json:"writeBufferSize"`    // Bytes; 0 uses the library default
+	HandshakeTimeout   Duration `json:"handshakeTimeout"`   // Time allowed for the upgrade handshake
+	AllowedOrigins     []string `json:"allowedOrigins"`     // Browser origins allowed to connect, e.g. "https://*.example.com"; "*" allows any
+	MaxMessageSize     int64    `json:"maxMessageSize"`     // Largest inbound message in bytes; larger messages close the connection
+	ReadTimeout        Duration `json:"readTimeout"`        // Time allowed without an inbound message or pong before the connection is reaped; 0 disables
+	WriteTimeout       Duration `json:"writeTimeout"`       // Time allowed for a single write to complete
+	PingInterval       Duration `json:"pingInterval"`       // How often the server pings each client; 0 disables pings
+	SendQueueSize      int      `json:"sendQueueSize"`      // Outbound messages buffered per connection
+	SlowConsumerPolicy string   `json:"slowConsumerPolicy"` // "drop" or "disconnect" when a send queue is full
+	EnableCompression  bool     `json:"enableCompression"`  // Negotiate per-message deflate compression
+}
+
+// Slow consumer policies applied when a connection's send queue is full.
+const (
+	SlowConsumerDrop       = "drop"       // Drop the message that did not fit
+	SlowConsumerDisconnect = "disconnect" // Close the connection
+)
+
+// Matchmaking holds matchmaking settings.
+type Matchmaking struct {
+	Timeout Duration `json:"timeout"` // How long a player waits for an opponent
+	Seating string   `json:"seating"` // Who plays X in a matched game, see SeatingBalanced
+}
+
+// Seating policies that pick which of two matched players plays X and moves first.
+const (
+	SeatingAlternate = "alternate" // Each game seats its players the other way around from the one before
+	SeatingRandom    = "random"    // A coin flip for each game
+	SeatingBalanced  = "balanced"  // The player who has played X less often, or a coin flip if even
+)
+
+// Abandonment holds the policy for players who leave a game or stop moving. A player who forfeits
+// this way loses, their opponent wins, and they wait out a matchmaking cooldown that grows each
+// time they do it again.
+type Abandonment struct {
+	GracePeriod  Duration   `json:"gracePeriod"`  // Time a player whose last session closed has to reconnect; 0 forfeits at once
+	MoveTimeout  Duration   `json:"moveTimeout"`  // Time a player has to make each move; 0 never forfeits idle players
+	Cooldowns    []Duration `json:"cooldowns"`    // Matchmaking cooldown after the first, second and later abandonments; the last repeats
+	ForgiveAfter Duration   `json:"forgiveAfter"` // Time without abandoning after which cooldowns start over; 0 never forgives
+}
+
+// Log holds logging settings.
+type Log struct {
+	Level       string `json:"level"`       // Minimum level of log records
+	PacketLevel string `json:"packetLevel"` // Level at which every inbound packet is logged
+}
+
+// Moderation holds moderation settings.
+type Moderation struct {
+	AuditLogPath  string `json:"auditLogPath"`  // File the audit trail is appended to; empty keeps it in memory only
+	OperatorToken string `json:"operatorToken"` // Bearer token for the moderation API endpoints; empty disables them
+}
+
+// RateLimit holds token bucket budgets. A bucket with a zero rate is unlimited.
+type RateLimit struct {
+	ConnectionsPerIP Bucket `json:"connectionsPerIp"` // New WebSocket connections per remote IP
+	Packets          Bucket `json:"packets"`          // Inbound packets of any type per connection
+	Chat             Bucket `json:"chat"`             // Chat packets per user
+	Moves            Bucket `json:"moves"`            // Move packets per user
+	Matchmaking      Bucket `json:"matchmaking"`      // Play packets per user
+	MaxViolations    int    `json:"maxViolations"`    // Rate-limited packets in a row before the connection is closed; 0 never closes it
+}
+
+// Backplane drivers that connect server nodes.
+const (
+	BackplaneMemory = "memory" // A single node; nothing is shared with other processes
+	BackplaneRedis  = "redis"  // Nodes share a Redis server, or any server speaking its protocol
+)
+
+// Backplane holds settings for sharing presence, matchmaking and game events between server nodes.
+// Moderation sanctions, user stats and matchmaking cooldowns are not shared; each node keeps its own.
+type Backplane struct {
+	Driver      string   `json:"driver"`      // See BackplaneMemory and BackplaneRedis
+	Addr        string   `json:"addr"`        // Redis server address, e.g. "localhost:6379"
+	NodeID      string   `json:"nodeId"`      // Unique name of this node; empty picks one at startup
+	PresenceTTL Duration `json:"presenceTtl"` // How long a node's presence records outlive it
+}
+
+// Bucket is a token bucket refilled at Rate tokens per second up to Burst tokens. Environment
+// variables and flags write it as "rate:burst", e.g. "5:10".
+type Bucket struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// Duration is a time.Duration that is written as a string such as "30s" in config files.
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string such as "1m30s".
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON writes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Default returns the settings used when nothing is overridden.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Addr:              ":8080",
+			ReadHeaderTimeout: Duration(10 * time.Second),
+			ShutdownTimeout:   Duration(30 * time.Second),
+		},
+		TLS: TLS{
+			ReloadInterval: Duration(30 * time.Second),
+		},
+		WebSocket: WebSocket{
+			HandshakeTimeout:   Duration(10 * time.Second),
+			MaxMessageSize:     4096,
+			ReadTimeout:        Duration(60 * time.Second),
+			WriteTimeout:       Duration(10 * time.Second),
+			PingInterval:       Duration(25 * time.Second),
+			SendQueueSize:      64,
+			SlowConsumerPolicy: SlowConsumerDisconnect,
+		},
+		Matchmaking: Matchmaking{
+			Timeout: Duration(120 * time.Second),
+			Seating: SeatingBalanced,
+		},
+		Abandonment: Abandonment{
+			GracePeriod:  Duration(30 * time.Second),
+			MoveTimeout:  Duration(2 * time.Minute),
+			Cooldowns:    []Duration{Duration(time.Minute), Duration(5 * time.Minute), Duration(30 * time.Minute)},
+			ForgiveAfter: Duration(24 * time.Hour),
+		},
+		Log: Log{
+			Level:       "info",
+			PacketLevel: "debug",
+		},
+		RateLimit: RateLimit{
+			ConnectionsPerIP: Bucket{Rate: 2, Burst: 30},
+			Packets:          Bucket{Rate: 20, Burst: 40},
+			Chat:             Bucket{Rate: 1, Burst: 5},
+			Moves:            Bucket{Rate: 5, Burst: 10},
+			Matchmaking:      Bucket{Rate: 0.5, Burst: 5},
+			MaxViolations:    20,
+		},
+		Backplane: Backplane{
+			Driver:      BackplaneMemory,
+			PresenceTTL: Duration(30 * time.Second),
+		},
+	}
+}
+
+// setting is a single value that can be overridden by an environment variable or a flag.
+type setting struct {
+	flag   string
+	env    string
+	usage  string
+	isBool bool // Whether the flag may be given without a value
+	apply  func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{"addr", "TICTACTOE_ADDR", "Listen address", false, func(c *Config, v string) error {
+		c.Server.Addr = v
+		return nil
+	}},
+	{"read-header-timeout", "TICTACTOE_READ_HEADER_TIMEOUT", "Time allowed to read request headers", false, func(c *Config, v string) error {
+		return parseDuration(&c.Server.ReadHeaderTimeout, v)
+	}},
+	{"shutdown-timeout", "TICTACTOE_SHUTDOWN_TIMEOUT", "Maximum time for a graceful shutdown", false, func(c *Config, v string) error {
+		return parseDuration(&c.Server.ShutdownTimeout, v)
+	}},
+	{"shutdown-wait-games", "TICTACTOE_SHUTDOWN_WAIT_GAMES", "Wait for in-progress games to finish before shutting down", true, func(c *Config, v string) error {
+		return parseBool(&c.Server.ShutdownWaitGames, v)
+	}},
+	{"tls-cert", "TICTACTOE_TLS_CERT_FILE", "TLS certificate file", false, func(c *Config, v string) error {
+		c.TLS.CertFile = v
+		return nil
+	}},
+	{"tls-key", "TICTACTOE_TLS_KEY_FILE", "TLS private key file", false, func(c *Config, v string) error {
+		c.TLS.KeyFile = v
+		return nil
+	}},
+	{"tls-reload-interval", "TICTACTOE_TLS_RELOAD_INTERVAL", "How often TLS files are checked for changes", false, func(c *Config, v string) error {
+		return parseDuration(&c.TLS.ReloadInterval, v)
+	}},
+	{"ws-read-buffer-size", "TICTACTOE_WS_READ_BUFFER_SIZE", "WebSocket read buffer size in bytes", false, func(c *Config, v string) error {
+		return parseInt(&c.WebSocket.ReadBufferSize, v)
+	}},
+	{"ws-write-buffer-size", "TICTACTOE_WS_WRITE_BUFFER_SIZE", "WebSocket write buffer size in bytes", false, func(c *Config, v string) error {
+		return parseInt(&c.WebSocket.WriteBufferSize, v)
+	}},
+	{"ws-handshake-timeout", "TICTACTOE_WS_HANDSHAKE_TIMEOUT", "Time allowed for the WebSocket handshake", false, func(c *Config, v string) error {
+		return parseDuration(&c.WebSocket.HandshakeTimeout, v)
+	}},
+	{"ws-max-message-size", "TICTACTOE_WS_MAX_MESSAGE_SIZE", "Largest inbound WebSocket message in bytes", false, func(c *Config, v string) error {
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		c.WebSocket.MaxMessageSize = parsed
+		return err
+	}},
+	{"ws-read-timeout", "TICTACTOE_WS_READ_TIMEOUT", "Time allowed without an inbound message or pong; 0 disables", false, func(c *Config, v string) error {
+		return parseDuration(&c.WebSocket.ReadTimeout, v)
+	}},
+	{"ws-write-timeout", "TICTACTOE_WS_WRITE_TIMEOUT", "Time allowed for a single WebSocket write", false, func(c *Config, v string) error {
+		return parseDuration(&c.WebSocket.WriteTimeout, v)
+	}},
+	{"ws-ping-interval", "TICTACTOE_WS_PING_INTERVAL", "How often clients are pinged; 0 disables pings", false, func(c *Config, v string) error {
+		return parseDuration(&c.WebSocket.PingInterval, v)
+	}},
+	{"ws-send-queue-size", "TICTACTOE_WS_SEND_QUEUE_SIZE", "Outbound messages buffered per connection", false, func(c *Config, v string) error {
+		return parseInt(&c.WebSocket.SendQueueSize, v)
+	}},
+	{"ws-slow-consumer-policy", "TICTACTOE_WS_SLOW_CONSUMER_POLICY", "What to do when a send queue is full: drop or disconnect", false, func(c *Config, v string) error {
+		c.WebSocket.SlowConsumerPolicy = v
+		return nil
+	}},
+	{"ws-compression", "TICTACTOE_WS_COMPRESSION", "Negotiate per-message deflate compression", true, func(c *Config, v string) error {
+		return parseBool(&c.WebSocket.EnableCompression, v)
+	}},
+	{"allowed-origins", "TICTACTOE_ALLOWED_ORIGINS", "Comma-separated browser origins allowed to connect", false, func(c *Config, v string) error {
+		c.WebSocket.AllowedOrigins = splitList(v)
+		return nil
+	}},
+	{"matchmaking-timeout", "TICTACTOE_MATCHMAKING_TIMEOUT", "How long a player waits for an opponent", false, func(c *Config, v string) error {
+		return parseDuration(&c.Matchmaking.Timeout, v)
+	}},
+	{"seating", "TICTACTOE_SEATING", "Who plays X in a matched game: alternate, random or balanced", false, func(c *Config, v string) error {
+		c.Matchmaking.Seating = v
+		return nil
+	}},
+	{"abandon-grace-period", "TICTACTOE_ABANDON_GRACE_PERIOD", "Time a disconnected player has to reconnect before forfeiting; 0 forfeits at once", false, func(c *Config, v string) error {
+		return parseDuration(&c.Abandonment.GracePeriod, v)
+	}},
+	{"move-timeout", "TICTACTOE_MOVE_TIMEOUT", "Time a player has to make each move before forfeiting; 0 disables", false, func(c *Config, v string) error {
+		return parseDuration(&c.Abandonment.MoveTimeout, v)
+	}},
+	{"abandon-cooldowns", "TICTACTOE_ABANDON_COOLDOWNS", "Comma-separated matchmaking cooldowns after each abandonment, e.g. 1m,5m,30m", false, func(c *Config, v string) error {
+		return parseDurations(&c.Abandonment.Cooldowns, v)
+	}},
+	{"abandon-forgive-after", "TICTACTOE_ABANDON_FORGIVE_AFTER", "Time without abandoning after which cooldowns start over; 0 never forgives", false, func(c *Config, v string) error {
+		return parseDuration(&c.Abandonment.ForgiveAfter, v)
+	}},
+	{"log-level", "TICTACTOE_LOG_LEVEL", "Minimum level of log records: debug, info, warn or error", false, func(c *Config, v string) error {
+		c.Log.Level = v
+		return nil
+	}},
+	{"packet-log-level", "TICTACTOE_PACKET_LOG_LEVEL", "Level at which every inbound packet is logged", false, func(c *Config, v string) error {
+		c.Log.PacketLevel = v
+		return nil
+	}},
+	{"audit-log", "TICTACTOE_AUDIT_LOG", "File the moderation audit trail is appended to", false, func(c *Config, v string) error {
+		c.Moderation.AuditLogPath = v
+		return nil
+	}},
+	{"moderation-token", "TICTACTOE_MODERATION_TOKEN", "Bearer token operators use to issue and lift sanctions over the API; empty disables", false, func(c *Config, v string) error {
+		c.Moderation.OperatorToken = v
+		return nil
+	}},
+	{"ratelimit-connections", "TICTACTOE_RATELIMIT_CONNECTIONS", "New connections per remote IP as rate:burst; rate 0 disables", false, func(c *Config, v string) error {
+		return parseBucket(&c.RateLimit.ConnectionsPerIP, v)
+	}},
+	{"ratelimit-packets", "TICTACTOE_RATELIMIT_PACKETS", "Inbound packets per connection as rate:burst; rate 0 disables", false, func(c *Config, v string) error {
+		return parseBucket(&c.RateLimit.Packets, v)
+	}},
+	{"ratelimit-chat", "TICTACTOE_RATELIMIT_CHAT", "Chat packets per user as rate:burst; rate 0 disables", false, func(c *Config, v string) error {
+		return parseBucket(&c.RateLimit.Chat, v)
+	}},
+	{"ratelimit-moves", "TICTACTOE_RATELIMIT_MOVES", "Move packets per user as rate:burst; rate 0 disables", false, func(c *Config, v string) error {
+		return parseBucket(&c.RateLimit.Moves, v)
+	}},
+	{"ratelimit-matchmaking", "TICTACTOE_RATELIMIT_MATCHMAKING", "Play packets per user as rate:burst; rate 0 disables", false, func(c *Config, v string) error {
+		return parseBucket(&c.RateLimit.Matchmaking, v)
+	}},
+	{"ratelimit-max-violations", "TICTACTOE_RATELIMIT_MAX_VIOLATIONS", "Rate-limited packets in a row before a connection is closed; 0 never closes", false, func(c *Config, v string) error {
+		return parseInt(&c.RateLimit.MaxViolations, v)
+	}},
+	{"backplane", "TICTACTOE_BACKPLANE", "Backplane shared with other nodes: memory or redis", false, func(c *Config, v string) error {
+		c.Backplane.Driver = v
+		return nil
+	}},
+	{"backplane-addr", "TICTACTOE_BACKPLANE_ADDR", "Redis server address for the redis backplane", false, func(c *Config, v string) error {
+		c.Backplane.Addr = v
+		return nil
+	}},
+	{"node-id", "TICTACTOE_NODE_ID", "Unique name of this node; empty picks one at startup", false, func(c *Config, v string) error {
+		c.Backplane.NodeID = v
+		return nil
+	}},
+	{"presence-ttl", "TICTACTOE_PRESENCE_TTL", "How long a node's presence records outlive it", false, func(c *Config, v string) error {
+		return parseDuration(&c.Backplane.PresenceTTL, v)
+	}},
+}
+
+// Load builds the configuration from defaults, then the config file, then environment variables,
+// then command-line flags, and validates the result. The config file is named by the -config flag
+// or the TICTACTOE_CONFIG environment variable.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	fs := flag.NewFlagSet("tictactoe", flag.ContinueOnError)
+	configPath := fs.String("config", "", "Path to a JSON config file (env TICTACTOE_CONFIG)")
+	flagValues := make(map[string]string, len(settings))
+	for _, s := range settings {
+		name := s.flag
+		record := func(value string) error {
+			flagValues[name] = value
+			return nil
+		}
+		usage := fmt.Sprintf("%s (env %s)", s.usage, s.env)
+		if s.isBool {
+			fs.BoolFunc(name, usage, record)
+		} else {
+			fs.Func(name, usage, record)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	path := *configPath
+	if path == "" {
+		path, _ = lookupEnv("TICTACTOE_CONFIG")
+	}
+
+	cfg := Default()
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := lookupEnv(s.env); ok {
+			if err := s.apply(cfg, value); err != nil {
+				return nil, fmt.Errorf("environment variable %s: %w", s.env, err)
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := flagValues[s.flag]; ok {
+			if err := s.apply(cfg, value); err != nil {
+				return nil, fmt.Errorf("flag -%s: %w", s.flag, err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks every setting and reports all problems at once.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if c.Server.Addr == "" {
+		fail("server.addr", "must not be empty")
+	}
+	if c.Server.ReadHeaderTimeout <= 0 {
+		fail("server.readHeaderTimeout", "must be positive")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		fail("server.shutdownTimeout", "must be positive")
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		fail("tls", "certFile and keyFile must be set together")
+	}
+	if c.TLS.CertFile != "" {
+		if _, err := os.Stat(c.TLS.CertFile); err != nil {
+			fail("tls.certFile", "%v", err)
+		}
+	}
+	if c.TLS.KeyFile != "" {
+		if _, err := os.Stat(c.TLS.KeyFile); err != nil {
+			fail("tls.keyFile", "%v", err)
+		}
+	}
+	if c.TLS.Enabled() && c.TLS.ReloadInterval <= 0 {
+		fail("tls.reloadInterval", "must be positive")
+	}
+
+	if c.WebSocket.ReadBufferSize < 0 {
+		fail("websocket.readBufferSize", "must not be negative")
+	}
+	if c.WebSocket.WriteBufferSize < 0 {
+		fail("websocket.writeBufferSize", "must not be negative")
+	}
+	if c.WebSocket.HandshakeTimeout <= 0 {
+		fail("websocket.handshakeTimeout", "must be positive")
+	}
+	if c.WebSocket.MaxMessageSize <= 0 {
+		fail("websocket.maxMessageSize", "must be positive")
+	}
+	if c.WebSocket.ReadTimeout < 0 {
+		fail("websocket.readTimeout", "must not be negative")
+	}
+	if c.WebSocket.WriteTimeout <= 0 {
+		fail("websocket.writeTimeout", "must be positive")
+	}
+	if c.WebSocket.SendQueueSize <= 0 {
+		fail("websocket.sendQueueSize", "must be positive")
+	}
+	if c.WebSocket.SlowConsumerPolicy != SlowConsumerDrop && c.WebSocket.SlowConsumerPolicy != SlowConsumerDisconnect {
+		fail("websocket.slowConsumerPolicy", "%q is not one of %s or %s", c.WebSocket.SlowConsumerPolicy, SlowConsumerDrop, SlowConsumerDisconnect)
+	}
+	if c.WebSocket.PingInterval < 0 {
+		fail("websocket.pingInterval", "must not be negative")
+	}
+	if c.WebSocket.PingInterval > 0 && c.WebSocket.ReadTimeout > 0 && c.WebSocket.PingInterval >= c.WebSocket.ReadTimeout {
+		fail("websocket.pingInterval", "must be shorter than websocket.readTimeout so pongs arrive in time")
+	}
+	for _, origin := range c.WebSocket.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			fail("websocket.allowedOrigins", "%q is not an origin such as https://example.com or https://*.example.com", origin)
+		}
+	}
+
+	if c.Matchmaking.Timeout <= 0 {
+		fail("matchmaking.timeout", "must be positive")
+	}
+	switch c.Matchmaking.Seating {
+	case SeatingAlternate, SeatingRandom, SeatingBalanced:
+	default:
+		fail("matchmaking.seating", "%q is not one of %s, %s or %s", c.Matchmaking.Seating, SeatingAlternate, SeatingRandom, SeatingBalanced)
+	}
+
+	if c.Abandonment.GracePeriod < 0 {
+		fail("abandonment.gracePeriod", "must not be negative")
+	}
+	if c.Abandonment.MoveTimeout < 0 {
+		fail("abandonment.moveTimeout", "must not be negative")
+	}
+	for _, cooldown := range c.Abandonment.Cooldowns {
+		if cooldown < 0 {
+			fail("abandonment.cooldowns", "must not be negative")
+			break
+		}
+	}
+	if c.Abandonment.ForgiveAfter < 0 {
+		fail("abandonment.forgiveAfter", "must not be negative")
+	}
+
+	for _, b := range []struct {
+		field  string
+		bucket Bucket
+	}{
+		{"rateLimit.connectionsPerIp", c.RateLimit.ConnectionsPerIP},
+		{"rateLimit.packets", c.RateLimit.Packets},
+		{"rateLimit.chat", c.RateLimit.Chat},
+		{"rateLimit.moves", c.RateLimit.Moves},
+		{"rateLimit.matchmaking", c.RateLimit.Matchmaking},
+	} {
+		if b.bucket.Rate < 0 {
+			fail(b.field+".rate", "must not be negative")
+		}
+		if b.bucket.Rate > 0 && b.bucket.Burst < 1 {
+			fail(b.field+".burst", "must be at least 1 when rate is set")
+		}
+	}
+	if c.RateLimit.MaxViolations < 0 {
+		fail("rateLimit.maxViolations", "must not be negative")
+	}
+
+	switch c.Backplane.Driver {
+	case BackplaneMemory:
+	case BackplaneRedis:
+		if c.Backplane.Addr == "" {
+			fail("backplane.addr", "is required by the %s backplane", BackplaneRedis)
+		}
+	default:
+		fail("backplane.driver", "%q is not one of %s or %s", c.Backplane.Driver, BackplaneMemory, BackplaneRedis)
+	}
+	if c.Backplane.PresenceTTL <= 0 {
+		fail("backplane.presenceTtl", "must be positive")
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		fail("log.level", "%q is not one of debug, info, warn or error", c.Log.Level)
+	}
+	if err := level.UnmarshalText([]byte(c.Log.PacketLevel)); err != nil {
+		fail("log.packetLevel", "%q is not one of debug, info, warn or error", c.Log.PacketLevel)
+	}
+
+	return errors.Join(errs...)
+}
+
+// LogLevel returns the parsed minimum log level. It must only be called on a validated Config.
+func (c *Config) LogLevel() slog.Level {
+	var level slog.Level
+	level.UnmarshalText([]byte(c.Log.Level))
+	return level
+}
+
+// PacketLogLevel returns the parsed packet log level. It must only be called on a validated Config.
+func (c *Config) PacketLogLevel() slog.Level {
+	var level slog.Level
+	level.UnmarshalText([]byte(c.Log.PacketLevel))
+	return level
+}
+
+func parseDuration(d *Duration, value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func parseDurations(d *[]Duration, value string) error {
+	var parsed []Duration
+	for _, item := range splitList(value) {
+		var duration Duration
+		if err := parseDuration(&duration, item); err != nil {
+			return err
+		}
+		parsed = append(parsed, duration)
+	}
+	*d = parsed
+	return nil
+}
+
+func parseBucket(b *Bucket, value string) error {
+	rate, burst, ok := strings.Cut(value, ":")
+	if !ok {
+		return fmt.Errorf("%q is not of the form rate:burst", value)
+	}
+	parsedRate, err := strconv.ParseFloat(rate, 64)
+	if err != nil {
+		return err
+	}
+	parsedBurst, err := strconv.Atoi(burst)
+	if err != nil {
+		return err
+	}
+	*b = Bucket{Rate: parsedRate, Burst: parsedBurst}
+	return nil
+}
+
+func parseInt(i *int, value string) error {
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	*i = parsed
+	return nil
+}
+
+func parseBool(b *bool, value string) error {
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	*b = parsed
+	return nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
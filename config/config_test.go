@@ -0,0 +1,99 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	file := `{
+		"server": {"addr": ":9000"},
+		"matchmaking": {"timeout": "45s"},
+		"log": {"level": "warn"}
+	}`
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{
+		"TICTACTOE_CONFIG":              path,
+		"TICTACTOE_MATCHMAKING_TIMEOUT": "60s",
+		"TICTACTOE_LOG_LEVEL":           "debug",
+		"TICTACTOE_RATELIMIT_CHAT":      "0.5:3",
+		"TICTACTOE_ABANDON_COOLDOWNS":   "10s, 1m",
+	}
+	lookupEnv := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+
+	cfg, err := Load([]string{"-log-level", "error", "-shutdown-wait-games"}, lookupEnv)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if cfg.Server.Addr != ":9000" {
+		t.Errorf("Expected addr from file, got %q", cfg.Server.Addr)
+	}
+	if time.Duration(cfg.Matchmaking.Timeout) != 60*time.Second {
+		t.Errorf("Expected matchmaking timeout from env, got %v", time.Duration(cfg.Matchmaking.Timeout))
+	}
+	if cfg.Log.Level != "error" {
+		t.Errorf("Expected log level from flag, got %q", cfg.Log.Level)
+	}
+	if !cfg.Server.ShutdownWaitGames {
+		t.Error("Expected bare boolean flag to enable shutdownWaitGames")
+	}
+	if cfg.RateLimit.Chat != (Bucket{Rate: 0.5, Burst: 3}) {
+		t.Errorf("Expected chat rate limit from env, got %+v", cfg.RateLimit.Chat)
+	}
+	if cooldowns := cfg.Abandonment.Cooldowns; len(cooldowns) != 2 || cooldowns[0] != Duration(10*time.Second) || cooldowns[1] != Duration(time.Minute) {
+		t.Errorf("Expected abandonment cooldowns from env, got %v", cooldowns)
+	}
+	if time.Duration(cfg.Server.ShutdownTimeout) != 30*time.Second {
+		t.Errorf("Expected default shutdown timeout, got %v", time.Duration(cfg.Server.ShutdownTimeout))
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	cfg := Default()
+	cfg.Server.Addr = ""
+	cfg.TLS.CertFile = "cert.pem"
+	cfg.WebSocket.AllowedOrigins = []string{"example.com"}
+	cfg.Log.Level = "loud"
+	cfg.RateLimit.Moves = Bucket{Rate: 1}
+	cfg.Backplane.Driver = BackplaneRedis
+	cfg.Abandonment.Cooldowns = []Duration{Duration(-time.Minute)}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected validation to fail")
+	}
+	for _, want := range []string{"server.addr", "tls: certFile and keyFile", "websocket.allowedOrigins", "log.level", "rateLimit.moves.burst", "backplane.addr", "abandonment.cooldowns"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %q, got:\n%v", want, err)
+		}
+	}
+}
+
+func TestLoadRejectsUnknownFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"server": {"port": 8080}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	noEnv := func(string) (string, bool) { return "", false }
+	if _, err := Load([]string{"-config", path}, noEnv); err == nil || !strings.Contains(err.Error(), "port") {
+		t.Errorf("Expected an unknown field error, got %v", err)
+	}
+}
+
+func TestExampleConfigLoads(t *testing.T) {
+	noEnv := func(string) (string, bool) { return "", false }
+	if _, err := Load([]string{"-config", "../config.example.json"}, noEnv); err != nil {
+		t.Errorf("Example config does not load: %v", err)
+	}
+}
@@ -1,29 +1,139 @@
 package main
 
 import (
-	"log"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"tictactoe/backplane"
+	"tictactoe/config"
 	"tictactoe/managers"
+	"tictactoe/metrics"
+	"tictactoe/utils"
+	"time"
 )
 
 func main() {
+	// Load settings from the config file, environment and flags
+	cfg, err := config.Load(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%s\n", err)
+		os.Exit(2)
+	}
+
+	// Setup structured logging
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.LogLevel()})))
+
 	// Initialize managers
 	userManager := managers.NewUserManager()
 	gameManager := managers.NewGameManager()
+	gameManager.Configure(cfg.Matchmaking)
 	matchmakingManager := managers.NewMatchmakingManager()
+	matchmakingManager.Configure(cfg.Matchmaking)
+	moderationManager := managers.NewModerationManager()
+	if cfg.Moderation.AuditLogPath != "" {
+		auditLog, err := os.OpenFile(cfg.Moderation.AuditLogPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			slog.Error("Failed to open moderation audit log", "path", cfg.Moderation.AuditLogPath, "error", err)
+			os.Exit(1)
+		}
+		defer auditLog.Close()
+		moderationManager.SetAuditSink(auditLog)
+	}
 
 	// Initialize WebSocketManager with references to other managers
-	websocketManager := managers.NewWebSocketManager(userManager, gameManager, matchmakingManager)
+	websocketManager := managers.NewWebSocketManager(userManager, gameManager, matchmakingManager, moderationManager)
+	websocketManager.Configure(cfg.WebSocket)
+	websocketManager.ConfigureRateLimits(cfg.RateLimit)
+	websocketManager.ConfigureAbandonment(cfg.Abandonment)
+	websocketManager.SetPacketLogLevel(cfg.PacketLogLevel())
+
+	// Share presence, matchmaking and game events with the other nodes
+	var bp backplane.Backplane = backplane.NewMemory()
+	if cfg.Backplane.Driver == config.BackplaneRedis {
+		bp = backplane.NewRedis(cfg.Backplane.Addr)
+	}
+	if err := websocketManager.SetBackplane(bp, cfg.Backplane); err != nil {
+		slog.Error("Failed to connect to the backplane", "driver", cfg.Backplane.Driver, "error", err)
+		os.Exit(1)
+	}
+
+	// Expose manager state as Prometheus metrics
+	managers.RegisterGauges(websocketManager, gameManager, matchmakingManager)
+	http.Handle("/metrics", metrics.Handler())
+
+	// Health and readiness probes
+	http.HandleFunc("/healthz", websocketManager.HandleHealthz)
+	http.HandleFunc("/readyz", websocketManager.HandleReadyz)
+
+	// REST API for clients that cannot keep a WebSocket open
+	api := managers.NewAPI(websocketManager)
+	api.Configure(cfg.Moderation)
+	http.Handle(managers.APIPrefix, api)
 
 	// Setup WebSocket handler
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		websocketManager.HandleWebSocket(w, r)
 	})
 
+	// Fallback transports for networks that block WebSocket upgrades
+	http.HandleFunc("/sse", websocketManager.HandleSSE)
+	http.HandleFunc("/poll", websocketManager.HandleLongPoll)
+	http.HandleFunc("/send", websocketManager.HandleSend)
+
+	server := &http.Server{
+		Addr:              cfg.Server.Addr,
+		ReadHeaderTimeout: time.Duration(cfg.Server.ReadHeaderTimeout),
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	// Serve TLS with a certificate that is reloaded when its files change
+	if cfg.TLS.Enabled() {
+		certReloader, err := utils.NewCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			slog.Error("Failed to load TLS certificate", "error", err)
+			os.Exit(1)
+		}
+		go certReloader.Watch(ctx, time.Duration(cfg.TLS.ReloadInterval))
+		server.TLSConfig = &tls.Config{
+			GetCertificate: certReloader.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+	}
+
 	// Start listening for WebSocket connections
-	log.Println("WebSocket server starting on :8080") // Log before starting the server
-	err := http.ListenAndServe(":8080", nil)          // Start the server
-	if err != nil {
-		log.Fatalf("Failed to start WebSocket server: %s", err) // This will log only if there's an error starting the server
+	go func() {
+		slog.Info("WebSocket server starting", "addr", server.Addr, "tls", cfg.TLS.Enabled()) // Log before starting the server
+		var err error
+		if cfg.TLS.Enabled() {
+			err = server.ListenAndServeTLS("", "") // Certificates come from TLSConfig
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Failed to start WebSocket server", "error", err) // This will log only if there's an error starting the server
+			os.Exit(1)
+		}
+	}()
+
+	// Wait for a termination signal, then shut down gracefully
+	<-ctx.Done()
+	stop()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeout))
+	defer cancel()
+
+	websocketManager.Shutdown(shutdownCtx, cfg.Server.ShutdownWaitGames)
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("HTTP server shutdown failed", "error", err)
 	}
 }
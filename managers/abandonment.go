@@ -0,0 +1,178 @@
+package managers
+
+import (
+	"fmt"
+	"sync"
+	"tictactoe/config"
+	"tictactoe/models"
+	"time"
+)
+
+// abandonmentPolicy decides when a player who left a game or stopped moving forfeits it, and how
+// long repeat abandoners wait before matchmaking again. Cooldowns follow the streaks kept by the
+// UserManager, so they are only enforced on the node where the abandonments were recorded.
+type abandonmentPolicy struct {
+	gracePeriod  time.Duration
+	cooldowns    []time.Duration
+	forgiveAfter time.Duration
+}
+
+// graceTimers holds the pending grace period of every user who left an in-progress game, so a user
+// who comes back and leaves again has one grace period running, started when they last left.
+type graceTimers struct {
+	timers map[string]*time.Timer // Keyed by username
+	mu     sync.Mutex
+}
+
+func newGraceTimers() *graceTimers {
+	return &graceTimers{timers: make(map[string]*time.Timer)}
+}
+
+// start replaces the user's pending grace period, if any, with one that calls f once d has passed.
+func (g *graceTimers) start(username string, d time.Duration, f func()) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if pending := g.timers[username]; pending != nil {
+		pending.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		g.mu.Lock()
+		current := g.timers[username] == timer
+		if current {
+			delete(g.timers, username)
+		}
+		g.mu.Unlock()
+		if current {
+			f()
+		}
+	})
+	g.timers[username] = timer
+}
+
+// stop cancels the user's pending grace period, if any.
+func (g *graceTimers) stop(username string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if pending := g.timers[username]; pending != nil {
+		pending.Stop()
+		delete(g.timers, username)
+	}
+}
+
+// stopAll cancels every pending grace period.
+func (g *graceTimers) stopAll() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for username, pending := range g.timers {
+		pending.Stop()
+		delete(g.timers, username)
+	}
+}
+
+// cooldownError is returned when a user who abandoned recent games asks for a match too soon. It
+// unwraps to models.ErrCoolingDown.
+type cooldownError struct {
+	retryAfter time.Duration
+}
+
+func (e *cooldownError) Error() string {
+	return fmt.Sprintf("matchmaking cooldown, retry after %v", e.retryAfter)
+}
+
+func (e *cooldownError) Unwrap() error { return models.ErrCoolingDown }
+
+// ConfigureAbandonment applies the abandonment policy. It must be called before games start.
+func (wsm *WebSocketManager) ConfigureAbandonment(cfg config.Abandonment) {
+	policy := &abandonmentPolicy{
+		gracePeriod:  time.Duration(cfg.GracePeriod),
+		forgiveAfter: time.Duration(cfg.ForgiveAfter),
+	}
+	for _, cooldown := range cfg.Cooldowns {
+		policy.cooldowns = append(policy.cooldowns, time.Duration(cooldown))
+	}
+	wsm.abandonment = policy
+	wsm.gameManager.setMoveTimeout(time.Duration(cfg.MoveTimeout), wsm.handleMoveTimeout)
+}
+
+// cooldown returns how long a user must wait after their streak-th abandonment in a row.
+func (p *abandonmentPolicy) cooldown(streak int) time.Duration {
+	if streak <= 0 || len(p.cooldowns) == 0 {
+		return 0
+	}
+	return p.cooldowns[min(streak, len(p.cooldowns))-1]
+}
+
+// matchmakingCooldown returns how long the user must still wait before matchmaking, or 0.
+func (wsm *WebSocketManager) matchmakingCooldown(user *models.User, now time.Time) time.Duration {
+	streak, last := wsm.userManager.AbandonStreak(user.Username)
+	if streak == 0 {
+		return 0
+	}
+	if forgive := wsm.abandonment.forgiveAfter; forgive > 0 && now.Sub(last) > forgive {
+		return 0
+	}
+	return max(0, last.Add(wsm.abandonment.cooldown(streak)).Sub(now))
+}
+
+// abandonActiveGame starts the grace period of a user whose last session went away, after which
+// they forfeit their in-progress game so the opponent is not left waiting for a move that will
+// never come. It does nothing if the user still has, or has since opened, another session, or if
+// the session went away because the server is shutting down.
+func (wsm *WebSocketManager) abandonActiveGame(user *models.User) {
+	if len(user.Connections()) > 0 || wsm.shuttingDown.Load() {
+		return
+	}
+	active := wsm.gameManager.FindActiveGame(user)
+	if active == nil || wsm.cluster.presentElsewhere(user.Username) {
+		return // Not playing, or still connected to another node
+	}
+	if grace := wsm.abandonment.gracePeriod; grace > 0 {
+		wsm.logger.Info("player left game", "game_id", active.ID, "username", user.Username, "grace_period", grace)
+		wsm.leavers.start(user.Username, grace, func() { wsm.forfeitAbandoned(active.ID, user) })
+		return
+	}
+	wsm.forfeitAbandoned(active.ID, user)
+}
+
+// userReturned cancels the grace period of a user who opened a session after leaving their game.
+func (wsm *WebSocketManager) userReturned(user *models.User) {
+	wsm.leavers.stop(user.Username)
+}
+
+// forfeitAbandoned ends a game the user left, unless they have come back or the server is shutting
+// down.
+func (wsm *WebSocketManager) forfeitAbandoned(gameID string, user *models.User) {
+	if len(user.Connections()) > 0 || wsm.shuttingDown.Load() || wsm.cluster.presentElsewhere(user.Username) {
+		return
+	}
+	game, err := wsm.gameManager.AbandonGame(gameID, user)
+	if err != nil {
+		wsm.logger.Debug("failed to abandon game", "game_id", gameID, "username", user.Username, "error", err)
+		return
+	}
+	wsm.chargeAbandonment(game, user)
+}
+
+// handleMoveTimeout handles a game forfeited by a player who did not move in time. Running out of
+// a move time the players chose loses the game like any other; only players who went idle under
+// the server's move timeout are charged with abandoning it.
+func (wsm *WebSocketManager) handleMoveTimeout(game *models.Game, idle *models.User) {
+	if game.MoveTime > 0 {
+		wsm.notifyGameUpdate(game, nil, "")
+		return
+	}
+	wsm.chargeAbandonment(game, idle)
+}
+
+// chargeAbandonment charges the user with abandoning a game they forfeited by leaving or not
+// moving, and completes the game, which credits their opponent with the win.
+func (wsm *WebSocketManager) chargeAbandonment(game *models.Game, user *models.User) {
+	streak, err := wsm.userManager.RecordAbandonment(user.Username, time.Now(), wsm.abandonment.forgiveAfter)
+	if err != nil {
+		wsm.logger.Warn("failed to record abandonment", "game_id", game.ID, "username", user.Username, "error", err)
+	} else {
+		wsm.logger.Info("abandonment recorded", "game_id", game.ID, "username", user.Username, "streak", streak, "cooldown", wsm.abandonment.cooldown(streak))
+	}
+	wsm.notifyGameUpdate(game, nil, "")
+}
@@ -0,0 +1,196 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"tictactoe/backplane"
+	"tictactoe/config"
+	"tictactoe/models"
+	"tictactoe/utils"
+)
+
+// completions returns a channel told about every game wsm completes.
+func completions(wsm *WebSocketManager) <-chan *models.Game {
+	completed := make(chan *models.Game, 1)
+	wsm.OnGameCompleted(func(game *models.Game) { completed <- game })
+	return completed
+}
+
+// waitForCompletion waits for a game to be completed and returns it.
+func waitForCompletion(t *testing.T, completed <-chan *models.Game) *models.Game {
+	t.Helper()
+	select {
+	case game := <-completed:
+		return game
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the game to be completed")
+		return nil
+	}
+}
+
+func TestLeaverForfeitsAfterGracePeriod(t *testing.T) {
+	wsm := newTestManager()
+	wsm.ConfigureAbandonment(config.Abandonment{GracePeriod: config.Duration(20 * time.Millisecond)})
+	completed := completions(wsm)
+	alice := wsm.userManager.CreateUser("alice", "")
+	bob := wsm.userManager.CreateUser("bob", "")
+	game, _ := wsm.gameManager.CreateGame(alice, bob, models.GameOptions{})
+
+	// Coming back within the grace period keeps the game going
+	wsm.abandonActiveGame(alice)
+	alice.AddConnection(&fakeConn{})
+	time.Sleep(50 * time.Millisecond)
+	if game, _ := wsm.gameManager.GetGame(game.ID); game.Status != utils.GameStateInProgress {
+		t.Fatalf("Expected the game to continue after alice came back, got %s", game.Status)
+	}
+
+	alice.RemoveConnection(alice.Connections()[0])
+	wsm.abandonActiveGame(alice)
+	if game := waitForCompletion(t, completed); game.Winner != "bob" {
+		t.Errorf("Expected bob to win the abandoned game, got %q", game.Winner)
+	}
+	if alice.Stats.Losses != 1 || alice.Stats.Abandonments != 1 || bob.Stats.Wins != 1 {
+		t.Errorf("Expected alice charged with a loss and an abandonment and bob credited with a win, got %+v and %+v", alice.Stats, bob.Stats)
+	}
+}
+
+func TestLeavingAgainRestartsGracePeriod(t *testing.T) {
+	wsm := newTestManager()
+	wsm.ConfigureAbandonment(config.Abandonment{GracePeriod: config.Duration(300 * time.Millisecond)})
+	completed := completions(wsm)
+	alice := wsm.userManager.CreateUser("alice", "")
+	bob := wsm.userManager.CreateUser("bob", "")
+	game, _ := wsm.gameManager.CreateGame(alice, bob, models.GameOptions{})
+
+	// connectAlice opens a session for alice and returns a function that closes it
+	connectAlice := func() func() {
+		conn, server := createWebSocketConnection(t, wsm)
+		conn.WriteJSON(connectPacket("alice"))
+		readPacketOfType(t, conn, utils.UserStatsPacketType)
+		return func() {
+			conn.Close()
+			server.Close()
+			for deadline := time.Now().Add(time.Second); len(alice.Connections()) > 0; time.Sleep(5 * time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatal("Timed out waiting for alice's session to close")
+				}
+			}
+		}
+	}
+
+	// Alice leaves, comes back within the grace period and leaves again
+	connectAlice()()
+	left := time.Now()
+	time.Sleep(100 * time.Millisecond)
+	leave := connectAlice()
+	time.Sleep(100 * time.Millisecond)
+	leave()
+
+	// The first grace period would have run out by now, but only the second one counts
+	time.Sleep(time.Until(left.Add(400 * time.Millisecond)))
+	if game, _ := wsm.gameManager.GetGame(game.ID); game.Status != utils.GameStateInProgress {
+		t.Fatalf("Expected the game to continue until the second grace period runs out, got %s", game.Status)
+	}
+	if game := waitForCompletion(t, completed); game.Winner != "bob" {
+		t.Errorf("Expected bob to win the abandoned game, got %q", game.Winner)
+	}
+}
+
+func TestIdlePlayerForfeitsAndCoolsDown(t *testing.T) {
+	wsm := newTestManager()
+	wsm.ConfigureAbandonment(config.Abandonment{
+		MoveTimeout: config.Duration(20 * time.Millisecond),
+		Cooldowns:   []config.Duration{config.Duration(time.Minute), config.Duration(time.Hour)},
+	})
+	completed := completions(wsm)
+	alice := wsm.userManager.CreateUser("alice", "")
+	bob := wsm.userManager.CreateUser("bob", "")
+	game, _ := wsm.gameManager.CreateGame(alice, bob, models.GameOptions{})
+
+	// Alice moves in time, then Bob never does
+	if _, _, err := wsm.gameManager.UpdateGame(game.ID, alice, 1, 1, nil); err != nil {
+		t.Fatalf("Expected alice's move to succeed, got %v", err)
+	}
+	if game := waitForCompletion(t, completed); game.Winner != "alice" || game.Ply() != 1 {
+		t.Errorf("Expected alice to win after one move, got %q at ply %d", game.Winner, game.Ply())
+	}
+	if streak, _ := wsm.userManager.AbandonStreak("alice"); streak != 0 {
+		t.Errorf("Expected alice not to be charged, got a streak of %d", streak)
+	}
+
+	// Bob must wait out the first cooldown, and a second abandonment makes it longer
+	req, conn := newTestRequest()
+	req.user = bob
+	err := wsm.handlePlay(req, &models.PlayPacket{Username: "bob"})
+	var cooldownErr *cooldownError
+	if !errors.As(err, &cooldownErr) || cooldownErr.retryAfter <= 0 || cooldownErr.retryAfter > time.Minute {
+		t.Fatalf("Expected bob to cool down for up to a minute, got %v", err)
+	}
+	wsm.replyWithError(req, utils.PlayPacketType, err)
+	if reply := conn.packets[0].(models.ErrorPacket); reply.Code != models.ErrorCodeCoolingDown || reply.RetryAfterMs <= 0 {
+		t.Errorf("Expected a cooldown error with a retry delay, got %+v", reply)
+	}
+	wsm.userManager.RecordAbandonment("bob", time.Now(), 0)
+	if retryAfter := wsm.matchmakingCooldown(bob, time.Now()); retryAfter <= time.Minute {
+		t.Errorf("Expected a longer cooldown after a second abandonment, got %v", retryAfter)
+	}
+}
+
+func TestDisconnectOutsideGameIsNotALoss(t *testing.T) {
+	bp := sharedBackplane{backplane.NewMemory()}
+	wsm, conn := newTestNode(t, bp, "node-a", "alice")
+	conn.Close()
+	for deadline := time.Now().Add(time.Second); wsm.ClientCount() > 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for alice to disconnect")
+		}
+	}
+	if alice, _ := wsm.userManager.GetUser("alice"); alice.Stats != (models.UserStats{}) {
+		t.Errorf("Expected no stats change for leaving outside a game, got %+v", alice.Stats)
+	}
+}
+
+func TestShutdownDoesNotChargeAbandonment(t *testing.T) {
+	wsm := newTestManager()
+	wsm.ConfigureAbandonment(config.Abandonment{}) // Forfeit as soon as a player leaves
+	conn, server := createWebSocketConnection(t, wsm)
+	defer server.Close()
+	conn.WriteJSON(connectPacket("alice"))
+	readPacketOfType(t, conn, utils.UserStatsPacketType)
+	alice, _ := wsm.userManager.GetUser("alice")
+	game, _ := wsm.gameManager.CreateGame(alice, wsm.userManager.CreateUser("bob", ""), models.GameOptions{})
+
+	// Closing alice's session on the way down is not her leaving the game
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	wsm.Shutdown(ctx, false)
+	for deadline := time.Now().Add(time.Second); len(alice.Connections()) > 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for alice's session to close")
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if game, _ := wsm.gameManager.GetGame(game.ID); game.Status != utils.GameStateInProgress || alice.Stats.Abandonments != 0 {
+		t.Errorf("Expected the game to be left alone at shutdown, got %s with %d abandonments", game.Status, alice.Stats.Abandonments)
+	}
+}
+
+func TestShutdownStopsGracePeriods(t *testing.T) {
+	wsm := newTestManager()
+	wsm.ConfigureAbandonment(config.Abandonment{GracePeriod: config.Duration(time.Minute)})
+	alice := wsm.userManager.CreateUser("alice", "")
+	wsm.gameManager.CreateGame(alice, wsm.userManager.CreateUser("bob", ""), models.GameOptions{})
+	wsm.abandonActiveGame(alice)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	wsm.Shutdown(ctx, false)
+	wsm.leavers.mu.Lock()
+	defer wsm.leavers.mu.Unlock()
+	if pending := len(wsm.leavers.timers); pending != 0 {
+		t.Errorf("Expected shutdown to stop every grace period, got %d pending", pending)
+	}
+}
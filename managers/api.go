@@ -0,0 +1,497 @@
+package managers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"tictactoe/codec"
+	"tictactoe/config"
+	"tictactoe/models"
+	"tictactoe/utils"
+	"time"
+)
+
+// APIPrefix is the path the REST API is served under.
+const APIPrefix = "/api/v1/"
+
+// apiSessionTTL is how long a session token from register or login stays valid.
+const apiSessionTTL = 24 * time.Hour
+
+// Leaderboard sizes for the limit query parameter.
+const (
+	defaultLeaderboardSize = 10
+	maxLeaderboardSize     = 100
+)
+
+// API serves a JSON REST API over the same managers as the WebSocket protocol, for tools that
+// cannot keep a WebSocket open. Moves made over the API are pushed to WebSocket-connected players
+// exactly like moves made over a WebSocket.
+type API struct {
+	wsm           *WebSocketManager
+	sessions      map[string]apiSession // Keyed by token
+	operatorToken string                // Authenticates the moderation endpoints, which are not served without it
+	logger        *slog.Logger
+	mu            sync.Mutex // Protects sessions
+}
+
+// apiSession is the user a session token authenticates.
+type apiSession struct {
+	user      *models.User
+	expiresAt time.Time
+}
+
+// apiHandler serves one route. It returns the status and body of a successful response.
+type apiHandler func(r *http.Request) (int, any, error)
+
+// NewAPI creates the REST API for the managers behind wsm.
+func NewAPI(wsm *WebSocketManager) *API {
+	return &API{
+		wsm:      wsm,
+		sessions: make(map[string]apiSession),
+		logger:   wsm.logger.With("component", "api"),
+	}
+}
+
+// Configure applies moderation settings. It must be called before the API serves requests.
+func (a *API) Configure(cfg config.Moderation) {
+	a.operatorToken = cfg.OperatorToken
+}
+
+// ServeHTTP routes a request under APIPrefix to its handler and writes the JSON response.
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, APIPrefix), "/"), "/")
+	route, method, handle := a.route(path)
+
+	status, body, err := 0, any(nil), error(nil)
+	switch {
+	case handle == nil:
+		route, err = "unknown", models.ErrNotFound
+	case r.Method != method:
+		w.Header().Set("Allow", method)
+		err = models.ErrMethodNotAllowed
+	default:
+		status, body, err = handle(r)
+	}
+	if err != nil {
+		status, body = a.errorResponse(w, r, err)
+	}
+	apiRequests.Inc(route, strconv.Itoa(status))
+
+	if body == nil {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		a.logger.Debug("failed to write response", "path", r.URL.Path, "error", err)
+	}
+}
+
+// route returns the metric label, method and handler for a path, or a nil handler if none matches.
+func (a *API) route(path []string) (string, string, apiHandler) {
+	switch {
+	case len(path) == 1 && path[0] == "register":
+		return "register", http.MethodPost, a.handleRegister
+	case len(path) == 1 && path[0] == "login":
+		return "login", http.MethodPost, a.handleLogin
+	case len(path) == 1 && path[0] == "leaderboard":
+		return "leaderboard", http.MethodGet, a.handleLeaderboard
+	case len(path) == 1 && path[0] == "games":
+		return "listGames", http.MethodGet, a.handleListGames
+	case len(path) == 2 && path[0] == "games":
+		return "getGame", http.MethodGet, func(r *http.Request) (int, any, error) {
+			return a.handleGetGame(r, path[1])
+		}
+	case len(path) == 3 && path[0] == "games" && path[2] == "moves":
+		return "submitMove", http.MethodPost, func(r *http.Request) (int, any, error) {
+			return a.handleSubmitMove(r, path[1])
+		}
+	case len(path) == 3 && path[0] == "users" && path[2] == "stats":
+		return "userStats", http.MethodGet, func(r *http.Request) (int, any, error) {
+			return a.handleUserStats(r, path[1])
+		}
+	case a.operatorToken == "":
+		// Without a token nobody can be trusted with the moderation endpoints
+	case len(path) == 2 && path[0] == "moderation" && path[1] == "sanctions":
+		return "issueSanction", http.MethodPost, a.handleIssueSanction
+	case len(path) == 3 && path[0] == "moderation" && path[1] == "sanctions" && path[2] == "lift":
+		return "liftSanction", http.MethodPost, a.handleLiftSanction
+	}
+	return "", "", nil
+}
+
+// handleRegister creates a user and starts a session for it.
+func (a *API) handleRegister(r *http.Request) (int, any, error) {
+	var req models.CredentialsRequest
+	if err := a.startSession(r, &req); err != nil {
+		return 0, nil, err
+	}
+	user, err := a.wsm.userManager.RegisterUser(req.Username, req.DeviceID)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusCreated, a.newSession(user), nil
+}
+
+// handleLogin starts a session for an existing user. The device ID must match the one the user
+// registered or first connected with, and cannot be empty.
+func (a *API) handleLogin(r *http.Request) (int, any, error) {
+	var req models.CredentialsRequest
+	if err := a.startSession(r, &req); err != nil {
+		return 0, nil, err
+	}
+	user, err := a.wsm.userManager.GetUser(req.Username)
+	if err != nil || user.DeviceID != req.DeviceID {
+		return 0, nil, models.ErrUnauthorized
+	}
+	return http.StatusOK, a.newSession(user), nil
+}
+
+// startSession decodes a register or login request after applying the per-IP connection budget
+// and the server's sanctions.
+func (a *API) startSession(r *http.Request, req *models.CredentialsRequest) error {
+	if a.wsm.shuttingDown.Load() {
+		return models.ErrShuttingDown
+	}
+	if ok, retryAfter := a.wsm.rateLimits.connections.allow(remoteIP(r.RemoteAddr), time.Now()); !ok {
+		return &rateLimitedError{scope: "connect", retryAfter: retryAfter}
+	}
+	if err := a.decode(r, req); err != nil {
+		return err
+	}
+	if sanction := a.wsm.moderationManager.CheckAccess(req.Username, req.DeviceID); sanction != nil {
+		a.logger.Warn("rejected sanctioned user", "username", req.Username, "device_id", req.DeviceID, "kind", sanction.Kind)
+		return models.ErrBanned
+	}
+	return nil
+}
+
+// handleUserStats returns a user's game statistics.
+func (a *API) handleUserStats(r *http.Request, username string) (int, any, error) {
+	stats, err := a.wsm.userManager.Stats(username)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, newUserStatsResponse(username, stats), nil
+}
+
+// handleLeaderboard returns the users with the most wins.
+func (a *API) handleLeaderboard(r *http.Request) (int, any, error) {
+	limit := defaultLeaderboardSize
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxLeaderboardSize {
+			var v models.ValidationError
+			v.Add("limit", "must be between 1 and %d", maxLeaderboardSize)
+			return 0, nil, &v
+		}
+		limit = n
+	}
+
+	response := models.LeaderboardResponse{Entries: []models.LeaderboardEntry{}}
+	for i, standing := range a.wsm.userManager.Leaderboard(limit) {
+		response.Entries = append(response.Entries, models.LeaderboardEntry{Rank: i + 1, UserStatsResponse: newUserStatsResponse(standing.Username, standing.Stats)})
+	}
+	return http.StatusOK, response, nil
+}
+
+// handleListGames returns the games matching the status and player query parameters.
+func (a *API) handleListGames(r *http.Request) (int, any, error) {
+	status := r.URL.Query().Get("status")
+	if status != "" && status != utils.GameStateInProgress && status != utils.GameStateCompleted {
+		var v models.ValidationError
+		v.Add("status", "must be %s or %s", utils.GameStateInProgress, utils.GameStateCompleted)
+		return 0, nil, &v
+	}
+
+	response := models.GameListResponse{Games: []models.GameResponse{}}
+	for _, game := range a.wsm.gameManager.ListGames(status, r.URL.Query().Get("player")) {
+		response.Games = append(response.Games, newGameResponse(game))
+	}
+	return http.StatusOK, response, nil
+}
+
+// handleGetGame returns a game's state.
+func (a *API) handleGetGame(r *http.Request, gameID string) (int, any, error) {
+	game, err := a.wsm.gameManager.GetGame(gameID)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusOK, newGameResponse(game), nil
+}
+
+// handleSubmitMove applies a move for the session's user and pushes the new state to both players.
+// A move in a game another node hosts is forwarded there and answered with 202 Accepted; the new
+// state then arrives on the user's sessions.
+func (a *API) handleSubmitMove(r *http.Request, gameID string) (int, any, error) {
+	user, err := a.authenticate(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := a.wsm.rateLimits.allowPacket(utils.MovePacketType, "user:"+user.Username, time.Now()); err != nil {
+		return 0, nil, err
+	}
+	var req models.MoveRequest
+	if err := a.decode(r, &req); err != nil {
+		return 0, nil, err
+	}
+
+	game, replayed, err := a.wsm.gameManager.UpdateGame(gameID, user, req.Row, req.Col, req.Ply)
+	if errors.Is(err, models.ErrGameNotFound) {
+		// Another node may host the game; it sends the new state to the user's sessions
+		packet := models.MovePacket{BasePacket: models.BasePacket{Type: utils.MovePacketType}, GameID: gameID, Row: req.Row, Col: req.Col, Ply: req.Ply}
+		forward := &packetRequest{user: user, logger: a.logger.With("username", user.Username)}
+		if a.wsm.cluster.forward(forward, gameID, packet) {
+			return http.StatusAccepted, nil, nil
+		}
+	}
+	if err != nil {
+		a.logger.Debug("move rejected", "game_id", gameID, "username", user.Username, "error", err)
+		return 0, nil, err
+	}
+	if !replayed {
+		// Tell both players, including any WebSocket the mover has open
+		a.wsm.notifyGameUpdate(game, user, "")
+	}
+	return http.StatusOK, newGameResponse(game), nil
+}
+
+// newSession issues a session token for user. Expired sessions are forgotten at the same time.
+func (a *API) newSession(user *models.User) models.SessionResponse {
+	now := time.Now()
+	session := apiSession{user: user, expiresAt: now.Add(apiSessionTTL)}
+	token := utils.GenerateSessionToken()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for t, s := range a.sessions {
+		if now.After(s.expiresAt) {
+			delete(a.sessions, t)
+		}
+	}
+	a.sessions[token] = session
+	return models.SessionResponse{Username: user.Username, Token: token, ExpiresAt: session.expiresAt}
+}
+
+// handleIssueSanction bans, suspends or mutes a username or device on behalf of an operator.
+func (a *API) handleIssueSanction(r *http.Request) (int, any, error) {
+	if err := a.authenticateOperator(r); err != nil {
+		return 0, nil, err
+	}
+	var req models.SanctionRequest
+	if err := a.decode(r, &req); err != nil {
+		return 0, nil, err
+	}
+
+	moderation := a.wsm.moderationManager
+	duration := time.Duration(req.DurationMs) * time.Millisecond
+	var sanction *models.Sanction
+	var err error
+	switch req.Kind {
+	case models.SanctionBan:
+		sanction, err = moderation.Ban(req.Actor, req.SubjectType, req.Subject, req.Reason, duration)
+	case models.SanctionSuspension:
+		sanction, err = moderation.Suspend(req.Actor, req.SubjectType, req.Subject, req.Reason, duration)
+	case models.SanctionMute:
+		sanction, err = moderation.Mute(req.Actor, req.SubjectType, req.Subject, req.Reason, duration)
+	}
+	if sanction == nil {
+		return 0, nil, err
+	}
+	if err != nil {
+		// The sanction applies, but the audit trail could not be written
+		a.logger.Error("failed to write moderation audit entry", "kind", req.Kind, "subject", req.Subject, "error", err)
+	}
+	return http.StatusCreated, newSanctionResponse(sanction), nil
+}
+
+// handleLiftSanction lifts a sanction on behalf of an operator.
+func (a *API) handleLiftSanction(r *http.Request) (int, any, error) {
+	if err := a.authenticateOperator(r); err != nil {
+		return 0, nil, err
+	}
+	var req models.LiftSanctionRequest
+	if err := a.decode(r, &req); err != nil {
+		return 0, nil, err
+	}
+	err := a.wsm.moderationManager.Lift(req.Actor, req.Kind, req.SubjectType, req.Subject, req.Reason)
+	if errors.Is(err, models.ErrSanctionNotFound) {
+		return 0, nil, err
+	}
+	if err != nil {
+		// The sanction is lifted, but the audit trail could not be written
+		a.logger.Error("failed to write moderation audit entry", "kind", req.Kind, "subject", req.Subject, "error", err)
+	}
+	return http.StatusNoContent, nil, nil
+}
+
+// authenticateOperator checks that the request carries the operator token as a bearer token.
+func (a *API) authenticateOperator(r *http.Request) error {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.operatorToken)) != 1 {
+		return models.ErrUnauthorized
+	}
+	return nil
+}
+
+// authenticate returns the user whose session token the request carries as a bearer token.
+func (a *API) authenticate(r *http.Request) (*models.User, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, models.ErrUnauthorized
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	session, ok := a.sessions[token]
+	if !ok {
+		return nil, models.ErrUnauthorized
+	}
+	if time.Now().After(session.expiresAt) {
+		delete(a.sessions, token)
+		return nil, models.ErrUnauthorized
+	}
+	return session.user, nil
+}
+
+// decode strictly decodes and validates a JSON request body, which may be no larger than the
+// largest WebSocket message.
+func (a *API) decode(r *http.Request, req models.Validator) error {
+	data, err := io.ReadAll(io.LimitReader(r.Body, a.wsm.maxMessageSize+1))
+	if err != nil || int64(len(data)) > a.wsm.maxMessageSize {
+		return models.ErrInvalidPacket
+	}
+	if err := codec.JSON.UnmarshalStrict(data, req); err != nil {
+		var validationErr *models.ValidationError
+		if errors.As(err, &validationErr) {
+			return validationErr
+		}
+		return models.ErrInvalidPacket
+	}
+	return req.Validate()
+}
+
+// errorResponse maps err to a status and ErrorResponse, as replyWithError does for packets.
+func (a *API) errorResponse(w http.ResponseWriter, r *http.Request, err error) (int, models.ErrorResponse) {
+	var limitedErr *rateLimitedError
+	if errors.As(err, &limitedErr) {
+		rateLimited.Inc(limitedErr.scope)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limitedErr.retryAfter.Seconds()))))
+		return http.StatusTooManyRequests, models.ErrorResponse{
+			Code:         models.ErrorCodeRateLimited,
+			Message:      models.ErrRateLimited.Message,
+			RetryAfterMs: (limitedErr.retryAfter + time.Millisecond - 1).Milliseconds(),
+		}
+	}
+	var validationErr *models.ValidationError
+	if errors.As(err, &validationErr) {
+		return http.StatusBadRequest, models.ErrorResponse{
+			Code:    models.ErrorCodeValidationFailed,
+			Message: "Invalid request",
+			Errors:  validationErr.Fields,
+		}
+	}
+	var apiErr *models.Error
+	if !errors.As(err, &apiErr) {
+		a.logger.Error("request failed", "path", r.URL.Path, "error", err)
+		apiErr = models.ErrInternal
+	}
+	return apiStatus(apiErr.Code), models.ErrorResponse{Code: apiErr.Code, Message: apiErr.Message}
+}
+
+// apiStatus returns the HTTP status reported for an error code.
+func apiStatus(code models.ErrorCode) int {
+	switch code {
+	case models.ErrorCodeInvalidPacket, models.ErrorCodeOutOfBounds:
+		return http.StatusBadRequest
+	case models.ErrorCodeUnauthorized:
+		return http.StatusUnauthorized
+	case models.ErrorCodeBanned, models.ErrorCodeNotInGame:
+		return http.StatusForbidden
+	case models.ErrorCodeNotFound, models.ErrorCodeUserNotFound, models.ErrorCodeGameNotFound, models.ErrorCodeSanctionNotFound:
+		return http.StatusNotFound
+	case models.ErrorCodeMethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	case models.ErrorCodeUserExists, models.ErrorCodeGameOver, models.ErrorCodeNotYourTurn,
+		models.ErrorCodeCellOccupied, models.ErrorCodeStaleMove:
+		return http.StatusConflict
+	case models.ErrorCodeShuttingDown:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// newUserStatsResponse builds the stats response from a copy of the user's stats taken under the
+// UserManager's lock.
+func newUserStatsResponse(username string, stats models.UserStats) models.UserStatsResponse {
+	return models.UserStatsResponse{
+		Username:     username,
+		Wins:         stats.Wins,
+		Losses:       stats.Losses,
+		Draws:        stats.Draws,
+		Abandonments: stats.Abandonments,
+		AsX:          newSymbolStatsResponse(stats.AsX),
+		AsO:          newSymbolStatsResponse(stats.AsO),
+	}
+}
+
+func newSymbolStatsResponse(stats models.SymbolStats) models.SymbolStatsResponse {
+	return models.SymbolStatsResponse{
+		Games:   stats.Games(),
+		Wins:    stats.Wins,
+		Losses:  stats.Losses,
+		Draws:   stats.Draws,
+		WinRate: stats.WinRate(),
+	}
+}
+
+// newGameResponse builds the game state as seen by an observer.
+func newGameResponse(game *models.Game) models.GameResponse {
+	response := models.GameResponse{
+		GameID:      game.ID,
+		Players:     make([]models.GamePlayer, len(game.Players)),
+		Board:       game.Board,
+		CurrentTurn: game.CurrentTurn,
+		Status:      game.Status,
+		Winner:      game.Winner,
+		Ply:         game.Ply(),
+		Moves:       make([]models.GameMove, len(game.Moves)),
+	}
+	for i, player := range game.Players {
+		// The first player plays X
+		symbol := "X"
+		if i == 1 {
+			symbol = "O"
+		}
+		response.Players[i] = models.GamePlayer{Username: player.Username, Symbol: symbol}
+	}
+	for i, move := range game.Moves {
+		response.Moves[i] = models.GameMove{Player: move.Player, Row: move.Row, Col: move.Col}
+	}
+	return response
+}
+
+// newSanctionResponse builds the response to an issued sanction.
+func newSanctionResponse(sanction *models.Sanction) models.SanctionResponse {
+	resp := models.SanctionResponse{
+		Kind:        sanction.Kind,
+		SubjectType: sanction.SubjectType,
+		Subject:     sanction.Subject,
+		Reason:      sanction.Reason,
+		IssuedBy:    sanction.IssuedBy,
+		IssuedAt:    sanction.IssuedAt,
+	}
+	if !sanction.IsPermanent() {
+		expiresAt := sanction.ExpiresAt
+		resp.ExpiresAt = &expiresAt
+	}
+	return resp
+}
@@ -0,0 +1,225 @@
+package managers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tictactoe/config"
+	"tictactoe/models"
+	"tictactoe/utils"
+)
+
+// doAPI sends a request to api and decodes the JSON response into out.
+func doAPI(t *testing.T, api *API, method, path, token, body string, out any) int {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	api.ServeHTTP(recorder, r)
+	if out != nil {
+		if err := json.Unmarshal(recorder.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: failed to decode response %q: %v", method, path, recorder.Body.String(), err)
+		}
+	}
+	return recorder.Code
+}
+
+func TestAPIRegisterAndLogin(t *testing.T) {
+	api := NewAPI(newTestManager())
+
+	var session models.SessionResponse
+	if code := doAPI(t, api, http.MethodPost, "/api/v1/register", "", `{"username":"alice","deviceId":"phone"}`, &session); code != http.StatusCreated || session.Token == "" {
+		t.Fatalf("Expected registration to return a token, got %d %+v", code, session)
+	}
+
+	var errResp models.ErrorResponse
+	if code := doAPI(t, api, http.MethodPost, "/api/v1/register", "", `{"username":"alice","deviceId":"laptop"}`, &errResp); code != http.StatusConflict || errResp.Code != models.ErrorCodeUserExists {
+		t.Errorf("Expected a taken username to conflict, got %d %+v", code, errResp)
+	}
+	if code := doAPI(t, api, http.MethodPost, "/api/v1/login", "", `{"username":"alice","deviceId":"laptop"}`, &errResp); code != http.StatusUnauthorized || errResp.Code != models.ErrorCodeUnauthorized {
+		t.Errorf("Expected login from another device to be refused, got %d %+v", code, errResp)
+	}
+	if code := doAPI(t, api, http.MethodPost, "/api/v1/login", "", `{"username":"alice","deviceId":"phone","admin":true}`, &errResp); code != http.StatusBadRequest || errResp.Code != models.ErrorCodeValidationFailed {
+		t.Errorf("Expected unknown fields to be rejected, got %d %+v", code, errResp)
+	}
+	if code := doAPI(t, api, http.MethodPost, "/api/v1/register", "", `{"username":"bob"}`, &errResp); code != http.StatusBadRequest || errResp.Code != models.ErrorCodeValidationFailed {
+		t.Errorf("Expected registration without a device ID to be rejected, got %d %+v", code, errResp)
+	}
+
+	// A user who first connected without a device ID cannot be logged into by anyone
+	api.wsm.userManager.CreateUser("carol", "")
+	if code := doAPI(t, api, http.MethodPost, "/api/v1/login", "", `{"username":"carol","deviceId":""}`, &errResp); code != http.StatusBadRequest || errResp.Code != models.ErrorCodeValidationFailed {
+		t.Errorf("Expected login without a device ID to be rejected, got %d %+v", code, errResp)
+	}
+
+	var login models.SessionResponse
+	if code := doAPI(t, api, http.MethodPost, "/api/v1/login", "", `{"username":"alice","deviceId":"phone"}`, &login); code != http.StatusOK || login.Token == "" || login.Token == session.Token {
+		t.Errorf("Expected login to return a new token, got %d %+v", code, login)
+	}
+
+	var stats models.UserStatsResponse
+	if code := doAPI(t, api, http.MethodGet, "/api/v1/users/alice/stats", "", "", &stats); code != http.StatusOK || stats.Username != "alice" {
+		t.Errorf("Expected alice's stats, got %d %+v", code, stats)
+	}
+	if code := doAPI(t, api, http.MethodGet, "/api/v1/users/bob/stats", "", "", &errResp); code != http.StatusNotFound || errResp.Code != models.ErrorCodeUserNotFound {
+		t.Errorf("Expected an unknown user to be not found, got %d %+v", code, errResp)
+	}
+}
+
+func TestAPIMovesNotifyWebSocketPlayers(t *testing.T) {
+	wsm := newTestManager()
+	api := NewAPI(wsm)
+
+	var session models.SessionResponse
+	doAPI(t, api, http.MethodPost, "/api/v1/register", "", `{"username":"alice","deviceId":"phone"}`, &session)
+	alice, _ := wsm.userManager.GetUser("alice")
+	bob := wsm.userManager.CreateUser("bob", "")
+	bobConn := &fakeConn{}
+	bob.AddConnection(bobConn)
+	game, _ := wsm.gameManager.CreateGame(alice, bob, models.GameOptions{})
+	movePath := "/api/v1/games/" + game.ID + "/moves"
+
+	var errResp models.ErrorResponse
+	if code := doAPI(t, api, http.MethodPost, movePath, "", `{"row":0,"col":0}`, &errResp); code != http.StatusUnauthorized {
+		t.Errorf("Expected a move without a token to be refused, got %d %+v", code, errResp)
+	}
+	if code := doAPI(t, api, http.MethodGet, movePath, session.Token, "", &errResp); code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET on moves to be refused, got %d %+v", code, errResp)
+	}
+
+	if code := doAPI(t, api, http.MethodPost, movePath, session.Token, `{"row":3,"col":1}`, &errResp); code != http.StatusBadRequest || errResp.Code != models.ErrorCodeOutOfBounds {
+		t.Errorf("Expected a move off the board to be out of bounds, got %d %+v", code, errResp)
+	}
+
+	var state models.GameResponse
+	if code := doAPI(t, api, http.MethodPost, movePath, session.Token, `{"row":1,"col":1,"ply":0}`, &state); code != http.StatusOK {
+		t.Fatalf("Expected the move to be applied, got %d", code)
+	}
+	if state.Board[1][1] != "X" || state.CurrentTurn != "O" || state.Ply != 1 {
+		t.Errorf("Unexpected game state %+v", state)
+	}
+	if len(bobConn.packets) != 1 {
+		t.Fatalf("Expected bob to be sent one game update, got %v", bobConn.packets)
+	}
+	update, ok := bobConn.packets[0].(models.GameUpdatePacket)
+	if !ok || update.Board[1][1] != "X" || !update.CurrentTurn {
+		t.Errorf("Expected bob to be told it is their turn, got %+v", bobConn.packets[0])
+	}
+
+	if code := doAPI(t, api, http.MethodPost, movePath, session.Token, `{"row":2,"col":2}`, &errResp); code != http.StatusConflict || errResp.Code != models.ErrorCodeNotYourTurn {
+		t.Errorf("Expected a move out of turn to conflict, got %d %+v", code, errResp)
+	}
+
+	var games models.GameListResponse
+	doAPI(t, api, http.MethodGet, "/api/v1/games?status="+utils.GameStateInProgress+"&player=bob", "", "", &games)
+	if len(games.Games) != 1 || games.Games[0].GameID != game.ID {
+		t.Errorf("Expected bob's game to be listed, got %+v", games)
+	}
+	if code := doAPI(t, api, http.MethodGet, "/api/v1/games/nope", "", "", &errResp); code != http.StatusNotFound || errResp.Code != models.ErrorCodeGameNotFound {
+		t.Errorf("Expected an unknown game to be not found, got %d %+v", code, errResp)
+	}
+}
+
+func TestAPILeaderboard(t *testing.T) {
+	wsm := newTestManager()
+	api := NewAPI(wsm)
+	for name, wins := range map[string]int{"alice": 1, "bob": 3, "carol": 2} {
+		wsm.userManager.CreateUser(name, "").Stats.Wins = wins
+	}
+
+	var board models.LeaderboardResponse
+	if code := doAPI(t, api, http.MethodGet, "/api/v1/leaderboard?limit=2", "", "", &board); code != http.StatusOK {
+		t.Fatalf("Expected the leaderboard, got %d", code)
+	}
+	if len(board.Entries) != 2 || board.Entries[0].Username != "bob" || board.Entries[1].Username != "carol" || board.Entries[1].Rank != 2 {
+		t.Errorf("Unexpected leaderboard %+v", board.Entries)
+	}
+
+	var errResp models.ErrorResponse
+	if code := doAPI(t, api, http.MethodGet, "/api/v1/leaderboard?limit=0", "", "", &errResp); code != http.StatusBadRequest || len(errResp.Errors) != 1 {
+		t.Errorf("Expected an invalid limit to be rejected, got %d %+v", code, errResp)
+	}
+}
+
+func TestAPIStatsReadWhileGamesEnd(t *testing.T) {
+	wsm := newTestManager()
+	api := NewAPI(wsm)
+	wsm.userManager.CreateUser("alice", "")
+
+	// Run with -race: stats are read from copies while games keep updating them
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			wsm.userManager.UpdateUserStats("alice", "X", true, false)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		var stats models.UserStatsResponse
+		doAPI(t, api, http.MethodGet, "/api/v1/users/alice/stats", "", "", &stats)
+		var board models.LeaderboardResponse
+		doAPI(t, api, http.MethodGet, "/api/v1/leaderboard", "", "", &board)
+	}
+	<-done
+
+	var stats models.UserStatsResponse
+	doAPI(t, api, http.MethodGet, "/api/v1/users/alice/stats", "", "", &stats)
+	if stats.Wins != 100 || stats.AsX.Games != 100 || stats.AsX.WinRate != 1 {
+		t.Errorf("Expected 100 wins as X, got %+v", stats)
+	}
+}
+
+func TestAPIOperatorIssuesAndLiftsSanctions(t *testing.T) {
+	wsm := newTestManager()
+	api := NewAPI(wsm)
+	doAPI(t, api, http.MethodPost, "/api/v1/register", "", `{"username":"alice","deviceId":"phone"}`, nil)
+	ban := `{"actor":"mod","kind":"ban","subjectType":"username","subject":"alice","reason":"abuse"}`
+
+	var errResp models.ErrorResponse
+	if code := doAPI(t, api, http.MethodPost, "/api/v1/moderation/sanctions", "secret", ban, &errResp); code != http.StatusNotFound {
+		t.Errorf("Expected moderation endpoints to be off without an operator token, got %d %+v", code, errResp)
+	}
+
+	api.Configure(config.Moderation{OperatorToken: "secret"})
+	if code := doAPI(t, api, http.MethodPost, "/api/v1/moderation/sanctions", "guess", ban, &errResp); code != http.StatusUnauthorized {
+		t.Errorf("Expected a wrong operator token to be refused, got %d %+v", code, errResp)
+	}
+	var session models.SessionResponse
+	doAPI(t, api, http.MethodPost, "/api/v1/login", "", `{"username":"alice","deviceId":"phone"}`, &session)
+	if code := doAPI(t, api, http.MethodPost, "/api/v1/moderation/sanctions", session.Token, ban, &errResp); code != http.StatusUnauthorized {
+		t.Errorf("Expected a player's session token to be refused, got %d %+v", code, errResp)
+	}
+	suspension := `{"actor":"mod","kind":"suspension","subjectType":"username","subject":"alice"}`
+	if code := doAPI(t, api, http.MethodPost, "/api/v1/moderation/sanctions", "secret", suspension, &errResp); code != http.StatusBadRequest || errResp.Code != models.ErrorCodeValidationFailed {
+		t.Errorf("Expected a suspension without a duration to be rejected, got %d %+v", code, errResp)
+	}
+
+	var sanction models.SanctionResponse
+	if code := doAPI(t, api, http.MethodPost, "/api/v1/moderation/sanctions", "secret", ban, &sanction); code != http.StatusCreated || sanction.IssuedBy != "mod" || sanction.ExpiresAt != nil {
+		t.Fatalf("Expected a permanent ban, got %d %+v", code, sanction)
+	}
+	if code := doAPI(t, api, http.MethodPost, "/api/v1/login", "", `{"username":"alice","deviceId":"phone"}`, &errResp); code != http.StatusForbidden || errResp.Code != models.ErrorCodeBanned {
+		t.Errorf("Expected the banned user to be refused, got %d %+v", code, errResp)
+	}
+
+	lift := `{"actor":"mod","kind":"ban","subjectType":"username","subject":"alice","reason":"appeal"}`
+	if code := doAPI(t, api, http.MethodPost, "/api/v1/moderation/sanctions/lift", "secret", lift, nil); code != http.StatusNoContent {
+		t.Fatalf("Expected the ban to be lifted, got %d", code)
+	}
+	if code := doAPI(t, api, http.MethodPost, "/api/v1/login", "", `{"username":"alice","deviceId":"phone"}`, &session); code != http.StatusOK {
+		t.Errorf("Expected login once the ban is lifted, got %d", code)
+	}
+	if code := doAPI(t, api, http.MethodPost, "/api/v1/moderation/sanctions/lift", "secret", lift, &errResp); code != http.StatusNotFound || errResp.Code != models.ErrorCodeSanctionNotFound {
+		t.Errorf("Expected lifting a lifted ban to be not found, got %d %+v", code, errResp)
+	}
+
+	audit := wsm.moderationManager.AuditLog()
+	if len(audit) != 2 || audit[0].Action != models.AuditActionIssue || audit[1].Action != models.AuditActionLift {
+		t.Errorf("Expected the ban and its lift to be audited, got %+v", audit)
+	}
+}
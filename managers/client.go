@@ -0,0 +1,166 @@
+package managers
+
+import (
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"tictactoe/codec"
+	"tictactoe/config"
+	"tictactoe/models"
+	"time"
+)
+
+var (
+	// ErrSendQueueFull is returned by client.Send when a slow client's send queue overflows.
+	ErrSendQueueFull = errors.New("send queue full")
+	// ErrClientClosed is returned by client.Send once the connection has been closed.
+	ErrClientClosed = errors.New("client connection closed")
+)
+
+// client is a single connection over any transport. All writes go through its send queue and are
+// performed by one writer goroutine, so a slow client never blocks the goroutine that produced the
+// message.
+type client struct {
+	transport  transport
+	codec      codec.Codec             // Negotiated through the WebSocket subprotocol; JSON over HTTP transports
+	send       chan []byte             // Outbound messages waiting to be written
+	flushClose chan models.CloseNotice // Close notice to write once the queue is drained
+	done       chan struct{}           // Closed when the connection is closed
+	closeOnce  sync.Once
+	policy     string       // What to do when the send queue is full, see config.SlowConsumer*
+	lastSeen   atomic.Int64 // Unix nanoseconds of the last inbound message or pong
+	logger     *slog.Logger
+
+	sessionID string // Identifies SSE and long-poll sessions; empty for WebSockets
+
+	negotiated atomic.Pointer[negotiation] // Set by the connect handshake; nil before it
+
+	// Used only by the goroutine reading from the connection, or while holding inbound
+	remoteIP   string
+	packets    tokenBucket // Inbound packet budget
+	violations int         // Rate-limited packets in a row
+	inbound    sync.Mutex  // Serializes packets posted concurrently over HTTP transports
+}
+
+// negotiation is what the connect handshake agreed for a client. It is replaced, not changed, if
+// the client connects again.
+type negotiation struct {
+	protocolVersion int
+	capabilities    []string
+}
+
+func newClient(transport transport, codec codec.Codec, queueSize int, policy string, logger *slog.Logger) *client {
+	c := &client{
+		transport:  transport,
+		codec:      codec,
+		send:       make(chan []byte, queueSize),
+		flushClose: make(chan models.CloseNotice, 1),
+		done:       make(chan struct{}),
+		policy:     policy,
+		logger:     logger,
+	}
+	c.touch()
+	return c
+}
+
+// Send encodes a packet and queues it for the writer goroutine without blocking. When the queue is
+// full the packet is dropped or the client is disconnected, depending on the slow consumer policy.
+func (c *client) Send(packet any) error {
+	select {
+	case <-c.done:
+		return ErrClientClosed
+	default:
+	}
+
+	msg, err := c.codec.Marshal(packet)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case c.send <- msg:
+		return nil
+	case <-c.done:
+		return ErrClientClosed
+	default:
+	}
+
+	sendQueueOverflows.Inc(c.policy)
+	if c.policy == config.SlowConsumerDrop {
+		c.logger.Warn("send queue full, dropping message", "queue_size", cap(c.send))
+		return ErrSendQueueFull
+	}
+	c.logger.Warn("send queue full, disconnecting slow client", "queue_size", cap(c.send))
+	c.close()
+	return ErrSendQueueFull
+}
+
+// writePump writes queued messages to the transport until the connection is closed.
+func (c *client) writePump() {
+	for {
+		select {
+		case msg := <-c.send:
+			if err := c.transport.writeMessage(msg); err != nil {
+				c.logger.Debug("failed to write message", "error", err)
+				c.close()
+				return
+			}
+		case notice := <-c.flushClose:
+			c.drain()
+			c.transport.writeClose(notice)
+			c.close()
+			return
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// drain writes every message still in the queue.
+func (c *client) drain() {
+	for {
+		select {
+		case msg := <-c.send:
+			if err := c.transport.writeMessage(msg); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// closeAfterFlush writes every queued message and a close notice, then closes the connection. The
+// code is a WebSocket close code whatever the transport.
+func (c *client) closeAfterFlush(code int, text string) {
+	select {
+	case c.flushClose <- models.CloseNotice{Code: code, Reason: text}:
+	default:
+	}
+}
+
+// close closes the connection immediately. It is safe to call more than once.
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.transport.close()
+	})
+}
+
+// hasCapability reports whether the capability was negotiated for this connection.
+func (c *client) hasCapability(capability string) bool {
+	n := c.negotiated.Load()
+	return n != nil && slices.Contains(n.capabilities, capability)
+}
+
+// touch records that the client is alive.
+func (c *client) touch() {
+	c.lastSeen.Store(time.Now().UnixNano())
+}
+
+// silentFor returns how long it has been since the client was last heard from.
+func (c *client) silentFor(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, c.lastSeen.Load()))
+}
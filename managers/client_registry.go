@@ -0,0 +1,140 @@
+package managers
+
+import (
+	"sync"
+	"tictactoe/models"
+)
+
+// clientRegistry tracks every open client, the user each is bound to and the HTTP transport
+// sessions. Registering, binding and removing a client each happen under one lock together with
+// the matching change to the user's sessions, so a client that is being torn down can never be
+// bound, and a client is only ever torn down once.
+type clientRegistry struct {
+	mu       sync.Mutex
+	clients  map[*client]*models.User    // Every registered client and its user, nil until bound
+	byUser   map[string]map[*client]bool // Bound clients by username
+	sessions map[string]*client          // SSE and long-poll clients by session ID
+}
+
+func newClientRegistry() *clientRegistry {
+	return &clientRegistry{
+		clients:  make(map[*client]*models.User),
+		byUser:   make(map[string]map[*client]bool),
+		sessions: make(map[string]*client),
+	}
+}
+
+// add registers a client that is not yet bound to a user, along with its session ID if it has one.
+func (r *clientRegistry) add(c *client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clients[c] = nil
+	if c.sessionID != "" {
+		r.sessions[c.sessionID] = c
+	}
+}
+
+// bind binds a registered client to user and adds it to the user's sessions, taking it from the
+// user it was bound to before, if any. It returns that previous user and whether the client was
+// its last session. It reports false without binding if the client has already been removed.
+func (r *clientRegistry) bind(c *client, user *models.User) (previous *models.User, previousLeft, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	previous, ok = r.clients[c]
+	if !ok {
+		return nil, false, false
+	}
+	if previous != nil && previous != user {
+		r.unindex(c, previous)
+		previousLeft = previous.RemoveConnection(c) == 0
+	}
+	r.clients[c] = user
+	if r.byUser[user.Username] == nil {
+		r.byUser[user.Username] = make(map[*client]bool)
+	}
+	r.byUser[user.Username][c] = true
+	user.AddConnection(c)
+	return previous, previousLeft, true
+}
+
+// remove unregisters a client and takes it from its user's sessions. It returns the user the client
+// was bound to, if any, and whether the client was their last session. It reports false if the
+// client had already been removed, so only one caller tears a client down.
+func (r *clientRegistry) remove(c *client) (user *models.User, lastSession, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok = r.clients[c]
+	if !ok {
+		return nil, false, false
+	}
+	delete(r.clients, c)
+	if c.sessionID != "" {
+		delete(r.sessions, c.sessionID)
+	}
+	if user != nil {
+		r.unindex(c, user)
+		lastSession = user.RemoveConnection(c) == 0
+	}
+	return user, lastSession, true
+}
+
+func (r *clientRegistry) unindex(c *client, user *models.User) {
+	delete(r.byUser[user.Username], c)
+	if len(r.byUser[user.Username]) == 0 {
+		delete(r.byUser, user.Username)
+	}
+}
+
+// userFor returns the user a client is bound to, or nil before it has sent a connect packet.
+func (r *clientRegistry) userFor(c *client) *models.User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.clients[c]
+}
+
+// session returns the HTTP transport client with the given session ID, or nil.
+func (r *clientRegistry) session(sessionID string) *client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sessions[sessionID]
+}
+
+// forUser returns the clients bound to the named user on this node.
+func (r *clientRegistry) forUser(username string) []*client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clients := make([]*client, 0, len(r.byUser[username]))
+	for c := range r.byUser[username] {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// usernames returns the users with at least one client on this node.
+func (r *clientRegistry) usernames() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	usernames := make([]string, 0, len(r.byUser))
+	for username := range r.byUser {
+		usernames = append(usernames, username)
+	}
+	return usernames
+}
+
+// all returns every registered client.
+func (r *clientRegistry) all() []*client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clients := make([]*client, 0, len(r.clients))
+	for c := range r.clients {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// count returns the number of registered clients.
+func (r *clientRegistry) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.clients)
+}
@@ -0,0 +1,105 @@
+package managers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"tictactoe/config"
+	"tictactoe/models"
+	"tictactoe/utils"
+)
+
+func TestRegistryBindAfterRemoveFails(t *testing.T) {
+	r := newClientRegistry()
+	c := newClient(nil, nil, 1, config.SlowConsumerDrop, nil)
+	alice := models.NewUser("alice", "")
+
+	r.add(c)
+	if _, _, ok := r.bind(c, alice); !ok || len(alice.Connections()) != 1 {
+		t.Fatalf("Expected the client to be bound to alice")
+	}
+	if user, last, ok := r.remove(c); !ok || user != alice || !last {
+		t.Fatalf("Expected removing alice's only client to report their last session, got %v %v %v", user, last, ok)
+	}
+	if _, _, ok := r.remove(c); ok {
+		t.Errorf("Expected a client to be removed only once")
+	}
+	if _, _, ok := r.bind(c, alice); ok || len(alice.Connections()) != 0 {
+		t.Errorf("Expected a removed client not to be bound again")
+	}
+	if len(r.forUser("alice")) != 0 || len(r.usernames()) != 0 {
+		t.Errorf("Expected no clients left for alice, got %v", r.usernames())
+	}
+}
+
+func TestRegistryUnderConcurrentConnections(t *testing.T) {
+	wsm := newTestManager()
+	wsm.ConfigureRateLimits(config.RateLimit{})
+	server := httptest.NewServer(http.HandlerFunc(wsm.HandleWebSocket))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	// Users open and close sessions, some sharing a username, while others look them up
+	const conns = 40
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			for _, username := range wsm.OnlineUsers() {
+				wsm.IsOnline(username)
+				wsm.UserConnections(username)
+			}
+			wsm.ClientCount()
+		}
+	}()
+	for i := 0; i < conns; i++ {
+		wg.Add(1)
+		go func(username string) {
+			defer wg.Done()
+			conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+			if err != nil {
+				t.Errorf("Failed to connect: %v", err)
+				return
+			}
+			defer conn.Close()
+			conn.WriteJSON(connectPacket(username))
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			for {
+				var packet map[string]any
+				if err := conn.ReadJSON(&packet); err != nil {
+					t.Errorf("Failed to read stats: %v", err)
+					return
+				}
+				if packet["type"] == utils.UserStatsPacketType {
+					return
+				}
+			}
+		}(fmt.Sprintf("user%d", i%10))
+	}
+	wg.Wait()
+	defer close(stop)
+
+	for deadline := time.Now().Add(2 * time.Second); wsm.ClientCount() > 0 || len(wsm.OnlineUsers()) > 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected every client to be torn down, %d left for %v", wsm.ClientCount(), wsm.OnlineUsers())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		user, err := wsm.userManager.GetUser(fmt.Sprintf("user%d", i))
+		if err != nil || len(user.Connections()) != 0 {
+			t.Errorf("Expected user%d to have no sessions left, got %v", i, err)
+		}
+	}
+}
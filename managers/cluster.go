@@ -0,0 +1,620 @@
+package managers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"tictactoe/backplane"
+	"tictactoe/codec"
+	"tictactoe/config"
+	"tictactoe/models"
+	"tictactoe/utils"
+	"time"
+)
+
+// Backplane channels, presence keys and queues. A node hears about its own users on
+// "user:<username>" and everything addressed to it on "node:<id>".
+const (
+	presenceChannel = "presence"    // Users whose last session on some node went away
+	matchQueue      = "matchmaking" // Prefix of the queue of each set of game options
+)
+
+// Kinds of clusterMessage.
+const (
+	clusterDeliver = "deliver" // Packet to send to every local session of Username
+	clusterPacket  = "packet"  // Inbound packet forwarded to the node hosting its game
+	clusterMatch   = "match"   // Game start for the user waiting under Ticket
+	clusterLeft    = "left"    // Username's last session on Origin went away
+	clusterEnded   = "ended"   // Game GameID hosted on Origin is over
+)
+
+// forwardedPackets are the inbound packet types that may be forwarded to the node hosting their
+// game. They must not depend on the connection they arrived on.
+var forwardedPackets = map[string]bool{
+	utils.MovePacketType:   true,
+	utils.ChatPacketType:   true,
+	utils.ResignPacketType: true,
+}
+
+// clusterMessage is everything nodes tell each other over the backplane. Packets are always JSON.
+type clusterMessage struct {
+	Kind     string          `json:"kind"`
+	Origin   string          `json:"origin"`
+	Username string          `json:"username,omitempty"`
+	DeviceID string          `json:"deviceId,omitempty"`
+	Ticket   string          `json:"ticket,omitempty"`
+	GameID   string          `json:"gameId,omitempty"`
+	Packet   json.RawMessage `json:"packet,omitempty"`
+}
+
+// matchEntry is a user waiting in the shared matchmaking queue.
+type matchEntry struct {
+	Node     string `json:"node"`
+	Username string `json:"username"`
+	DeviceID string `json:"deviceId"`
+	ID       string `json:"id"` // Tells apart entries of a user who asks again
+	Variant  string `json:"variant"`
+	MoveTime int64  `json:"moveTimeMs,omitempty"`
+}
+
+// matchQueueFor returns the shared queue of users waiting for a game with the given options.
+func matchQueueFor(options models.GameOptions) string {
+	return fmt.Sprintf("%s:%s:%d", matchQueue, options.Variant, options.MoveTime.Milliseconds())
+}
+
+// cluster connects the manager to other nodes through a backplane. Each game is hosted by the node
+// that created it: the other nodes forward their users' moves and chat there and relay the packets
+// it sends back. Users and games are announced as present on their node until they go away, or
+// until the node stops refreshing them.
+type cluster struct {
+	wsm         *WebSocketManager
+	bp          backplane.Backplane
+	node        string
+	presenceTTL time.Duration
+	logger      *slog.Logger
+	users       map[string]*userPresence // Every user with a session on this node
+	cancels     []func()                 // Cancel the node's own subscriptions
+	stop        chan struct{}
+	mu          sync.Mutex // Protects users; never held while calling the backplane
+}
+
+// SetBackplane connects the manager to other nodes sharing bp and takes ownership of it. It must be
+// called before connections are accepted. Without it the manager runs as a single node on an
+// in-process backplane.
+func (wsm *WebSocketManager) SetBackplane(bp backplane.Backplane, cfg config.Backplane) error {
+	node := cfg.NodeID
+	if node == "" {
+		node = utils.GenerateNodeID()
+	}
+	c := &cluster{
+		wsm:         wsm,
+		bp:          bp,
+		node:        node,
+		presenceTTL: time.Duration(cfg.PresenceTTL),
+		logger:      wsm.logger.With("node_id", node),
+		users:       make(map[string]*userPresence),
+		stop:        make(chan struct{}),
+	}
+	for channel, handle := range map[string]func([]byte){
+		nodeChannel(node): c.handleNodeMessage,
+		presenceChannel:   c.handlePresenceMessage,
+	} {
+		cancel, err := bp.Subscribe(channel, handle)
+		if err != nil {
+			c.close()
+			return fmt.Errorf("subscribing to %s: %w", channel, err)
+		}
+		c.cancels = append(c.cancels, cancel)
+	}
+	go c.refreshPresence()
+
+	if wsm.cluster != nil {
+		wsm.cluster.close()
+	}
+	wsm.cluster = c
+	wsm.matchmakingManager.shared = c
+	c.logger.Info("backplane connected")
+	return nil
+}
+
+func nodeChannel(node string) string { return "node:" + node }
+
+func userKey(username string) string { return "user:" + username }
+
+func gameKey(gameID string) string { return "game:" + gameID }
+
+// userPresence relays the packets other nodes send one user to their sessions on this node. Its
+// lock serializes the user joining and leaving, so the backplane calls those make hold up nobody
+// else.
+type userPresence struct {
+	mu      sync.Mutex
+	cancel  func() // Cancels the subscription; nil until subscribed
+	removed bool   // Set once the presence has been dropped from cluster.users
+}
+
+// presence returns the user's presence, adding it if they have none.
+func (c *cluster) presence(username string) *userPresence {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.users[username]
+	if !ok {
+		p = &userPresence{}
+		c.users[username] = p
+	}
+	return p
+}
+
+// drop removes a presence that is going away. It must be called with p.mu held.
+func (c *cluster) drop(username string, p *userPresence) {
+	c.mu.Lock()
+	if c.users[username] == p {
+		delete(c.users, username)
+	}
+	c.mu.Unlock()
+	p.removed = true
+}
+
+// userJoined announces that a user has a session on this node and starts relaying packets other
+// nodes send them. It is called whenever a session is bound to the user.
+func (c *cluster) userJoined(user *models.User) {
+	p := c.presence(user.Username)
+	p.mu.Lock()
+	for p.removed {
+		// They left just now; start over
+		p.mu.Unlock()
+		p = c.presence(user.Username)
+		p.mu.Lock()
+	}
+	defer p.mu.Unlock()
+	if p.cancel == nil {
+		cancel, err := c.bp.Subscribe(userKey(user.Username), func(msg []byte) { c.handleUserMessage(user.Username, msg) })
+		if err != nil {
+			c.logger.Warn("failed to subscribe to user", "username", user.Username, "error", err)
+			c.drop(user.Username, p)
+			return
+		}
+		p.cancel = cancel
+	}
+	c.announce(userKey(user.Username))
+}
+
+// userLeft withdraws a user whose last session on this node went away and tells the other nodes,
+// so whichever hosts their game can end it.
+func (c *cluster) userLeft(user *models.User) {
+	c.mu.Lock()
+	p, ok := c.users[user.Username]
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.removed || p.cancel == nil || len(user.Connections()) > 0 {
+		return // They have come back already
+	}
+	c.drop(user.Username, p)
+	p.cancel()
+
+	ctx, done := context.WithTimeout(context.Background(), c.presenceTTL)
+	defer done()
+	if err := c.bp.Withdraw(ctx, userKey(user.Username), c.node); err != nil {
+		c.logger.Warn("failed to withdraw user", "username", user.Username, "error", err)
+	}
+	c.publish(ctx, presenceChannel, clusterMessage{Kind: clusterLeft, Username: user.Username})
+}
+
+// hostGame announces that this node hosts a game.
+func (c *cluster) hostGame(game *models.Game) {
+	c.announce(gameKey(game.ID))
+}
+
+// gameEnded tells the other nodes that a game hosted here is over, so they free the seats of its
+// players.
+func (c *cluster) gameEnded(game *models.Game) {
+	ctx, done := context.WithTimeout(context.Background(), c.presenceTTL)
+	defer done()
+	if err := c.publish(ctx, presenceChannel, clusterMessage{Kind: clusterEnded, GameID: game.ID}); err != nil {
+		c.logger.Warn("failed to announce game end", "game_id", game.ID, "error", err)
+	}
+}
+
+// releaseOrphanedSeats frees seats in games whose host has stopped announcing them, such as a node
+// that went away without saying the game ended.
+func (c *cluster) releaseOrphanedSeats() {
+	for _, gameID := range c.wsm.gameManager.remoteGames() {
+		ctx, done := context.WithTimeout(context.Background(), c.presenceTTL)
+		nodes, err := c.bp.Locate(ctx, gameKey(gameID))
+		done()
+		if err == nil && len(nodes) == 0 {
+			c.logger.Warn("host of game went away", "game_id", gameID)
+			c.wsm.gameManager.releaseRemoteSeats(gameID)
+		}
+	}
+}
+
+func (c *cluster) announce(key string) {
+	ctx, done := context.WithTimeout(context.Background(), c.presenceTTL)
+	defer done()
+	if err := c.bp.Announce(ctx, key, c.node, c.presenceTTL); err != nil {
+		c.logger.Warn("failed to announce presence", "key", key, "error", err)
+	}
+}
+
+// refreshPresence announces every local user and hosted game in progress again well before their
+// records expire, and frees seats in games other nodes no longer host.
+func (c *cluster) refreshPresence() {
+	ticker := time.NewTicker(c.presenceTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+		c.mu.Lock()
+		usernames := make([]string, 0, len(c.users))
+		for username := range c.users {
+			usernames = append(usernames, username)
+		}
+		c.mu.Unlock()
+		for _, username := range usernames {
+			c.announce(userKey(username))
+		}
+		for _, game := range c.wsm.gameManager.ListGames(utils.GameStateInProgress, "") {
+			c.hostGame(game)
+		}
+		c.releaseOrphanedSeats()
+	}
+}
+
+// presentElsewhere reports whether the user has a session on another node.
+func (c *cluster) presentElsewhere(username string) bool {
+	ctx, done := context.WithTimeout(context.Background(), c.presenceTTL)
+	defer done()
+	elsewhere, err := c.locateElsewhere(ctx, username)
+	if err != nil {
+		c.logger.Warn("failed to locate user", "username", username, "error", err)
+	}
+	return elsewhere
+}
+
+func (c *cluster) locateElsewhere(ctx context.Context, username string) (bool, error) {
+	nodes, err := c.bp.Locate(ctx, userKey(username))
+	if err != nil {
+		return false, err
+	}
+	for _, node := range nodes {
+		if node != c.node {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// deliver sends a packet to the user's sessions on every other node. Nothing is published unless
+// presence says the user has a session on another node.
+func (c *cluster) deliver(username string, packet any) error {
+	ctx, done := context.WithTimeout(context.Background(), c.presenceTTL)
+	defer done()
+	if elsewhere, err := c.locateElsewhere(ctx, username); err != nil || !elsewhere {
+		return err
+	}
+	data, err := json.Marshal(packet)
+	if err != nil {
+		return err
+	}
+	return c.publish(ctx, userKey(username), clusterMessage{Kind: clusterDeliver, Username: username, Packet: data})
+}
+
+// forward sends an inbound packet for a game this node does not host to the node that does. It
+// reports whether the packet was forwarded; packets that were forwarded already never are again.
+func (c *cluster) forward(req *packetRequest, gameID string, packet any) bool {
+	if req.forwarded || req.user == nil {
+		return false
+	}
+	ctx, done := context.WithTimeout(context.Background(), c.presenceTTL)
+	defer done()
+	nodes, err := c.bp.Locate(ctx, gameKey(gameID))
+	if err != nil {
+		req.logger.Warn("failed to locate game", "game_id", gameID, "error", err)
+		return false
+	}
+	for _, node := range nodes {
+		if node == c.node {
+			continue
+		}
+		data, err := json.Marshal(packet)
+		if err != nil {
+			return false
+		}
+		msg := clusterMessage{Kind: clusterPacket, Username: req.user.Username, DeviceID: req.user.DeviceID, Packet: data}
+		if err := c.publish(ctx, nodeChannel(node), msg); err != nil {
+			req.logger.Warn("failed to forward packet", "game_id", gameID, "node", node, "error", err)
+			return false
+		}
+		req.logger.Debug("packet forwarded", "game_id", gameID, "node", node)
+		return true
+	}
+	return false
+}
+
+func (c *cluster) publish(ctx context.Context, channel string, msg clusterMessage) error {
+	msg.Origin = c.node
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.bp.Publish(ctx, channel, data)
+}
+
+// ticket implements sharedQueue. The ticket is the queue entry itself, so enqueueing and
+// withdrawing it needs nothing else.
+func (c *cluster) ticket(user *models.User, options models.GameOptions) (string, error) {
+	entry := matchEntry{
+		Node:     c.node,
+		Username: user.Username,
+		DeviceID: user.DeviceID,
+		ID:       utils.GenerateConnectionID(),
+		Variant:  options.Variant,
+		MoveTime: options.MoveTime.Milliseconds(),
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// enqueue implements sharedQueue.
+func (c *cluster) enqueue(ctx context.Context, ticket string) error {
+	var entry matchEntry
+	if err := json.Unmarshal([]byte(ticket), &entry); err != nil {
+		return err
+	}
+	return c.bp.Push(ctx, matchQueueFor(entry.options()), []byte(ticket))
+}
+
+// withdraw implements sharedQueue.
+func (c *cluster) withdraw(ctx context.Context, ticket string) (bool, error) {
+	var entry matchEntry
+	if err := json.Unmarshal([]byte(ticket), &entry); err != nil {
+		return false, err
+	}
+	return c.bp.Remove(ctx, matchQueueFor(entry.options()), []byte(ticket))
+}
+
+func (e *matchEntry) options() models.GameOptions {
+	return models.GameOptions{Variant: e.Variant, MoveTime: time.Duration(e.MoveTime) * time.Millisecond}
+}
+
+// claim implements sharedQueue. Entries left behind by nodes or users that have gone away are
+// discarded.
+func (c *cluster) claim(ctx context.Context, user *models.User, options models.GameOptions) (*models.Game, error) {
+	queue := matchQueueFor(options)
+	for {
+		item, err := c.bp.Pop(ctx, queue)
+		if err != nil || item == nil {
+			return nil, err
+		}
+		var entry matchEntry
+		if err := json.Unmarshal(item, &entry); err != nil {
+			c.logger.Warn("discarding malformed matchmaking entry", "error", err)
+			continue
+		}
+		if entry.Node == c.node || !c.presentOn(ctx, entry.Username, entry.Node) {
+			continue // Users waiting on this node are never in the shared queue for long
+		}
+		if entry.Username == user.Username {
+			// The user is waiting on another node too; leave that request be
+			return nil, c.bp.Push(ctx, queue, item)
+		}
+
+		opponent := c.wsm.userManager.CreateUser(entry.Username, entry.DeviceID)
+		game, err := c.wsm.gameManager.CreateGame(user, opponent, options)
+		if err != nil && !seatTaken(err, opponent) {
+			// The user cannot play, which is no reason to drop the one waiting
+			if pushErr := c.bp.Push(ctx, queue, item); pushErr != nil {
+				c.logger.Warn("failed to return matchmaking entry", "username", entry.Username, "error", pushErr)
+			}
+			return nil, err
+		}
+		if err != nil {
+			c.logger.Warn("discarding matchmaking entry of user who cannot be matched", "username", entry.Username, "error", err)
+			continue
+		}
+		// Announced before the other node hears of it, so the game is never looked for in vain
+		c.hostGame(game)
+		start, _ := newMatchFoundPacket(game, opponent)
+		data, err := json.Marshal(start)
+		if err == nil {
+			msg := clusterMessage{Kind: clusterMatch, Ticket: string(item), Packet: data}
+			err = c.publish(ctx, nodeChannel(entry.Node), msg)
+		}
+		if err != nil {
+			c.wsm.gameManager.discardGame(game.ID)
+			return nil, err
+		}
+		return game, nil
+	}
+}
+
+func (c *cluster) presentOn(ctx context.Context, username, node string) bool {
+	nodes, err := c.bp.Locate(ctx, userKey(username))
+	if err != nil {
+		return false
+	}
+	for _, other := range nodes {
+		if other == node {
+			return true
+		}
+	}
+	return false
+}
+
+// handleUserMessage relays a packet another node sent a user to their sessions on this node.
+func (c *cluster) handleUserMessage(username string, data []byte) {
+	var msg clusterMessage
+	if err := json.Unmarshal(data, &msg); err != nil || msg.Kind != clusterDeliver || msg.Origin == c.node {
+		return
+	}
+	user, err := c.wsm.userManager.GetUser(username)
+	if err != nil {
+		return
+	}
+	header, err := codec.JSON.PacketHeader(msg.Packet)
+	if err != nil {
+		return
+	}
+	for _, conn := range user.Connections() {
+		if cl, ok := conn.(*client); ok && header.Type == utils.ChatPacketType && !cl.hasCapability(utils.CapabilityChat) {
+			continue // This client cannot display chat
+		}
+		if err := conn.Send(relayedPacket(conn, msg.Packet)); err != nil {
+			c.logger.Warn("failed to relay packet", "username", username, "type", header.Type, "error", err)
+		}
+	}
+}
+
+// handleNodeMessage handles packets forwarded to this node and claims of users waiting here.
+func (c *cluster) handleNodeMessage(data []byte) {
+	var msg clusterMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		c.logger.Warn("discarding malformed cluster message", "error", err)
+		return
+	}
+	switch msg.Kind {
+	case clusterPacket:
+		c.handleForwarded(msg)
+	case clusterMatch:
+		var start models.MatchFoundPacket
+		if err := json.Unmarshal(msg.Packet, &start); err != nil {
+			return
+		}
+		if !c.wsm.matchmakingManager.matchedRemotely(msg.Ticket, start) {
+			c.logger.Warn("claimed user is no longer waiting", "origin", msg.Origin, "game_id", start.GameID)
+		}
+	}
+}
+
+// handleForwarded handles a packet a user sent to another node for a game hosted here. Rate limits
+// were applied by that node, and replies go to every session of the user.
+func (c *cluster) handleForwarded(msg clusterMessage) {
+	user := c.wsm.userManager.CreateUser(msg.Username, msg.DeviceID)
+	logger := c.logger.With("origin", msg.Origin, "username", user.Username)
+	req := &packetRequest{
+		conn:      remoteConnection{wsm: c.wsm, user: user},
+		user:      user,
+		logger:    logger,
+		rateKey:   user.Username,
+		forwarded: true,
+	}
+	header, err := codec.JSON.PacketHeader(msg.Packet)
+	req.id = header.RequestID
+	if err != nil || !forwardedPackets[header.Type] {
+		logger.Warn("discarding forwarded packet", "type", header.Type, "error", err)
+		return
+	}
+	if err := packetHandlers[header.Type](c.wsm, codec.JSON, msg.Packet, req); err != nil {
+		c.wsm.replyWithError(req, header.Type, err)
+	}
+	logger.Log(context.Background(), c.wsm.packetLogLevel, "forwarded packet handled", "type", header.Type)
+}
+
+// handlePresenceMessage ends games hosted here whose player has left every node, and frees the
+// seats of players here in games other nodes have ended.
+func (c *cluster) handlePresenceMessage(data []byte) {
+	var msg clusterMessage
+	if err := json.Unmarshal(data, &msg); err != nil || msg.Origin == c.node {
+		return
+	}
+	switch msg.Kind {
+	case clusterLeft:
+		if user, err := c.wsm.userManager.GetUser(msg.Username); err == nil {
+			c.wsm.abandonActiveGame(user)
+		}
+	case clusterEnded:
+		c.wsm.gameManager.releaseRemoteSeats(msg.GameID)
+	}
+}
+
+// close stops sharing with other nodes and closes the backplane.
+func (c *cluster) close() {
+	c.mu.Lock()
+	select {
+	case <-c.stop:
+		c.mu.Unlock()
+		return
+	default:
+	}
+	close(c.stop)
+	presences := make([]*userPresence, 0, len(c.users))
+	for _, p := range c.users {
+		presences = append(presences, p)
+	}
+	c.mu.Unlock()
+
+	for _, cancel := range c.cancels {
+		cancel()
+	}
+	for _, p := range presences {
+		p.mu.Lock()
+		if p.cancel != nil {
+			p.cancel()
+		}
+		p.mu.Unlock()
+	}
+	if err := c.bp.Close(); err != nil && !errors.Is(err, backplane.ErrClosed) {
+		c.logger.Warn("failed to close backplane", "error", err)
+	}
+}
+
+// remoteConnection replies to a user whose packet was forwarded from another node, reaching every
+// one of their sessions wherever they are.
+type remoteConnection struct {
+	wsm  *WebSocketManager
+	user *models.User
+}
+
+func (r remoteConnection) Send(packet any) error {
+	return r.wsm.sendToUser(r.user, packet)
+}
+
+// relayedPacket turns a JSON packet from another node into something conn's codec encodes as the
+// same packet.
+func relayedPacket(conn models.Connection, data json.RawMessage) any {
+	if c, ok := conn.(*client); !ok || c.codec == codec.JSON {
+		return data
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var packet any
+	if err := dec.Decode(&packet); err != nil {
+		return data
+	}
+	return plainNumbers(packet)
+}
+
+// plainNumbers replaces the json.Numbers in a decoded value with int64 or float64.
+func plainNumbers(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for key, item := range v {
+			v[key] = plainNumbers(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = plainNumbers(item)
+		}
+	}
+	return v
+}
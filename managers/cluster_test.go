@@ -0,0 +1,286 @@
+package managers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"tictactoe/backplane"
+	"tictactoe/config"
+	"tictactoe/models"
+	"tictactoe/utils"
+)
+
+// sharedBackplane lets test nodes share one Memory without closing it for each other.
+type sharedBackplane struct{ *backplane.Memory }
+
+func (sharedBackplane) Close() error { return nil }
+
+// newTestNode starts a node on bp and connects a user to it over a WebSocket.
+func newTestNode(t *testing.T, bp backplane.Backplane, node, username string) (*WebSocketManager, *websocket.Conn) {
+	wsm := newTestManager()
+	cfg := config.Default().Backplane
+	cfg.NodeID = node
+	if err := wsm.SetBackplane(bp, cfg); err != nil {
+		t.Fatalf("Failed to set backplane: %v", err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(wsm.HandleWebSocket))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to WebSocket server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	connect := connectPacket(username)
+	connect.Capabilities = []string{utils.CapabilityChat}
+	conn.WriteJSON(connect)
+	readPacketOfType(t, conn, utils.UserStatsPacketType)
+	return wsm, conn
+}
+
+// readPacketOfType reads packets until one of the given type arrives and returns it.
+func readPacketOfType(t *testing.T, conn *websocket.Conn, packetType string) map[string]any {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var packet map[string]any
+		if err := conn.ReadJSON(&packet); err != nil {
+			t.Fatalf("Failed to read %s packet: %v", packetType, err)
+		}
+		if packet["type"] == packetType {
+			return packet
+		}
+	}
+}
+
+func TestPlayersOnDifferentNodesPlayEachOther(t *testing.T) {
+	bp := sharedBackplane{backplane.NewMemory()}
+	nodeA, alice := newTestNode(t, bp, "node-a", "alice")
+	nodeB, bob := newTestNode(t, bp, "node-b", "bob")
+
+	// Alice waits on node A until Bob asks on node B, which then hosts the game
+	alice.WriteJSON(models.PlayPacket{BasePacket: models.BasePacket{Type: utils.PlayPacketType, RequestID: "a1"}, Username: "alice"})
+	for deadline := time.Now().Add(time.Second); nodeA.matchmakingManager.QueueDepth() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for alice to queue")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	bob.WriteJSON(models.PlayPacket{BasePacket: models.BasePacket{Type: utils.PlayPacketType}, Username: "bob"})
+
+	bobStart := readPacketOfType(t, bob, utils.GameStartPacketType)
+	aliceStart := readPacketOfType(t, alice, utils.GameStartPacketType)
+	gameID, _ := bobStart["gameId"].(string)
+	if aliceStart["gameId"] != gameID || aliceStart["opponent"] != "bob" || aliceStart["requestId"] != "a1" {
+		t.Fatalf("Expected alice to be told about game %s against bob in reply to a1, got %v", gameID, aliceStart)
+	}
+	if aliceStart["yourSymbol"] == bobStart["yourSymbol"] {
+		t.Fatalf("Expected different symbols, got %v and %v", aliceStart, bobStart)
+	}
+	if _, err := nodeB.gameManager.GetGame(gameID); err != nil {
+		t.Fatalf("Expected node B to host the game: %v", err)
+	}
+
+	// Bob plays X on node B; Alice's moves and chat are forwarded from node A
+	first, second := bob, alice
+	if bobStart["yourTurn"] != true {
+		first, second = alice, bob
+	}
+	moves := [][2]int{{0, 0}, {1, 1}, {0, 1}, {2, 2}, {0, 2}}
+	for i, m := range moves {
+		mover, other := first, second
+		if i%2 == 1 {
+			mover, other = second, first
+		}
+		mover.WriteJSON(models.MovePacket{BasePacket: models.BasePacket{Type: utils.MovePacketType, RequestID: "m"}, GameID: gameID, Row: m[0], Col: m[1]})
+		if update := readPacketOfType(t, mover, "gameUpdate"); update["requestId"] != "m" {
+			t.Fatalf("Expected move %d to be acknowledged, got %v", i, update)
+		}
+		readPacketOfType(t, other, "gameUpdate")
+	}
+	if end := readPacketOfType(t, first, "gameEnd"); end["outcome"] != "win" {
+		t.Errorf("Expected the first player to win, got %v", end)
+	}
+	if end := readPacketOfType(t, second, "gameEnd"); end["outcome"] != "lose" {
+		t.Errorf("Expected the second player to lose, got %v", end)
+	}
+
+	alice.WriteJSON(models.ChatPacket{BasePacket: models.BasePacket{Type: utils.ChatPacketType}, GameID: gameID, Message: "gg"})
+	if chat := readPacketOfType(t, bob, utils.ChatPacketType); chat["from"] != "alice" || chat["message"] != "gg" {
+		t.Errorf("Expected alice's chat to reach bob, got %v", chat)
+	}
+}
+
+func TestDisconnectOnOtherNodeAbandonsGame(t *testing.T) {
+	bp := sharedBackplane{backplane.NewMemory()}
+	nodeA, alice := newTestNode(t, bp, "node-a", "alice")
+	_, bob := newTestNode(t, bp, "node-b", "bob")
+	nodeA.ConfigureAbandonment(config.Abandonment{}) // Forfeit as soon as Bob leaves
+
+	// Node A hosts a game with Bob, who is connected to node B
+	aliceUser, _ := nodeA.userManager.GetUser("alice")
+	bobUser := nodeA.userManager.CreateUser("bob", "")
+	game, _ := nodeA.gameManager.CreateGame(aliceUser, bobUser, models.GameOptions{})
+	nodeA.cluster.hostGame(game)
+
+	bob.Close()
+	if end := readPacketOfType(t, alice, "gameEnd"); end["outcome"] != "win" || end["gameId"] != game.ID {
+		t.Errorf("Expected alice to win the abandoned game, got %v", end)
+	}
+}
+
+// stalledBackplane hangs announcing one key until released, like a backplane that stopped
+// answering.
+type stalledBackplane struct {
+	sharedBackplane
+	key     string
+	release chan struct{}
+}
+
+func (b stalledBackplane) Announce(ctx context.Context, key, node string, ttl time.Duration) error {
+	if key == b.key {
+		select {
+		case <-b.release:
+		case <-ctx.Done():
+		}
+	}
+	return b.sharedBackplane.Announce(ctx, key, node, ttl)
+}
+
+func TestStalledPresenceHoldsUpOnlyItsUser(t *testing.T) {
+	wsm := newTestManager()
+	bp := stalledBackplane{sharedBackplane{backplane.NewMemory()}, userKey("alice"), make(chan struct{})}
+	defer close(bp.release)
+	if err := wsm.SetBackplane(bp, config.Default().Backplane); err != nil {
+		t.Fatalf("Failed to set backplane: %v", err)
+	}
+	alice := wsm.userManager.CreateUser("alice", "")
+	bob := wsm.userManager.CreateUser("bob", "")
+	go wsm.cluster.userJoined(alice)
+
+	// Bob comes and goes while alice's announcement hangs
+	joined := make(chan struct{})
+	go func() {
+		defer close(joined)
+		wsm.cluster.userJoined(bob)
+		wsm.cluster.userLeft(bob)
+	}()
+	select {
+	case <-joined:
+	case <-time.After(time.Second):
+		t.Fatal("Expected bob to join and leave while alice's announcement hangs")
+	}
+}
+
+// countingBackplane counts the messages published on each channel.
+type countingBackplane struct {
+	sharedBackplane
+	mu        sync.Mutex
+	published map[string]int
+}
+
+func (b *countingBackplane) Publish(ctx context.Context, channel string, msg []byte) error {
+	b.mu.Lock()
+	b.published[channel]++
+	b.mu.Unlock()
+	return b.sharedBackplane.Publish(ctx, channel, msg)
+}
+
+func (b *countingBackplane) count(channel string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.published[channel]
+}
+
+func TestPacketsOnlyPublishedToUsersOnOtherNodes(t *testing.T) {
+	bp := &countingBackplane{sharedBackplane: sharedBackplane{backplane.NewMemory()}, published: map[string]int{}}
+	nodeA, alice := newTestNode(t, bp, "node-a", "alice")
+	aliceUser, _ := nodeA.userManager.GetUser("alice")
+
+	// Alice is only connected here, so her packets stay on this node
+	nodeA.sendToUser(aliceUser, models.BasePacket{Type: "ping"})
+	readPacketOfType(t, alice, "ping")
+	if n := bp.count(userKey("alice")); n != 0 {
+		t.Errorf("Expected nothing published for a user connected only here, got %d messages", n)
+	}
+
+	// Once she also connects to node B, it gets a copy
+	_, aliceOnB := newTestNode(t, bp, "node-b", "alice")
+	nodeA.sendToUser(aliceUser, models.BasePacket{Type: "ping"})
+	readPacketOfType(t, aliceOnB, "ping")
+	if n := bp.count(userKey("alice")); n != 1 {
+		t.Errorf("Expected one message published for a user also on another node, got %d", n)
+	}
+}
+
+func TestPlayerMatchedRemotelyCanQueueAgainOnlyAfterTheGame(t *testing.T) {
+	bp := sharedBackplane{backplane.NewMemory()}
+	nodeA, alice := newTestNode(t, bp, "node-a", "alice")
+	_, bob := newTestNode(t, bp, "node-b", "bob")
+
+	// Alice waits on node A and is matched by Bob on node B, which hosts the game
+	alice.WriteJSON(models.PlayPacket{BasePacket: models.BasePacket{Type: utils.PlayPacketType}, Username: "alice"})
+	waitForQueueDepth(t, nodeA.matchmakingManager, 1)
+	bob.WriteJSON(models.PlayPacket{BasePacket: models.BasePacket{Type: utils.PlayPacketType}, Username: "bob"})
+	start := readPacketOfType(t, alice, utils.GameStartPacketType)
+	readPacketOfType(t, bob, utils.GameStartPacketType)
+	gameID, _ := start["gameId"].(string)
+
+	// Node A knows she is playing, so she cannot queue for a second game
+	alice.WriteJSON(models.PlayPacket{BasePacket: models.BasePacket{Type: utils.PlayPacketType, RequestID: "again"}, Username: "alice"})
+	if packet := readPacketOfType(t, alice, utils.ErrorPacketType); packet["code"] != string(models.ErrAlreadyInGame.Code) {
+		t.Fatalf("Expected alice to be told she is already in a game, got %v", packet)
+	}
+	if depth := nodeA.matchmakingManager.QueueDepth(); depth != 0 {
+		t.Fatalf("Expected alice not to be queued during her game, got depth %d", depth)
+	}
+
+	// Once Bob resigns on node B, she can queue again
+	bob.WriteJSON(models.ResignPacket{BasePacket: models.BasePacket{Type: utils.ResignPacketType}, GameID: gameID})
+	readPacketOfType(t, alice, "gameEnd")
+	for deadline := time.Now().Add(time.Second); ; {
+		if aliceUser, _ := nodeA.userManager.GetUser("alice"); nodeA.gameManager.CurrentGame(aliceUser) == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for node A to free alice's seat")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	alice.WriteJSON(models.PlayPacket{BasePacket: models.BasePacket{Type: utils.PlayPacketType}, Username: "alice"})
+	waitForQueueDepth(t, nodeA.matchmakingManager, 1)
+}
+
+func TestAPIMoveForwardedToHostNode(t *testing.T) {
+	bp := sharedBackplane{backplane.NewMemory()}
+	nodeA, alice := newTestNode(t, bp, "node-a", "alice")
+	nodeB, bob := newTestNode(t, bp, "node-b", "bob")
+
+	// Node B hosts a game between Bob and Alice, who moves over the API on node A
+	bobUser, _ := nodeB.userManager.GetUser("bob")
+	game, _ := nodeB.gameManager.CreateGame(nodeB.userManager.CreateUser("alice", ""), bobUser, models.GameOptions{})
+	nodeB.cluster.hostGame(game)
+	ply := 1
+	if game.CurrentPlayer() == bobUser {
+		ply = 2
+		bob.WriteJSON(models.MovePacket{BasePacket: models.BasePacket{Type: utils.MovePacketType}, GameID: game.ID, Row: 2, Col: 2})
+		readPacketOfType(t, alice, "gameUpdate")
+	}
+	api := NewAPI(nodeA)
+	aliceUser, _ := nodeA.userManager.GetUser("alice")
+	token := api.newSession(aliceUser).Token
+
+	if code := doAPI(t, api, http.MethodPost, "/api/v1/games/"+game.ID+"/moves", token, `{"row":0,"col":0}`, nil); code != http.StatusAccepted {
+		t.Fatalf("Expected the move to be forwarded to node B, got %d", code)
+	}
+	if update := readPacketOfType(t, alice, "gameUpdate"); update["gameId"] != game.ID || update["ply"] != float64(ply) {
+		t.Errorf("Expected alice's move to be applied, got %v", update)
+	}
+	readPacketOfType(t, bob, "gameUpdate")
+}
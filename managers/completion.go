@@ -0,0 +1,54 @@
+package managers
+
+import (
+	"tictactoe/models"
+	"tictactoe/utils"
+)
+
+// Outcomes of a game for one player, as sent in GameEndPacket.
+const (
+	outcomeWin  = "win"
+	outcomeLose = "lose"
+	outcomeDraw = "draw"
+)
+
+// OnGameCompleted registers f to be called once for every game that ends, after both players have
+// been told and their stats updated. It must be called before games start.
+func (wsm *WebSocketManager) OnGameCompleted(f func(game *models.Game)) {
+	wsm.onGameCompleted = append(wsm.onGameCompleted, f)
+}
+
+// completeGame handles the end of a game, however it ended: it tells both players whether they
+// won, lost or drew, updates their stats, clears their current game, tells OnGameCompleted
+// listeners and moves the game out of its shard. Every path that ends a game calls it, and only
+// the first call for a game does anything.
+func (wsm *WebSocketManager) completeGame(game *models.Game) {
+	if game.Status != utils.GameStateCompleted || !wsm.gameManager.claimCompletion(game.ID) {
+		return
+	}
+	wsm.gameManager.releaseSeats(game)
+	draw := game.Winner == utils.GameStateDraw
+	for _, player := range game.Players {
+		outcome := outcomeLose
+		switch {
+		case draw:
+			outcome = outcomeDraw
+		case game.Winner == player.Username:
+			outcome = outcomeWin
+		}
+		if err := wsm.userManager.UpdateUserStats(player.Username, game.Symbol(player), outcome == outcomeWin, draw); err != nil {
+			wsm.logger.Warn("failed to update stats", "game_id", game.ID, "username", player.Username, "error", err)
+		}
+		wsm.sendGameEnd(player, game, outcome)
+	}
+
+	if draw {
+		gamesCompleted.Inc(outcomeDraw)
+	} else {
+		gamesCompleted.Inc(outcomeWin)
+	}
+	for _, f := range wsm.onGameCompleted {
+		f(game)
+	}
+	wsm.gameManager.evictGame(game.ID)
+}
@@ -0,0 +1,142 @@
+package managers
+
+import (
+	"errors"
+	"log/slog"
+	"strconv"
+	"testing"
+
+	"tictactoe/config"
+	"tictactoe/models"
+	"tictactoe/utils"
+)
+
+func TestDrawCompletesOnce(t *testing.T) {
+	wsm := newTestManager()
+	completed := 0
+	wsm.OnGameCompleted(func(*models.Game) { completed++ })
+	alice := wsm.userManager.CreateUser("alice", "")
+	bob := wsm.userManager.CreateUser("bob", "")
+	game, _ := wsm.gameManager.CreateGame(alice, bob, models.GameOptions{})
+	aliceConn, bobConn := &fakeConn{}, &fakeConn{}
+	alice.AddConnection(aliceConn)
+	bob.AddConnection(bobConn)
+
+	moves := [][2]int{{0, 0}, {0, 1}, {0, 2}, {1, 1}, {1, 0}, {1, 2}, {2, 1}, {2, 0}, {2, 2}}
+	for i, m := range moves {
+		player, conn := alice, aliceConn
+		if i%2 == 1 {
+			player, conn = bob, bobConn
+		}
+		req := &packetRequest{conn: conn, user: player, logger: slog.Default()}
+		if err := wsm.handleMove(req, &models.MovePacket{GameID: game.ID, Row: m[0], Col: m[1]}); err != nil {
+			t.Fatalf("Move %d failed: %v", i, err)
+		}
+	}
+
+	for _, conn := range []*fakeConn{aliceConn, bobConn} {
+		if end := conn.packets[len(conn.packets)-1].(models.GameEndPacket); end.Outcome != "draw" || end.Winner != "" {
+			t.Errorf("Expected a draw with no winner, got %+v", end)
+		}
+	}
+	if alice.Stats != (models.UserStats{Draws: 1, AsX: models.SymbolStats{Draws: 1}}) || bob.Stats != (models.UserStats{Draws: 1, AsO: models.SymbolStats{Draws: 1}}) {
+		t.Errorf("Expected one draw each, got %+v and %+v", alice.Stats, bob.Stats)
+	}
+	if alice.CurrentGame != nil || bob.CurrentGame != nil {
+		t.Errorf("Expected the players' current game to be cleared")
+	}
+
+	// Telling the players about the finished game again does not complete it again
+	final, _ := wsm.gameManager.GetGame(game.ID)
+	wsm.notifyGameUpdate(final, nil, "")
+	if completed != 1 || alice.Stats.Draws != 1 {
+		t.Errorf("Expected the game to complete once, got %d completions and %+v", completed, alice.Stats)
+	}
+}
+
+func TestBalancedSeatingEvensOutSymbols(t *testing.T) {
+	wsm := newTestManager()
+	wsm.gameManager.Configure(config.Matchmaking{Seating: config.SeatingBalanced})
+	alice := wsm.userManager.CreateUser("alice", "")
+	bob := wsm.userManager.CreateUser("bob", "")
+	alice.Stats.AsX.Wins = 3 // Alice has already had the first move three times
+
+	// X resigns every game, so whoever plays O wins
+	for i, want := range []*models.User{bob, bob, alice} {
+		game, err := wsm.gameManager.CreateGame(alice, bob, models.GameOptions{})
+		if err != nil {
+			t.Fatalf("Failed to create game %d: %v", i, err)
+		}
+		if game.Players[0] != want {
+			t.Errorf("Expected %s to play X in game %d, got %s", want.Username, i, game.Players[0].Username)
+		}
+		resigned, err := wsm.gameManager.Resign(game.ID, game.Players[0])
+		if err != nil {
+			t.Fatalf("Failed to resign game %d: %v", i, err)
+		}
+		wsm.completeGame(resigned)
+	}
+
+	if alice.Stats.SymbolBalance() != 2 || bob.Stats.SymbolBalance() != 1 {
+		t.Errorf("Expected alice two and bob one game ahead as X, got %d and %d", alice.Stats.SymbolBalance(), bob.Stats.SymbolBalance())
+	}
+	if alice.Stats.AsO.WinRate() != 1 || bob.Stats.AsX.WinRate() != 0 || bob.Stats.AsO != (models.SymbolStats{Wins: 1}) {
+		t.Errorf("Expected per-symbol outcomes to follow the resignations, got %+v and %+v", alice.Stats, bob.Stats)
+	}
+}
+
+func TestCompletedGamesLeaveTheirShards(t *testing.T) {
+	wsm := newTestManager()
+	alice := wsm.userManager.CreateUser("alice", "")
+	bob := wsm.userManager.CreateUser("bob", "")
+	game, _ := wsm.gameManager.CreateGame(alice, bob, models.GameOptions{})
+	if active := wsm.gameManager.FindActiveGame(bob); active == nil || active.ID != game.ID {
+		t.Fatalf("Expected bob's seat to lead to game %s, got %v", game.ID, active)
+	}
+
+	// Alice wins along the top row
+	ply := 0
+	for i, m := range [][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}, {0, 2}} {
+		player := alice
+		if i%2 == 1 {
+			player = bob
+		}
+		ply = i
+		req := &packetRequest{conn: &fakeConn{}, user: player, logger: slog.Default()}
+		if err := wsm.handleMove(req, &models.MovePacket{GameID: game.ID, Row: m[0], Col: m[1], Ply: &ply}); err != nil {
+			t.Fatalf("Move %d failed: %v", i, err)
+		}
+	}
+
+	if wsm.gameManager.actor(game.ID) != nil {
+		t.Error("Expected the completed game to leave its shard")
+	}
+	if active := wsm.gameManager.FindActiveGame(alice); active != nil {
+		t.Errorf("Expected alice to have no active game, got %v", active)
+	}
+
+	// The finished game can still be looked up, and a retried winning move is acknowledged
+	if final, err := wsm.gameManager.GetGame(game.ID); err != nil || final.Winner != "alice" {
+		t.Errorf("Expected the finished game to be found with alice winning, got %v, %v", final, err)
+	}
+	if _, replayed, err := wsm.gameManager.UpdateGame(game.ID, alice, 0, 2, &ply); err != nil || !replayed {
+		t.Errorf("Expected the winning move to be replayed, got %v, %v", replayed, err)
+	}
+	if _, _, err := wsm.gameManager.UpdateGame(game.ID, bob, 2, 2, nil); !errors.Is(err, models.ErrGameOver) {
+		t.Errorf("Expected a move in the finished game to be refused, got %v", err)
+	}
+	if games := wsm.gameManager.ListGames(utils.GameStateCompleted, "bob"); len(games) != 1 || games[0].ID != game.ID {
+		t.Errorf("Expected the finished game to be listed, got %v", games)
+	}
+}
+
+func TestFinishedGamesKeptAreBounded(t *testing.T) {
+	var finished finishedGames
+	finished.games = make(map[string]*models.Game)
+	for i := 0; i <= finishedGamesKept; i++ {
+		finished.add(&models.Game{ID: strconv.Itoa(i), Status: utils.GameStateCompleted})
+	}
+	if len(finished.all()) != finishedGamesKept || finished.get("0") != nil || finished.get(strconv.Itoa(finishedGamesKept)) == nil {
+		t.Errorf("Expected only the latest %d games to be kept", finishedGamesKept)
+	}
+}
@@ -3,77 +3,438 @@ package managers
 import (
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math/rand"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"tictactoe/config"
 	"tictactoe/models" // Adjust the import path based on your actual project structure
+	"tictactoe/utils"
 	"time"
 )
 
-// GameManager manages game-related operations.
+// gameShardCount is how many shards games are spread over. Games in different shards never share a
+// lock, and games in the same shard only share it to be looked up.
+const gameShardCount = 32
+
+// finishedGamesKept is how many completed games are kept once they leave their shards, so late
+// moves, lookups and listings still find them.
+const finishedGamesKept = 1000
+
+// GameManager manages game-related operations. Games are spread over shards by ID, and every game
+// is owned by its own actor goroutine, so moves in different games never wait for each other.
+// Games returned by the manager are snapshots that later changes do not affect.
 type GameManager struct {
+	shards      [gameShardCount]*gameShard
+	finished    finishedGames // Completed games no longer in their shards
+	logger      *slog.Logger
+	moveTimeout time.Duration                              // Time a player has to move; 0 disables
+	onIdle      func(game *models.Game, idle *models.User) // Told about games forfeited by moveTimeout
+	seating     string                                     // See config.SeatingBalanced
+	created     atomic.Uint64                              // Games created, for alternate seating
+	balance     func(username string) int                  // Games a user played as X more than as O, for balanced seating
+	remote      map[string][]*models.User                  // Users seated in games other nodes host, by game ID; protected by seats
+	seats       sync.Mutex                                 // Protects the players' CurrentGame
+}
+
+// gameShard holds some of the games.
+type gameShard struct {
+	games map[string]*gameActor
+	mu    sync.RWMutex // Protects games, not the games themselves
+}
+
+// finishedGames holds the final state of the most recently completed games.
+type finishedGames struct {
 	games map[string]*models.Game
-	mu    sync.RWMutex // ensures thread-safe access to the games map
+	order []string     // IDs, oldest first
+	mu    sync.RWMutex // Protects games and order
+}
+
+// add keeps a completed game, forgetting the oldest one once finishedGamesKept are kept.
+func (f *finishedGames) add(game *models.Game) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, exists := f.games[game.ID]; exists {
+		return
+	}
+	if len(f.order) == finishedGamesKept {
+		delete(f.games, f.order[0])
+		f.order = f.order[1:]
+	}
+	f.games[game.ID] = game
+	f.order = append(f.order, game.ID)
+}
+
+// get returns a copy of the completed game with the given ID, or nil if it is not kept.
+func (f *finishedGames) get(gameID string) *models.Game {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if game, ok := f.games[gameID]; ok {
+		return game.Snapshot()
+	}
+	return nil
+}
+
+// all returns a copy of every kept game.
+func (f *finishedGames) all() []*models.Game {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	games := make([]*models.Game, 0, len(f.games))
+	for _, game := range f.games {
+		games = append(games, game.Snapshot())
+	}
+	return games
 }
 
 // NewGameManager creates a new instance of GameManager.
 func NewGameManager() *GameManager {
-	return &GameManager{
-		games: make(map[string]*models.Game),
+	m := &GameManager{logger: slog.Default(), seating: config.Default().Matchmaking.Seating}
+	m.finished.games = make(map[string]*models.Game)
+	m.remote = make(map[string][]*models.User)
+	for i := range m.shards {
+		m.shards[i] = &gameShard{games: make(map[string]*gameActor)}
 	}
+	return m
 }
 
-// CreateGame initializes a new game with two players and adds it to the games map.
-func (m *GameManager) CreateGame(player1, player2 *models.User) *models.Game {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// shard returns the shard holding the game with the given ID.
+func (m *GameManager) shard(gameID string) *gameShard {
+	h := fnv.New32a()
+	h.Write([]byte(gameID))
+	return m.shards[h.Sum32()%gameShardCount]
+}
+
+// actor returns the actor owning the game with the given ID, or nil if there is no such game.
+func (m *GameManager) actor(gameID string) *gameActor {
+	shard := m.shard(gameID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.games[gameID]
+}
+
+// actors returns every game's actor.
+func (m *GameManager) actors() []*gameActor {
+	var actors []*gameActor
+	for _, shard := range m.shards {
+		shard.mu.RLock()
+		for _, a := range shard.games {
+			actors = append(actors, a)
+		}
+		shard.mu.RUnlock()
+	}
+	return actors
+}
+
+// Configure applies matchmaking settings that decide how games are set up. It must be called before
+// games are created.
+func (m *GameManager) Configure(cfg config.Matchmaking) {
+	m.seating = cfg.Seating
+}
 
-	gameID := fmt.Sprintf("game_%d", time.Now().UnixNano()) // Simple way to generate a unique game ID
+// CreateGame starts a game between two players with the given options, choosing who plays X by
+// the seating policy, and returns a snapshot of it. It is the only way games are created, so every
+// player has at most one game in progress: it fails with models.ErrAlreadyInGame if either player
+// already has one, wrapped in a *seatTakenError naming the player. The players' CurrentGame is set
+// until the game is completed.
+func (m *GameManager) CreateGame(player1, player2 *models.User, options models.GameOptions) (*models.Game, error) {
+	if player1 == player2 {
+		return nil, models.ErrAlreadyInGame
+	}
+	if options.Variant == "" {
+		options.Variant = utils.VariantClassic
+	}
+	m.seats.Lock()
+	defer m.seats.Unlock()
+	for _, player := range []*models.User{player1, player2} {
+		if player.CurrentGame != nil {
+			return nil, &seatTakenError{username: player.Username, gameID: player.CurrentGame.ID}
+		}
+	}
+	if m.seatsSwapped(player1, player2) {
+		player1, player2 = player2, player1
+	}
 	game := &models.Game{
-		ID:          gameID,
+		ID:          utils.GenerateGameID(),
 		Players:     []*models.User{player1, player2},
 		Board:       [3][3]string{},
-		CurrentTurn: "X", // By default, the first player ("X") starts the game
-		Status:      "in_progress",
+		CurrentTurn: "X", // The first player ("X") starts the game
+		Status:      utils.GameStateInProgress,
+		Variant:     options.Variant,
+		MoveTime:    options.MoveTime,
 	}
+	a := newGameActor(game)
+	shard := m.shard(game.ID)
+	shard.mu.Lock()
+	shard.games[game.ID] = a
+	shard.mu.Unlock()
+	a.do(func(game *models.Game) { m.startMoveTimer(a, game) })
+
+	snapshot := a.snapshot()
+	player1.CurrentGame, player2.CurrentGame = snapshot, snapshot
+	m.logger.Info("game created", "game_id", game.ID, "players", []string{player1.Username, player2.Username}, "variant", game.Variant, "move_time", game.MoveTime)
+	return snapshot, nil
+}
 
-	m.games[gameID] = game
-	return game
+// seatTakenError is returned by CreateGame when one of the players already has a game in progress.
+type seatTakenError struct {
+	username string
+	gameID   string
+}
+
+func (e *seatTakenError) Error() string {
+	return fmt.Sprintf("%s is in game %s", e.username, e.gameID)
+}
+
+func (e *seatTakenError) Unwrap() error { return models.ErrAlreadyInGame }
+
+// seatTaken reports whether err is CreateGame finding that the player already has a game.
+func seatTaken(err error, player *models.User) bool {
+	var taken *seatTakenError
+	return errors.As(err, &taken) && taken.username == player.Username
+}
+
+// seatsSwapped reports whether the next game seats its second player as X.
+func (m *GameManager) seatsSwapped(player1, player2 *models.User) bool {
+	switch m.seating {
+	case config.SeatingAlternate:
+		return m.created.Add(1)%2 == 0 // The first game keeps the order it was given
+	case config.SeatingBalanced:
+		if m.balance == nil {
+			break
+		}
+		if balance1, balance2 := m.balance(player1.Username), m.balance(player2.Username); balance1 != balance2 {
+			return balance2 < balance1 // X goes to whoever is further behind on games as X
+		}
+	}
+	return rand.Intn(2) == 1
+}
+
+// setSymbolBalance tells the manager how many more games a user has played as X than as O, which
+// balanced seating evens out. It must be called before games are created.
+func (m *GameManager) setSymbolBalance(balance func(username string) int) {
+	m.balance = balance
+}
+
+// CurrentGame returns the game in progress the player has a seat in, or nil.
+func (m *GameManager) CurrentGame(player *models.User) *models.Game {
+	m.seats.Lock()
+	defer m.seats.Unlock()
+	return player.CurrentGame
+}
+
+// releaseSeats clears the CurrentGame of the players of a completed game.
+func (m *GameManager) releaseSeats(game *models.Game) {
+	m.seats.Lock()
+	defer m.seats.Unlock()
+	for _, player := range game.Players {
+		if player.CurrentGame != nil && player.CurrentGame.ID == game.ID {
+			player.CurrentGame = nil
+		}
+	}
+}
+
+// evictGame moves a completed game out of its shard once its end has been handled, keeping its
+// final state among the finished games.
+func (m *GameManager) evictGame(gameID string) {
+	a := m.actor(gameID)
+	if a == nil {
+		return
+	}
+	// Kept before it leaves the shard, so lookups never miss it in between
+	m.finished.add(a.snapshot())
+	shard := m.shard(gameID)
+	shard.mu.Lock()
+	delete(shard.games, gameID)
+	shard.mu.Unlock()
+}
+
+// seatRemote gives a user on this node a seat in a game another node hosts, so they cannot be
+// matched into a second game here while it is in progress. It reports false if they already have
+// a seat.
+func (m *GameManager) seatRemote(user *models.User, start models.MatchFoundPacket) bool {
+	m.seats.Lock()
+	defer m.seats.Unlock()
+	if user.CurrentGame != nil {
+		return false
+	}
+	user.CurrentGame = &models.Game{
+		ID:       start.GameID,
+		Status:   utils.GameStateInProgress,
+		Variant:  start.Variant,
+		MoveTime: time.Duration(start.MoveTimeMs) * time.Millisecond,
+	}
+	m.remote[start.GameID] = append(m.remote[start.GameID], user)
+	return true
+}
+
+// releaseRemoteSeats clears the seats of users on this node in a game another node hosted.
+func (m *GameManager) releaseRemoteSeats(gameID string) {
+	m.seats.Lock()
+	defer m.seats.Unlock()
+	for _, user := range m.remote[gameID] {
+		if user.CurrentGame != nil && user.CurrentGame.ID == gameID {
+			user.CurrentGame = nil
+		}
+	}
+	delete(m.remote, gameID)
+}
+
+// remoteGames returns the IDs of the games other nodes host that users on this node have seats in.
+func (m *GameManager) remoteGames() []string {
+	m.seats.Lock()
+	defer m.seats.Unlock()
+	ids := make([]string, 0, len(m.remote))
+	for id := range m.remote {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// discardGame forgets a game that never started, such as one its other player could not be told
+// about, and frees its players' seats.
+func (m *GameManager) discardGame(gameID string) {
+	shard := m.shard(gameID)
+	shard.mu.Lock()
+	a := shard.games[gameID]
+	delete(shard.games, gameID)
+	shard.mu.Unlock()
+	if a == nil {
+		return
+	}
+	a.reported.Store(true) // Nobody played it, so there is nothing to complete
+	a.do(func(game *models.Game) { game.Status = utils.GameStateCompleted })
+	m.releaseSeats(a.snapshot())
+	m.logger.Info("game discarded", "game_id", gameID)
+}
+
+// setMoveTimeout makes players who take longer than timeout, or the game's own move time, to move
+// forfeit, and calls onIdle with the forfeited game and the idle player. It must be called before
+// games are created.
+func (m *GameManager) setMoveTimeout(timeout time.Duration, onIdle func(game *models.Game, idle *models.User)) {
+	m.moveTimeout, m.onIdle = timeout, onIdle
+}
+
+// startMoveTimer gives the player to move the game's move time, or else the move timeout, to do so
+// before they forfeit. It runs on the game's actor.
+func (m *GameManager) startMoveTimer(a *gameActor, game *models.Game) {
+	timeout := m.moveTimeout
+	if game.MoveTime > 0 {
+		timeout = game.MoveTime
+	}
+	if timeout <= 0 || game.Status != utils.GameStateInProgress {
+		return
+	}
+	ply := game.Ply()
+	a.after(timeout, func() {
+		var forfeited *models.Game
+		var idle *models.User
+		a.do(func(game *models.Game) {
+			if game.Status != utils.GameStateInProgress || game.Ply() != ply {
+				return // They moved in time
+			}
+			idle = game.CurrentPlayer()
+			game.UpdateWinState(game.Opponent(idle))
+			forfeited = game.Snapshot()
+		})
+		if forfeited == nil {
+			return
+		}
+		m.logger.Info("game forfeited by idle player", "game_id", forfeited.ID, "username", idle.Username, "winner", forfeited.Winner)
+		if m.onIdle != nil {
+			m.onIdle(forfeited, idle)
+		}
+	})
 }
 
 // UpdateGame processes a player's move and updates the game state.
+//
+// If ply is not nil it must equal the number of moves already applied. A move resent with an
+// earlier ply is acknowledged without being applied again, and replayed is true, when it exactly
+// matches the move recorded at that ply; any other mismatch is rejected as stale.
+func (m *GameManager) UpdateGame(gameID string, player *models.User, row, col int, ply *int) (game *models.Game, replayed bool, err error) {
+	start := time.Now()
+	defer func() {
+		result := "ok"
+		switch {
+		case err != nil:
+			result = "rejected"
+		case replayed:
+			result = "replayed"
+		}
+		moveDuration.Observe(time.Since(start).Seconds(), result)
+	}()
 
-func (m *GameManager) UpdateGame(gameID string, player *models.User, row, col int) (*models.Game, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	a := m.actor(gameID)
+	if a == nil {
+		finished := m.finished.get(gameID)
+		if finished == nil {
+			return nil, false, models.ErrGameNotFound
+		}
+		// A completed game only acknowledges retries of its moves
+		if replayed, err = m.applyMove(finished, player, row, col, ply); err != nil {
+			return nil, false, err
+		}
+		return finished, replayed, nil
+	}
+	a.do(func(g *models.Game) {
+		replayed, err = m.applyMove(g, player, row, col, ply)
+		if err == nil && !replayed {
+			m.startMoveTimer(a, g)
+		}
+		game = g.Snapshot()
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return game, replayed, nil
+}
 
-	game, exists := m.games[gameID]
-	if !exists {
-		return nil, errors.New("game not found")
+// applyMove validates a move and applies it to the game. It runs on the game's actor.
+func (m *GameManager) applyMove(game *models.Game, player *models.User, row, col int, ply *int) (replayed bool, err error) {
+	if game.Opponent(player) == nil {
+		return false, models.ErrNotInGame
+	}
+	if ply != nil && *ply != game.Ply() {
+		if *ply < game.Ply() && game.Moves[*ply] == (models.Move{Player: player.Username, Row: row, Col: col}) {
+			return true, nil
+		}
+		return false, models.ErrStaleMove
+	}
+	if game.Status != utils.GameStateInProgress {
+		return false, models.ErrGameOver
 	}
 
 	// Check if it's the player's turn
 	// Assume player[0] is "X" and player[1] is "O"
 	if !game.IsPlayerCurrent(player) {
-		return nil, errors.New("not your turn")
+		return false, models.ErrNotYourTurn
 	}
 
 	if err := game.IsValidMove(row, col); err != nil {
-		return nil, err
+		return false, err
 	}
 
 	// Update the board
 	game.UpdateBoard(row, col, game.CurrentTurn)
+	game.RecordMove(player, row, col)
+	logger := m.logger.With("game_id", game.ID)
+	logger.Debug("move applied", "username", player.Username, "symbol", game.CurrentTurn, "row", row, "col", col)
 
 	// Check for a win or a draw
 	if m.checkWin(game.Board, game.CurrentTurn) {
 		game.UpdateWinState(player)
+		logger.Info("game completed", "winner", game.Winner)
 	} else if m.checkDraw(game.Board) {
 		game.UpdateDrawState()
+		logger.Info("game completed", "winner", game.Winner)
 	} else {
 		// Toggle the current turn
 		game.CurrentTurn = m.toggleTurn(game.CurrentTurn)
 	}
 
-	return game, nil
+	return false, nil
 }
 
 func (m *GameManager) checkWin(board [3][3]string, playerSymbol string) bool {
@@ -115,30 +476,144 @@ func (m *GameManager) toggleTurn(currentTurn string) string {
 	return "X"
 }
 
-// GetGame retrieves a game by its ID.
-func (m *GameManager) GetGame(gameID string) (*models.Game, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	game, exists := m.games[gameID]
-	if !exists {
-		return nil, fmt.Errorf("game with ID %s not found", gameID)
+// FindActiveGame returns the in-progress game the player has a seat in, or nil if there is none.
+func (m *GameManager) FindActiveGame(player *models.User) *models.Game {
+	current := m.CurrentGame(player)
+	if current == nil {
+		return nil
+	}
+	a := m.actor(current.ID)
+	if a == nil {
+		return nil
+	}
+	if game := a.snapshot(); game.Status == utils.GameStateInProgress {
+		return game
 	}
+	return nil
+}
+
+// AbandonGame ends an in-progress game because a player left, making their opponent the winner.
+func (m *GameManager) AbandonGame(gameID string, player *models.User) (*models.Game, error) {
+	return m.forfeit(gameID, player, "game abandoned")
+}
 
-	return game, nil
+// Resign ends an in-progress game at the player's request, making their opponent the winner.
+func (m *GameManager) Resign(gameID string, player *models.User) (*models.Game, error) {
+	return m.forfeit(gameID, player, "game resigned")
+}
+
+// forfeit ends an in-progress game, making the player's opponent the winner, and logs why.
+func (m *GameManager) forfeit(gameID string, player *models.User, reason string) (game *models.Game, err error) {
+	a := m.actor(gameID)
+	if a == nil && m.finished.get(gameID) != nil {
+		return nil, models.ErrGameOver
+	}
+	if a == nil {
+		return nil, fmt.Errorf("game with ID %s: %w", gameID, models.ErrGameNotFound)
+	}
+	a.do(func(g *models.Game) {
+		if g.Status != utils.GameStateInProgress {
+			err = models.ErrGameOver
+			return
+		}
+		opponent := g.Opponent(player)
+		if opponent == nil {
+			err = models.ErrNotInGame
+			return
+		}
+		g.UpdateWinState(opponent)
+		m.logger.Info(reason, "game_id", gameID, "username", player.Username, "winner", g.Winner)
+		game = g.Snapshot()
+	})
+	return game, err
 }
 
-// EndGame marks a game as completed and sets the winner.
-func (m *GameManager) EndGame(gameID, winner string) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// Spectate adds a connection that is sent every update to an in-progress game, and returns the
+// game.
+func (m *GameManager) Spectate(gameID string, conn models.Connection) (*models.Game, error) {
+	a := m.actor(gameID)
+	if a == nil && m.finished.get(gameID) != nil {
+		return nil, models.ErrGameOver
+	}
+	if a == nil {
+		return nil, fmt.Errorf("game with ID %s: %w", gameID, models.ErrGameNotFound)
+	}
+	if !a.watch(conn) {
+		return nil, models.ErrGameOver
+	}
+	return a.snapshot(), nil
+}
 
-	game, exists := m.games[gameID]
-	if !exists {
-		return fmt.Errorf("game with ID %s not found", gameID)
+// StopSpectating removes a connection added by Spectate.
+func (m *GameManager) StopSpectating(gameID string, conn models.Connection) {
+	if a := m.actor(gameID); a != nil {
+		a.unwatch(conn)
 	}
+}
 
-	game.Status = "completed"
-	game.Winner = winner
+// Spectators returns the connections watching a game.
+func (m *GameManager) Spectators(gameID string) []models.Connection {
+	if a := m.actor(gameID); a != nil {
+		return a.spectators()
+	}
 	return nil
 }
+
+// claimCompletion reports whether the caller is the first to handle the end of a game, so the end of
+// every game is handled exactly once. It reports false while the game is in progress.
+func (m *GameManager) claimCompletion(gameID string) bool {
+	a := m.actor(gameID)
+	return a != nil && a.snapshot().Status != utils.GameStateInProgress && a.reported.CompareAndSwap(false, true)
+}
+
+// ListGames returns the games with the given status that the named player is part of, ordered by
+// ID. An empty status or username matches every game.
+func (m *GameManager) ListGames(status, username string) []*models.Game {
+	games := make([]*models.Game, 0)
+	for _, a := range m.actors() {
+		game := a.snapshot()
+		if (status == "" || game.Status == status) && (username == "" || hasPlayer(game, username)) {
+			games = append(games, game)
+		}
+	}
+	for _, game := range m.finished.all() {
+		if (status == "" || game.Status == status) && (username == "" || hasPlayer(game, username)) {
+			games = append(games, game)
+		}
+	}
+	sort.Slice(games, func(i, j int) bool { return games[i].ID < games[j].ID })
+	return games
+}
+
+func hasPlayer(game *models.Game, username string) bool {
+	for _, p := range game.Players {
+		if p.Username == username {
+			return true
+		}
+	}
+	return false
+}
+
+// ActiveGames returns the number of games currently in progress.
+func (m *GameManager) ActiveGames() int {
+	active := 0
+	for _, a := range m.actors() {
+		if a.snapshot().Status == utils.GameStateInProgress {
+			active++
+		}
+	}
+	return active
+}
+
+// GetGame retrieves a game by its ID.
+func (m *GameManager) GetGame(gameID string) (*models.Game, error) {
+	a := m.actor(gameID)
+	if a == nil {
+		if finished := m.finished.get(gameID); finished != nil {
+			return finished, nil
+		}
+		return nil, fmt.Errorf("game with ID %s: %w", gameID, models.ErrGameNotFound)
+	}
+
+	return a.snapshot(), nil
+}
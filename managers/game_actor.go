@@ -0,0 +1,121 @@
+package managers
+
+import (
+	"slices"
+	"sync/atomic"
+	"tictactoe/models"
+	"tictactoe/utils"
+	"time"
+)
+
+// gameActor owns one game. Every change to the game runs on the actor's goroutine, one command at
+// a time in the order the commands arrived, so the game itself needs no lock. Everyone else reads
+// the snapshot the actor publishes after each command.
+type gameActor struct {
+	commands chan func()              // Run on the actor's goroutine
+	done     chan struct{}            // Closed once the game is over and the goroutine has exited
+	view     atomic.Pointer[gameView] // Published after every command
+	game     *models.Game             // Only touched on the actor's goroutine
+	watchers []models.Connection      // Spectators; only touched on the actor's goroutine
+	timer    *time.Timer              // Pending turn timer; only touched on the actor's goroutine
+	reported atomic.Bool              // Set once the end of the game has been handled
+}
+
+// gameView is what an actor publishes about its game. Neither field is changed once published.
+type gameView struct {
+	game       *models.Game
+	spectators []models.Connection
+}
+
+// newGameActor takes ownership of game and starts its goroutine, unless the game is already over.
+func newGameActor(game *models.Game) *gameActor {
+	a := &gameActor{
+		commands: make(chan func()),
+		done:     make(chan struct{}),
+		game:     game,
+	}
+	a.publish()
+	if game.Status != utils.GameStateInProgress {
+		close(a.done)
+		return a
+	}
+	go a.run()
+	return a
+}
+
+func (a *gameActor) run() {
+	defer close(a.done)
+	defer func() {
+		if a.timer != nil {
+			a.timer.Stop()
+		}
+	}()
+	for cmd := range a.commands {
+		cmd()
+		a.publish()
+		if a.game.Status != utils.GameStateInProgress {
+			return // Nothing can change a finished game
+		}
+	}
+}
+
+func (a *gameActor) publish() {
+	a.view.Store(&gameView{game: a.game.Snapshot(), spectators: slices.Clone(a.watchers)})
+}
+
+// snapshot returns the game as of the last command.
+func (a *gameActor) snapshot() *models.Game {
+	return a.view.Load().game
+}
+
+// spectators returns the connections watching the game as of the last command.
+func (a *gameActor) spectators() []models.Connection {
+	return a.view.Load().spectators
+}
+
+// do runs cmd on the actor's goroutine and waits for it to finish. Once the game is over, cmd runs
+// on a copy of the final state instead, so it can still read the game but changes nothing.
+func (a *gameActor) do(cmd func(game *models.Game)) {
+	finished := make(chan struct{})
+	select {
+	case a.commands <- func() { cmd(a.game); close(finished) }:
+		<-finished
+	case <-a.done:
+		cmd(a.snapshot().Snapshot())
+	}
+}
+
+// after calls f on its own goroutine once d has passed, replacing the pending timer if there is
+// one. It must be called on the actor's goroutine. f must check that the game is still as it
+// expects in the commands it sends, since they may arrive after other commands.
+func (a *gameActor) after(d time.Duration, f func()) {
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+	a.timer = time.AfterFunc(d, f)
+}
+
+// watch adds a spectator. It reports false if the game is already over.
+func (a *gameActor) watch(conn models.Connection) bool {
+	watching := false
+	a.do(func(game *models.Game) {
+		if game.Status != utils.GameStateInProgress {
+			return
+		}
+		if !slices.Contains(a.watchers, conn) {
+			a.watchers = append(a.watchers, conn)
+		}
+		watching = true
+	})
+	return watching
+}
+
+// unwatch removes a spectator.
+func (a *gameActor) unwatch(conn models.Connection) {
+	a.do(func(game *models.Game) {
+		if game.Status != utils.GameStateInProgress {
+			return
+		}
+		a.watchers = slices.DeleteFunc(a.watchers, func(c models.Connection) bool { return c == conn })
+	})
+}
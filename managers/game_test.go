@@ -0,0 +1,100 @@
+package managers
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"tictactoe/config"
+	"tictactoe/models"
+	"tictactoe/utils"
+)
+
+func TestConcurrentGamesAcrossShards(t *testing.T) {
+	m := NewGameManager()
+	const games = 100
+
+	// Each game is played to a win by X on its own goroutine while others list and count games
+	var wg sync.WaitGroup
+	for i := 0; i < games; i++ {
+		game, err := m.CreateGame(models.NewUser(fmt.Sprintf("a%d", i), ""), models.NewUser(fmt.Sprintf("b%d", i), ""), models.GameOptions{})
+		if err != nil {
+			t.Fatalf("Failed to create game %d: %v", i, err)
+		}
+		x, o := game.Players[0], game.Players[1]
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			moves := []struct {
+				player   *models.User
+				row, col int
+			}{{x, 0, 0}, {o, 1, 0}, {x, 0, 1}, {o, 1, 1}, {x, 0, 2}}
+			for _, mv := range moves {
+				if _, _, err := m.UpdateGame(game.ID, mv.player, mv.row, mv.col, nil); err != nil {
+					t.Errorf("Move in game %s failed: %v", game.ID, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			m.ActiveGames()
+			m.ListGames(utils.GameStateInProgress, "")
+		}
+	}()
+	wg.Wait()
+
+	if active := m.ActiveGames(); active != 0 {
+		t.Errorf("Expected every game to be over, %d still in progress", active)
+	}
+	completed := m.ListGames(utils.GameStateCompleted, "")
+	if len(completed) != games {
+		t.Fatalf("Expected %d completed games, got %d", games, len(completed))
+	}
+	for _, game := range completed {
+		if game.Winner != game.Players[0].Username {
+			t.Errorf("Expected X to win game %s, got %q", game.ID, game.Winner)
+		}
+	}
+}
+
+func TestCreateGameSeatsPlayersOnce(t *testing.T) {
+	m := NewGameManager()
+	m.Configure(config.Matchmaking{Seating: config.SeatingAlternate})
+	alice, bob, carol := models.NewUser("alice", ""), models.NewUser("bob", ""), models.NewUser("carol", "")
+
+	game, err := m.CreateGame(alice, bob, models.GameOptions{MoveTime: 30 * time.Second})
+	if err != nil {
+		t.Fatalf("Failed to create game: %v", err)
+	}
+	if game.Players[0] != alice || game.Variant != utils.VariantClassic || game.MoveTime != 30*time.Second {
+		t.Errorf("Expected alice as X in a classic game with 30s moves, got %s as X, %q and %v", game.Players[0].Username, game.Variant, game.MoveTime)
+	}
+	if alice.CurrentGame == nil || alice.CurrentGame.ID != game.ID || bob.CurrentGame == nil || bob.CurrentGame.ID != game.ID {
+		t.Errorf("Expected both players' current game to be %s", game.ID)
+	}
+
+	// Nobody plays two games at once
+	if _, err := m.CreateGame(carol, bob, models.GameOptions{}); !errors.Is(err, models.ErrAlreadyInGame) {
+		t.Errorf("Expected pairing bob again to fail with ErrAlreadyInGame, got %v", err)
+	}
+	if carol.CurrentGame != nil {
+		t.Errorf("Expected carol to be left without a game")
+	}
+
+	// Once the game is over both can play again, and the next game seats the other player as X
+	m.releaseSeats(game)
+	next, err := m.CreateGame(alice, bob, models.GameOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create the next game: %v", err)
+	}
+	if next.Players[0] != bob {
+		t.Errorf("Expected bob to play X in the next game, got %s", next.Players[0].Username)
+	}
+}
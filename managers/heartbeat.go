@@ -0,0 +1,64 @@
+package managers
+
+import (
+	"github.com/gorilla/websocket"
+	"time"
+)
+
+// startHeartbeat starts the ping and reaper loop once, the first time a connection is accepted.
+func (wsm *WebSocketManager) startHeartbeat() {
+	wsm.heartbeatOnce.Do(func() {
+		if wsm.pingInterval > 0 {
+			go wsm.heartbeat()
+		}
+	})
+}
+
+// heartbeat pings every connection each interval and reaps connections that have not been heard
+// from, including by pong, within the read timeout. It returns once Shutdown stops it.
+func (wsm *WebSocketManager) heartbeat() {
+	ticker := time.NewTicker(wsm.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		var now time.Time
+		select {
+		case <-wsm.heartbeatStop:
+			return
+		case now = <-ticker.C:
+		}
+		for _, c := range wsm.clients.all() {
+			if silentFor := c.silentFor(now); wsm.readTimeout > 0 && silentFor > wsm.readTimeout {
+				wsm.reap(c, silentFor)
+				continue
+			}
+			if err := c.transport.ping(now.Add(wsm.writeTimeout)); err != nil {
+				c.logger.Debug("failed to send ping", "error", err)
+			}
+		}
+	}
+}
+
+// handlePong extends the read deadline whenever a WebSocket client answers a ping.
+func (wsm *WebSocketManager) handlePong(c *client, conn *websocket.Conn) func(string) error {
+	return func(string) error {
+		c.touch()
+		if wsm.readTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(wsm.readTimeout))
+		}
+		return nil
+	}
+}
+
+// reap closes a connection that stopped answering pings. Closing it ends its read loop, which
+// unregisters the client and abandons any game it was playing.
+func (wsm *WebSocketManager) reap(c *client, silentFor time.Duration) {
+	attrs := []any{"silent_for", silentFor}
+	if user := wsm.userFor(c); user != nil {
+		attrs = append(attrs, "username", user.Username)
+	}
+
+	c.logger.Warn("reaping dead connection", attrs...)
+	connectionsReaped.Inc()
+	c.close()
+}
@@ -0,0 +1,361 @@
+package managers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"tictactoe/codec"
+	"tictactoe/models"
+	"tictactoe/utils"
+	"time"
+)
+
+// maxLongPollWait is the longest a poll is held open waiting for packets. It is shortened to half
+// the read timeout so a client that keeps polling is never reaped.
+const maxLongPollWait = 25 * time.Second
+
+// sseTransport writes packets as Server-Sent Events on a streaming HTTP response. Clients post
+// their packets to HandleSend.
+type sseTransport struct {
+	w            http.ResponseWriter
+	rc           *http.ResponseController
+	writeTimeout time.Duration
+	pong         func() // Called when a ping reaches the client, standing in for a WebSocket pong
+	closed       bool   // Set once the handler may return, after which w must not be used
+	mu           sync.Mutex
+}
+
+func newSSETransport(w http.ResponseWriter, writeTimeout time.Duration) *sseTransport {
+	return &sseTransport{w: w, rc: http.NewResponseController(w), writeTimeout: writeTimeout}
+}
+
+func (t *sseTransport) writeMessage(msg []byte) error {
+	return t.writeEvent("", msg)
+}
+
+func (t *sseTransport) writeClose(notice models.CloseNotice) error {
+	data, err := json.Marshal(notice)
+	if err != nil {
+		return err
+	}
+	return t.writeEvent("close", data)
+}
+
+// ping writes an SSE comment. Reaching the client counts as a pong, since SSE has no way to answer.
+func (t *sseTransport) ping(deadline time.Time) error {
+	if err := t.write(deadline, ": ping\n\n"); err != nil {
+		return err
+	}
+	if t.pong != nil {
+		t.pong()
+	}
+	return nil
+}
+
+func (t *sseTransport) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	return nil
+}
+
+// writeEvent writes one event. JSON packets never contain newlines, so each fits on one data line.
+func (t *sseTransport) writeEvent(event string, data []byte) error {
+	var b strings.Builder
+	if event != "" {
+		fmt.Fprintf(&b, "event: %s\n", event)
+	}
+	fmt.Fprintf(&b, "data: %s\n\n", data)
+	return t.write(time.Now().Add(t.writeTimeout), b.String())
+}
+
+func (t *sseTransport) write(deadline time.Time, s string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return ErrClientClosed
+	}
+	t.rc.SetWriteDeadline(deadline) // Not every ResponseWriter supports deadlines
+	if _, err := io.WriteString(t.w, s); err != nil {
+		return err
+	}
+	return t.rc.Flush()
+}
+
+// longPollTransport holds packets until the client next polls for them. Clients post their packets
+// to HandleSend.
+type longPollTransport struct {
+	pending [][]byte
+	notice  *models.CloseNotice
+	limit   int           // Most packets held between polls
+	ready   chan struct{} // Signaled whenever there is something for a poll to return
+	closed  bool
+	mu      sync.Mutex
+}
+
+func newLongPollTransport(limit int) *longPollTransport {
+	return &longPollTransport{limit: limit, ready: make(chan struct{}, 1)}
+}
+
+// writeMessage holds a packet for the next poll. A client that stops polling while packets pile up
+// is treated as a slow consumer and disconnected.
+func (t *longPollTransport) writeMessage(msg []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return ErrClientClosed
+	}
+	if len(t.pending) >= t.limit {
+		return ErrSendQueueFull
+	}
+	t.pending = append(t.pending, msg)
+	t.signal()
+	return nil
+}
+
+func (t *longPollTransport) writeClose(notice models.CloseNotice) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.notice = &notice
+	t.signal()
+	return nil
+}
+
+// ping does nothing; a long-poll client shows it is alive by polling.
+func (t *longPollTransport) ping(deadline time.Time) error {
+	return nil
+}
+
+func (t *longPollTransport) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	t.signal()
+	return nil
+}
+
+func (t *longPollTransport) signal() {
+	select {
+	case t.ready <- struct{}{}:
+	default:
+	}
+}
+
+// poll waits up to wait for packets and returns every packet held so far, along with the close
+// notice once the session has ended. It returns early when ctx is done.
+func (t *longPollTransport) poll(ctx context.Context, wait time.Duration) models.PollResponse {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		t.mu.Lock()
+		if len(t.pending) > 0 || t.notice != nil || t.closed {
+			response := models.PollResponse{Packets: make([]json.RawMessage, len(t.pending)), Close: t.notice}
+			for i, msg := range t.pending {
+				response.Packets[i] = msg
+			}
+			if t.closed && t.notice == nil {
+				response.Close = &models.CloseNotice{Code: websocket.CloseAbnormalClosure}
+			}
+			t.pending, t.notice = nil, nil
+			t.mu.Unlock()
+			return response
+		}
+		t.mu.Unlock()
+
+		select {
+		case <-t.ready:
+		case <-timer.C:
+			return models.PollResponse{Packets: []json.RawMessage{}}
+		case <-ctx.Done():
+			return models.PollResponse{Packets: []json.RawMessage{}}
+		}
+	}
+}
+
+// HandleSSE opens a session whose packets are streamed as Server-Sent Events, for networks that
+// block WebSocket upgrades. The first event, named "session", carries the session ID that packets
+// are posted to HandleSend with.
+func (wsm *WebSocketManager) HandleSSE(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !wsm.admitHTTP(w, r) {
+		return
+	}
+
+	t := newSSETransport(w, wsm.writeTimeout)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Stop reverse proxies from buffering the stream
+	w.WriteHeader(http.StatusOK)
+
+	c, logger := wsm.newSessionClient(t, r, "sse")
+	t.pong = c.touch
+	wsm.registerSession(c, logger)
+	session, _ := json.Marshal(models.TransportSession{SessionID: c.sessionID})
+	if err := t.writeEvent("session", session); err != nil {
+		logger.Info("failed to start event stream", "error", err)
+		c.close()
+	} else {
+		go c.writePump()
+	}
+
+	select {
+	case <-c.done:
+	case <-r.Context().Done():
+		c.close()
+	}
+	wsm.disconnect(c, logger)
+}
+
+// HandleLongPoll serves the last-resort long-poll transport. A POST opens a session and returns
+// its ID; a GET with the session query parameter waits for the session's packets.
+func (wsm *WebSocketManager) HandleLongPoll(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		if !wsm.admitHTTP(w, r) {
+			return
+		}
+		c, logger := wsm.newSessionClient(newLongPollTransport(wsm.sendQueueSize), r, "longpoll")
+		wsm.registerSession(c, logger)
+		go c.writePump()
+		go func() {
+			<-c.done
+			wsm.disconnect(c, logger)
+		}()
+		writeJSON(w, http.StatusCreated, models.TransportSession{SessionID: c.sessionID})
+
+	case http.MethodGet:
+		if !wsm.allowHTTPOrigin(w, r) {
+			return
+		}
+		c := wsm.sessionFor(r)
+		if c == nil {
+			http.Error(w, "Unknown session", http.StatusNotFound)
+			return
+		}
+		t, ok := c.transport.(*longPollTransport)
+		if !ok {
+			http.Error(w, "Session does not use long-polling", http.StatusBadRequest)
+			return
+		}
+		c.touch()
+		wait := maxLongPollWait
+		if wsm.readTimeout > 0 && wait > wsm.readTimeout/2 {
+			wait = wsm.readTimeout / 2
+		}
+		response := t.poll(r.Context(), wait)
+		c.touch()
+		writeJSON(w, http.StatusOK, response)
+
+	case http.MethodOptions:
+		wsm.allowHTTPOrigin(w, r)
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleSend accepts one packet for an SSE or long-poll session. Replies are delivered on the
+// session's stream or poll, exactly as they would be over a WebSocket.
+func (wsm *WebSocketManager) HandleSend(w http.ResponseWriter, r *http.Request) {
+	if !wsm.allowHTTPOrigin(w, r) || r.Method == http.MethodOptions {
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	c := wsm.sessionFor(r)
+	if c == nil {
+		http.Error(w, "Unknown session", http.StatusNotFound)
+		return
+	}
+	message, err := io.ReadAll(io.LimitReader(r.Body, wsm.maxMessageSize+1))
+	if err != nil || int64(len(message)) > wsm.maxMessageSize {
+		http.Error(w, "Message too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	c.inbound.Lock()
+	defer c.inbound.Unlock()
+	c.touch()
+	if !wsm.handleMessage(c, c.logger, message) {
+		c.closeAfterFlush(websocket.ClosePolicyViolation, "")
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// newSessionClient creates a client for an HTTP transport with a new, unguessable session ID.
+// HTTP transports always use the JSON codec.
+func (wsm *WebSocketManager) newSessionClient(t transport, r *http.Request, transportName string) (*client, *slog.Logger) {
+	logger := wsm.logger.With("conn_id", utils.GenerateConnectionID(), "remote_addr", r.RemoteAddr, "transport", transportName)
+	c := newClient(t, codec.JSON, wsm.sendQueueSize, wsm.slowConsumerPolicy, logger)
+	c.remoteIP = remoteIP(r.RemoteAddr)
+	c.sessionID = utils.GenerateSessionToken()
+	return c, logger
+}
+
+// registerSession makes a session's client reachable by its session ID and registers it like a
+// WebSocket connection.
+func (wsm *WebSocketManager) registerSession(c *client, logger *slog.Logger) {
+	wsm.clients.add(c)
+	wsm.startHeartbeat()
+	logger.Info("connection opened")
+}
+
+// sessionFor returns the HTTP transport client named by the session query parameter, or nil.
+func (wsm *WebSocketManager) sessionFor(r *http.Request) *client {
+	return wsm.clients.session(r.URL.Query().Get("session"))
+}
+
+// admitHTTP applies the checks a WebSocket upgrade gets before opening an HTTP transport session.
+func (wsm *WebSocketManager) admitHTTP(w http.ResponseWriter, r *http.Request) bool {
+	return wsm.allowHTTPOrigin(w, r) && wsm.admit(w, r)
+}
+
+// allowHTTPOrigin applies the WebSocket origin policy to an HTTP transport request and answers
+// CORS preflight requests. Without allowed origins, only same-origin requests are accepted.
+func (wsm *WebSocketManager) allowHTTPOrigin(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	allowed := origin == ""
+	if !allowed && wsm.upgrader.CheckOrigin != nil {
+		allowed = wsm.upgrader.CheckOrigin(r)
+	} else if !allowed {
+		u, err := url.Parse(origin)
+		allowed = err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	if !allowed {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return false
+	}
+
+	if origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	}
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,135 @@
+package managers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tictactoe/models"
+	"tictactoe/utils"
+)
+
+func newTransportServer(t *testing.T) *httptest.Server {
+	wsm := newTestManager()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sse", wsm.HandleSSE)
+	mux.HandleFunc("/poll", wsm.HandleLongPoll)
+	mux.HandleFunc("/send", wsm.HandleSend)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func postPacket(t *testing.T, server *httptest.Server, sessionID string, packet any) int {
+	t.Helper()
+	body, _ := json.Marshal(packet)
+	resp, err := http.Post(server.URL+"/send?session="+sessionID, "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatalf("Failed to send packet: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func connectPacket(username string) models.ConnectPacket {
+	return models.ConnectPacket{
+		BasePacket:      models.BasePacket{Type: utils.ConnectPacketType, RequestID: "c1"},
+		Username:        username,
+		ProtocolVersion: utils.ProtocolVersion,
+	}
+}
+
+func TestSSETransport(t *testing.T) {
+	server := newTransportServer(t)
+
+	resp, err := http.Get(server.URL + "/sse")
+	if err != nil {
+		t.Fatalf("Failed to open event stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %q", ct)
+	}
+
+	// readEvent returns the next event's name and data
+	events := bufio.NewScanner(resp.Body)
+	readEvent := func() (string, string) {
+		var event, data string
+		for events.Scan() {
+			line := events.Text()
+			switch {
+			case line == "" && data != "":
+				return event, data
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+		t.Fatalf("Event stream ended: %v", events.Err())
+		return "", ""
+	}
+
+	event, data := readEvent()
+	var session models.TransportSession
+	if event != "session" || json.Unmarshal([]byte(data), &session) != nil || session.SessionID == "" {
+		t.Fatalf("Expected a session event, got %q %q", event, data)
+	}
+
+	if code := postPacket(t, server, session.SessionID, connectPacket("alice")); code != http.StatusAccepted {
+		t.Fatalf("Expected the packet to be accepted, got %d", code)
+	}
+	_, data = readEvent()
+	var welcome models.WelcomePacket
+	json.Unmarshal([]byte(data), &welcome)
+	if welcome.Type != utils.WelcomePacketType || welcome.RequestID != "c1" {
+		t.Errorf("Expected a welcome reply on the stream, got %s", data)
+	}
+
+	if code := postPacket(t, server, "nope", connectPacket("bob")); code != http.StatusNotFound {
+		t.Errorf("Expected an unknown session to be not found, got %d", code)
+	}
+}
+
+func TestLongPollTransport(t *testing.T) {
+	server := newTransportServer(t)
+
+	resp, err := http.Post(server.URL+"/poll", "application/json", nil)
+	if err != nil {
+		t.Fatalf("Failed to open long-poll session: %v", err)
+	}
+	var session models.TransportSession
+	json.NewDecoder(resp.Body).Decode(&session)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || session.SessionID == "" {
+		t.Fatalf("Expected a session, got %d %+v", resp.StatusCode, session)
+	}
+
+	postPacket(t, server, session.SessionID, connectPacket("alice"))
+	postPacket(t, server, session.SessionID, models.BasePacket{Type: "teleport", RequestID: "t1"})
+
+	// Replies may be split across polls, so poll until both have arrived
+	var types []string
+	for i := 0; i < 5 && len(types) < 3; i++ {
+		resp, err := http.Get(fmt.Sprintf("%s/poll?session=%s", server.URL, session.SessionID))
+		if err != nil {
+			t.Fatalf("Failed to poll: %v", err)
+		}
+		var poll models.PollResponse
+		json.NewDecoder(resp.Body).Decode(&poll)
+		resp.Body.Close()
+		for _, packet := range poll.Packets {
+			var base models.BasePacket
+			json.Unmarshal(packet, &base)
+			types = append(types, base.Type)
+		}
+	}
+	want := []string{utils.WelcomePacketType, utils.UserStatsPacketType, utils.ErrorPacketType}
+	if strings.Join(types, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected %v, got %v", want, types)
+	}
+}
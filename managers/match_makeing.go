@@ -2,61 +2,372 @@ package managers
 
 import (
 	"context"
+	"errors"
+	"log/slog"
 	"sync"
+	"tictactoe/config"
 	"tictactoe/models" // Adjust the import path based on your actual project structure
 	"time"
 )
 
+// ErrMatchmakingClosed is returned by RequestMatch once the manager has been closed.
+var ErrMatchmakingClosed = errors.New("matchmaking is closed")
+
+// remoteClaimGrace is how long a user whose queue entry was claimed by another node waits for
+// that node's word before giving up.
+const remoteClaimGrace = 5 * time.Second
+
+// sharedQueueTimeout bounds every call to the shared queue.
+const sharedQueueTimeout = 2 * time.Second
+
 // MatchmakingManager handles the matchmaking process.
 type MatchmakingManager struct {
 	mu          sync.Mutex
-	matchmaking map[*models.User]chan *models.Game
+	matchmaking map[*models.User]*waiter
+	tickets     map[string]*waiter // Waiters offered to other nodes, by ticket
+	shared      sharedQueue        // Nil when users are only matched with users on this node
+	games       *GameManager       // Creates the games of matched users
+	logger      *slog.Logger
+	timeout     time.Duration // How long a user waits for an opponent
+	closed      chan struct{} // Closed when the manager stops accepting requests
+	closeOnce   sync.Once
+}
+
+// waiter is a user waiting for an opponent.
+type waiter struct {
+	user    *models.User
+	options models.GameOptions           // What they asked for; only waiters asking for the same are matched
+	matched chan *models.Game            // Receives the game when a user on this node is matched with them, or nil if they cannot be
+	requeue chan struct{}                // Signalled when the user who took them from the pool could not play, so they wait again
+	claimed chan models.MatchFoundPacket // Receives the game start when another node claims them
+	ticket  string                       // Identifies their entry in the shared queue; empty if there is none. Protected by mu
 }
 
+// sharedQueue offers waiting users to other nodes, so users on different nodes can be matched.
+type sharedQueue interface {
+	// ticket returns the ticket that identifies an offer of a waiting user to other nodes.
+	ticket(user *models.User, options models.GameOptions) (string, error)
+	// enqueue offers a waiting user to other nodes under their ticket.
+	enqueue(ctx context.Context, ticket string) error
+	// withdraw takes an offer back, reporting false if another node has already claimed it.
+	withdraw(ctx context.Context, ticket string) (bool, error)
+	// claim matches user with the longest waiting user offered by another node with the same options
+	// and returns their game, or nil if nobody is waiting elsewhere. The other node is told about the
+	// game.
+	claim(ctx context.Context, user *models.User, options models.GameOptions) (*models.Game, error)
+}
+
+// remoteMatch is returned by RequestMatch when another node claimed the waiting user. The game is
+// hosted by that node, which built the user's game start packet.
+type remoteMatch struct {
+	start models.MatchFoundPacket
+}
+
+func (e *remoteMatch) Error() string { return "matched by another node" }
+
 // NewMatchmakingManager creates a new MatchmakingManager instance.
 func NewMatchmakingManager() *MatchmakingManager {
 	return &MatchmakingManager{
-		matchmaking: make(map[*models.User]chan *models.Game),
+		matchmaking: make(map[*models.User]*waiter),
+		tickets:     make(map[string]*waiter),
+		logger:      slog.Default(),
+		timeout:     time.Duration(config.Default().Matchmaking.Timeout),
+		closed:      make(chan struct{}),
 	}
 }
 
-// RequestMatch handles a new matchmaking request.
-func (m *MatchmakingManager) RequestMatch(ctx context.Context, user *models.User) (*models.Game, error) {
-	matchChan := make(chan *models.Game, len(m.matchmaking)+1)
+// Configure applies matchmaking settings. It must be called before the manager is used.
+func (m *MatchmakingManager) Configure(cfg config.Matchmaking) {
+	m.timeout = time.Duration(cfg.Timeout)
+}
 
-	m.mu.Lock()
-	for opponent, oppChan := range m.matchmaking {
-		// Found an opponent, remove them from the matchmaking pool and start a game
-		delete(m.matchmaking, opponent)
+// RequestMatch handles a new matchmaking request, matching the user with someone asking for the
+// same options. When another node hosts the game, the error is a *remoteMatch carrying the user's
+// game start packet. It fails with models.ErrAlreadyInGame if the user is already playing, and with
+// models.ErrAlreadyQueued if they are already waiting.
+//
+// Who to match is decided under the manager's lock, but the shared queue is only called outside it,
+// so a slow backplane holds up the request making the call and no other.
+func (m *MatchmakingManager) RequestMatch(ctx context.Context, user *models.User, options models.GameOptions) (*models.Game, error) {
+	start := time.Now()
+	w := &waiter{user: user, options: options, matched: make(chan *models.Game, 1), requeue: make(chan struct{}, 1), claimed: make(chan models.MatchFoundPacket, 1)}
+	offered := m.shared == nil // Whether other nodes have been searched and offered the user
+	for {
+		m.mu.Lock()
+		opponent, opp, err := m.takeWaiter(user, options)
+		waiting := err == nil && opp == nil && offered
+		if waiting && len(w.claimed) == 0 {
+			// No opponent found, add user to the matchmaking pool
+			m.matchmaking[user] = w
+		}
 		m.mu.Unlock()
 
-		game := models.NewGame(user, opponent)
+		switch {
+		case err != nil:
+			m.withdrawOffer(w)
+			return nil, err
+		case opp != nil:
+			if !m.withdrawOffer(w) {
+				// Another node claimed the user while they were being offered, so they cannot play
+				// this opponent after all, who waits again
+				opp.requeue <- struct{}{}
+				return m.await(ctx, user, w, start)
+			}
+			game, err := m.matchWith(user, opponent, opp, options)
+			if err != nil {
+				return nil, err
+			}
+			if game != nil {
+				matchmakingWait.Observe(time.Since(start).Seconds(), "matched")
+				return game, nil
+			}
+			offered = m.shared == nil // The offer was withdrawn, so make it again
+			continue
+		case waiting:
+			return m.await(ctx, user, w, start)
+		}
 
-		// Notify both players' channels
-		matchChan <- game
-		oppChan <- game
-		return game, nil
+		// Nobody is waiting here, so try users waiting on other nodes, then offer the user to them
+		claimCtx, cancel := context.WithTimeout(ctx, sharedQueueTimeout)
+		game, err := m.shared.claim(claimCtx, user, options)
+		cancel()
+		if errors.Is(err, models.ErrAlreadyInGame) {
+			return nil, err
+		}
+		if err != nil {
+			m.logger.Warn("failed to claim a user waiting on another node", "username", user.Username, "error", err)
+		}
+		if game != nil {
+			m.logger.Info("match found on another node", "game_id", game.ID, "username", user.Username, "opponent", game.Opponent(user).Username)
+			matchmakingWait.Observe(time.Since(start).Seconds(), "matched")
+			return game, nil
+		}
+		m.offer(user, w)
+		offered = true
 	}
+}
 
-	// No opponent found, add user to the matchmaking pool
-	m.matchmaking[user] = matchChan
+// takeWaiter removes and returns a user waiting for the same options, or nil if there is none. It
+// fails if the user cannot be matched at all. It must be called with m.mu held.
+func (m *MatchmakingManager) takeWaiter(user *models.User, options models.GameOptions) (*models.User, *waiter, error) {
+	select {
+	case <-m.closed:
+		return nil, nil, ErrMatchmakingClosed
+	default:
+	}
+	if m.games.CurrentGame(user) != nil {
+		return nil, nil, models.ErrAlreadyInGame
+	}
+	if _, waiting := m.matchmaking[user]; waiting {
+		return nil, nil, models.ErrAlreadyQueued
+	}
+	for opponent, opp := range m.matchmaking {
+		if opp.options == options {
+			delete(m.matchmaking, opponent)
+			return opponent, opp, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// matchWith starts a game between user and a waiter taken from the pool and tells the waiter about
+// it. It returns nil if the game could not be started because of the waiter, such as when another
+// node claimed them first, and fails if it could not be started because of the user, in which case
+// the waiter goes back to waiting.
+func (m *MatchmakingManager) matchWith(user, opponent *models.User, opp *waiter, options models.GameOptions) (*models.Game, error) {
+	if !m.withdrawOffer(opp) {
+		return nil, nil // Another node got to them first and tells them
+	}
+	game, err := m.games.CreateGame(user, opponent, options)
+	if err != nil {
+		m.logger.Warn("failed to create matched game", "username", user.Username, "opponent", opponent.Username, "error", err)
+		if seatTaken(err, opponent) {
+			// They started a game some other way while waiting
+			opp.matched <- nil
+			return nil, nil
+		}
+		opp.requeue <- struct{}{}
+		return nil, err
+	}
+	m.logger.Info("match found", "game_id", game.ID, "username", user.Username, "opponent", opponent.Username)
+
+	// Notify the opponent's channel
+	opp.matched <- game
+	return game, nil
+}
+
+// offer puts the user in the shared queue so other nodes can claim them. The ticket is known to
+// matchedRemotely before the offer is made, so a claim can never arrive ahead of it.
+func (m *MatchmakingManager) offer(user *models.User, w *waiter) {
+	ticket, err := m.shared.ticket(user, w.options)
+	if err != nil {
+		m.logger.Warn("failed to offer user to other nodes", "username", user.Username, "error", err)
+		return
+	}
+	m.mu.Lock()
+	w.ticket = ticket
+	m.tickets[ticket] = w
 	m.mu.Unlock()
 
-	select {
-	case game := <-matchChan:
-		// Match found
-		return game, nil
-	case <-time.After(120 * time.Second):
-		// Matchmaking timeout
-		m.mu.Lock()
-		delete(m.matchmaking, user)
-		m.mu.Unlock()
-		return nil, nil // or an error indicating timeout
-	case <-ctx.Done():
-		// Context cancellation
-		m.mu.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), sharedQueueTimeout)
+	defer cancel()
+	if err := m.shared.enqueue(ctx, ticket); err != nil {
+		m.logger.Warn("failed to offer user to other nodes", "username", user.Username, "error", err)
+		m.forget(w)
+	}
+}
+
+// forget drops a waiter's ticket once it can no longer be claimed.
+func (m *MatchmakingManager) forget(w *waiter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if w.ticket != "" {
+		delete(m.tickets, w.ticket)
+		w.ticket = ""
+	}
+}
+
+// await waits until the user is matched, gives up or the manager closes.
+func (m *MatchmakingManager) await(ctx context.Context, user *models.User, w *waiter, start time.Time) (*models.Game, error) {
+	defer m.forget(w)
+	m.logger.Debug("waiting for opponent", "username", user.Username)
+
+	timeout := time.NewTimer(m.timeout)
+	defer timeout.Stop()
+	var outcome string
+	var err error
+	for outcome == "" {
+		select {
+		case game := <-w.matched:
+			if game != nil {
+				// Match found
+				matchmakingWait.Observe(time.Since(start).Seconds(), "matched")
+				return game, nil
+			}
+			outcome = "timeout" // Matched with someone who could not play after all
+		case <-w.requeue:
+			// Taken by someone who could not play, so wait for the rest of the time
+			m.rejoin(user, w)
+		case packet := <-w.claimed:
+			// Match found by another node
+			matchmakingWait.Observe(time.Since(start).Seconds(), "matched")
+			return nil, &remoteMatch{start: packet}
+		case <-timeout.C:
+			// Matchmaking timeout
+			outcome = "timeout"
+		case <-m.closed:
+			// Manager closed while waiting
+			outcome, err = "closed", ErrMatchmakingClosed
+		case <-ctx.Done():
+			// Context cancellation
+			outcome, err = "cancelled", ctx.Err()
+		}
+	}
+
+	m.mu.Lock()
+	_, waiting := m.matchmaking[user]
+	if waiting {
 		delete(m.matchmaking, user)
+	}
+	m.mu.Unlock()
+	if !waiting || !m.withdrawOffer(w) {
+		// Someone here or on another node took the user just now and is about to say so
+		select {
+		case game := <-w.matched:
+			if game != nil {
+				matchmakingWait.Observe(time.Since(start).Seconds(), "matched")
+				return game, nil
+			}
+		case packet := <-w.claimed:
+			matchmakingWait.Observe(time.Since(start).Seconds(), "matched")
+			return nil, &remoteMatch{start: packet}
+		case <-w.requeue:
+			// The one who took them could not play, and the user has stopped waiting
+		case <-time.After(remoteClaimGrace):
+			m.logger.Warn("claim by another node never arrived", "username", user.Username)
+		}
+	}
+	matchmakingWait.Observe(time.Since(start).Seconds(), outcome)
+	if outcome == "timeout" {
+		m.logger.Info("matchmaking timed out", "username", user.Username)
+	}
+	return nil, err // Nil on timeout
+}
+
+// rejoin puts a waiter who was taken from the pool back in it and offers them to other nodes again.
+func (m *MatchmakingManager) rejoin(user *models.User, w *waiter) {
+	m.mu.Lock()
+	select {
+	case <-m.closed:
 		m.mu.Unlock()
-		return nil, ctx.Err()
+		return // await sees the manager closed
+	default:
 	}
+	m.matchmaking[user] = w
+	m.mu.Unlock()
+	if m.shared != nil {
+		m.offer(user, w)
+	}
+}
+
+// withdrawOffer takes a waiter's offer back from other nodes and reports whether they are still
+// free to be matched here. It must not be called with m.mu held.
+func (m *MatchmakingManager) withdrawOffer(w *waiter) bool {
+	m.mu.Lock()
+	ticket := w.ticket
+	m.mu.Unlock()
+	if ticket == "" {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sharedQueueTimeout)
+	defer cancel()
+	removed, err := m.shared.withdraw(ctx, ticket)
+	if err != nil {
+		// Without the backplane nobody else can claim them either
+		m.logger.Warn("failed to withdraw user from other nodes", "ticket", ticket, "error", err)
+		return true
+	}
+	if removed {
+		m.forget(w)
+	}
+	return removed
+}
+
+// matchedRemotely hands the game start packet built by another node to the waiter it claimed and
+// seats them in that game. It reports whether the waiter was still waiting.
+func (m *MatchmakingManager) matchedRemotely(ticket string, start models.MatchFoundPacket) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w, ok := m.tickets[ticket]
+	if !ok {
+		return false
+	}
+	for user, other := range m.matchmaking {
+		if other == w {
+			delete(m.matchmaking, user)
+		}
+	}
+	select {
+	case w.claimed <- start:
+	default:
+		return false
+	}
+	if !m.games.seatRemote(w.user, start) {
+		m.logger.Warn("claimed user already has a game", "username", w.user.Username, "game_id", start.GameID)
+	}
+	return true
+}
+
+// QueueDepth returns the number of users waiting for an opponent.
+func (m *MatchmakingManager) QueueDepth() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.matchmaking)
+}
+
+// Close stops the manager from accepting new requests and releases every waiting user.
+func (m *MatchmakingManager) Close() {
+	m.closeOnce.Do(func() {
+		close(m.closed)
+	})
 }
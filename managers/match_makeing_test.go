@@ -0,0 +1,211 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"tictactoe/models"
+)
+
+// stalledQueue is a shared queue whose claims for one user hang until released, like a backplane
+// that stopped answering.
+type stalledQueue struct {
+	stalled  string
+	claiming chan struct{} // Closed once the stalled user's claim has started
+	release  chan struct{}
+}
+
+func (q *stalledQueue) ticket(user *models.User, options models.GameOptions) (string, error) {
+	return user.Username, nil
+}
+
+func (q *stalledQueue) enqueue(ctx context.Context, ticket string) error { return nil }
+
+func (q *stalledQueue) withdraw(ctx context.Context, ticket string) (bool, error) { return true, nil }
+
+func (q *stalledQueue) claim(ctx context.Context, user *models.User, options models.GameOptions) (*models.Game, error) {
+	if user.Username == q.stalled {
+		close(q.claiming)
+		select {
+		case <-q.release:
+		case <-ctx.Done():
+		}
+	}
+	return nil, nil
+}
+
+func TestStalledSharedQueueHoldsUpOnlyItsCaller(t *testing.T) {
+	m := NewMatchmakingManager()
+	m.games = NewGameManager()
+	queue := &stalledQueue{stalled: "alice", claiming: make(chan struct{}), release: make(chan struct{})}
+	m.shared = queue
+	alice, bob := models.NewUser("alice", ""), models.NewUser("bob", "")
+
+	games := make(chan *models.Game, 2)
+	request := func(user *models.User) {
+		game, err := m.RequestMatch(context.Background(), user, models.GameOptions{})
+		if err != nil {
+			t.Errorf("Expected %s to be matched, got %v", user.Username, err)
+		}
+		games <- game
+	}
+	go request(alice)
+	<-queue.claiming
+
+	// Bob is queued while alice's claim hangs
+	go request(bob)
+	for deadline := time.Now().Add(time.Second); m.QueueDepth() != 1; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for bob to be queued")
+		}
+	}
+
+	// Once the backplane answers, alice finds bob
+	close(queue.release)
+	first, second := <-games, <-games
+	if first == nil || second == nil || first.ID != second.ID {
+		t.Errorf("Expected alice and bob in the same game, got %v and %v", first, second)
+	}
+}
+
+// withdrawHookQueue is a shared queue that runs a hook the first time an offer is withdrawn.
+type withdrawHookQueue struct {
+	once       sync.Once
+	onWithdraw func(ticket string)
+}
+
+func (q *withdrawHookQueue) ticket(user *models.User, options models.GameOptions) (string, error) {
+	return user.Username, nil
+}
+
+func (q *withdrawHookQueue) enqueue(ctx context.Context, ticket string) error { return nil }
+
+func (q *withdrawHookQueue) withdraw(ctx context.Context, ticket string) (bool, error) {
+	q.once.Do(func() { q.onWithdraw(ticket) })
+	return true, nil
+}
+
+func (q *withdrawHookQueue) claim(ctx context.Context, user *models.User, options models.GameOptions) (*models.Game, error) {
+	return nil, nil
+}
+
+func TestRequesterWhoCannotPlayLeavesOpponentQueued(t *testing.T) {
+	m := NewMatchmakingManager()
+	m.games = NewGameManager()
+	alice, bob, carol, dave := models.NewUser("alice", ""), models.NewUser("bob", ""), models.NewUser("carol", ""), models.NewUser("dave", "")
+
+	// Alice starts another game just as she takes bob from the pool
+	m.shared = &withdrawHookQueue{onWithdraw: func(ticket string) {
+		if _, err := m.games.CreateGame(alice, carol, models.GameOptions{}); err != nil {
+			t.Errorf("Failed to seat alice elsewhere: %v", err)
+		}
+	}}
+
+	bobGame := make(chan *models.Game, 1)
+	go func() {
+		game, err := m.RequestMatch(context.Background(), bob, models.GameOptions{})
+		if err != nil {
+			t.Errorf("Expected bob to be matched, got %v", err)
+		}
+		bobGame <- game
+	}()
+	waitForQueueDepth(t, m, 1)
+
+	if _, err := m.RequestMatch(context.Background(), alice, models.GameOptions{}); !errors.Is(err, models.ErrAlreadyInGame) {
+		t.Fatalf("Expected alice to be told she is already in a game, got %v", err)
+	}
+	waitForQueueDepth(t, m, 1)
+
+	game, err := m.RequestMatch(context.Background(), dave, models.GameOptions{})
+	if err != nil || game == nil {
+		t.Fatalf("Expected dave to be matched with bob, got %v, %v", game, err)
+	}
+	if other := <-bobGame; other == nil || other.ID != game.ID {
+		t.Errorf("Expected bob in dave's game %s, got %v", game.ID, other)
+	}
+}
+
+func waitForQueueDepth(t *testing.T, m *MatchmakingManager, depth int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); m.QueueDepth() != depth; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d users to be queued, have %d", depth, m.QueueDepth())
+		}
+	}
+}
+
+// claimingQueue is a shared queue where another node claims one user as soon as they are offered
+// and someone is waiting on this node.
+type claimingQueue struct {
+	m        *MatchmakingManager
+	claimed  string
+	offering chan struct{} // Closed once the claimed user's offer has started
+}
+
+func (q *claimingQueue) ticket(user *models.User, options models.GameOptions) (string, error) {
+	return user.Username, nil
+}
+
+func (q *claimingQueue) enqueue(ctx context.Context, ticket string) error {
+	if ticket == q.claimed {
+		// Hold the offer until someone is waiting here, so the user finds them next
+		close(q.offering)
+		for q.m.QueueDepth() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	return nil
+}
+
+func (q *claimingQueue) withdraw(ctx context.Context, ticket string) (bool, error) {
+	if ticket != q.claimed {
+		return true, nil
+	}
+	q.m.matchedRemotely(ticket, models.MatchFoundPacket{GameID: "remote"})
+	return false, nil
+}
+
+func (q *claimingQueue) claim(ctx context.Context, user *models.User, options models.GameOptions) (*models.Game, error) {
+	return nil, nil
+}
+
+func TestUserClaimedElsewhereLeavesOpponentQueued(t *testing.T) {
+	m := NewMatchmakingManager()
+	m.games = NewGameManager()
+	queue := &claimingQueue{m: m, claimed: "alice", offering: make(chan struct{})}
+	m.shared = queue
+	alice, bob, dave := models.NewUser("alice", ""), models.NewUser("bob", ""), models.NewUser("dave", "")
+
+	aliceDone := make(chan error, 1)
+	go func() {
+		_, err := m.RequestMatch(context.Background(), alice, models.GameOptions{})
+		aliceDone <- err
+	}()
+	<-queue.offering
+	bobGame := make(chan *models.Game, 1)
+	go func() {
+		game, err := m.RequestMatch(context.Background(), bob, models.GameOptions{})
+		if err != nil {
+			t.Errorf("Expected bob to be matched, got %v", err)
+		}
+		bobGame <- game
+	}()
+
+	// Alice takes bob from the pool, but another node has claimed her in the meantime
+	var remote *remoteMatch
+	if err := <-aliceDone; !errors.As(err, &remote) {
+		t.Fatalf("Expected alice to be matched by another node, got %v", err)
+	}
+	waitForQueueDepth(t, m, 1)
+
+	game, err := m.RequestMatch(context.Background(), dave, models.GameOptions{})
+	if err != nil || game == nil {
+		t.Fatalf("Expected dave to be matched with bob, got %v, %v", game, err)
+	}
+	if other := <-bobGame; other == nil || other.ID != game.ID {
+		t.Errorf("Expected bob in dave's game %s, got %v", game.ID, other)
+	}
+}
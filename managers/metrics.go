@@ -0,0 +1,84 @@
+package managers
+
+import (
+	"tictactoe/metrics"
+)
+
+var (
+	packetsReceived = metrics.NewCounterVec(
+		"tictactoe_packets_received_total",
+		"Inbound packets by type.",
+		"type",
+	)
+	// Labelled by code rather than message: each code stands for one message, and codes are a fixed
+	// set that clients match on, so the series stay few and do not change when a message is reworded.
+	errorPacketsSent = metrics.NewCounterVec(
+		"tictactoe_error_packets_total",
+		"Error packets sent to clients by code.",
+		"code",
+	)
+	matchmakingWait = metrics.NewHistogramVec(
+		"tictactoe_matchmaking_wait_seconds",
+		"Time spent in RequestMatch by outcome.",
+		[]float64{.1, .5, 1, 2.5, 5, 10, 30, 60, 120},
+		"outcome",
+	)
+	connectionsReaped = metrics.NewCounterVec(
+		"tictactoe_connections_reaped_total",
+		"Connections closed because they stopped answering pings.",
+	)
+	sendQueueOverflows = metrics.NewCounterVec(
+		"tictactoe_send_queue_overflows_total",
+		"Outbound messages that did not fit in a slow client's send queue, by policy applied.",
+		"policy",
+	)
+	rateLimited = metrics.NewCounterVec(
+		"tictactoe_rate_limited_total",
+		"Packets and connections refused by rate limits, by budget.",
+		"scope",
+	)
+	apiRequests = metrics.NewCounterVec(
+		"tictactoe_api_requests_total",
+		"REST API requests by route and response status.",
+		"route", "status",
+	)
+	gamesCompleted = metrics.NewCounterVec(
+		"tictactoe_games_completed_total",
+		"Games that ended, by outcome.",
+		"outcome",
+	)
+	moveDuration = metrics.NewHistogramVec(
+		"tictactoe_move_duration_seconds",
+		"Time taken by GameManager.UpdateGame by result.",
+		metrics.DefaultBuckets,
+		"result",
+	)
+)
+
+// packetTypeLabel returns the metric label for an inbound packet type. Only registered types are
+// used as labels, which bounds their number.
+func packetTypeLabel(packetType string) string {
+	if _, ok := packetHandlers[packetType]; ok {
+		return packetType
+	}
+	return "unknown"
+}
+
+// RegisterGauges exposes the live state of the managers as gauges on the default metrics registry.
+func RegisterGauges(wsm *WebSocketManager, gameManager *GameManager, matchmakingManager *MatchmakingManager) {
+	metrics.NewGaugeFunc(
+		"tictactoe_connected_clients",
+		"Connections currently open over every transport.",
+		func() float64 { return float64(wsm.ClientCount()) },
+	)
+	metrics.NewGaugeFunc(
+		"tictactoe_matchmaking_queue_depth",
+		"Users currently waiting for an opponent.",
+		func() float64 { return float64(matchmakingManager.QueueDepth()) },
+	)
+	metrics.NewGaugeFunc(
+		"tictactoe_active_games",
+		"Games currently in progress.",
+		func() float64 { return float64(gameManager.ActiveGames()) },
+	)
+}
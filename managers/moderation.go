@@ -0,0 +1,210 @@
+package managers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"tictactoe/models"
+	"time"
+)
+
+// ModerationManager tracks bans, suspensions and mutes and keeps an audit trail of every change.
+// Sanctions are held in this node's memory only: when several nodes share a backplane, a sanction
+// issued on one node does not bar the subject from the others.
+type ModerationManager struct {
+	sanctions map[string]*models.Sanction // Keyed by kind, subject type and subject
+	audit     []models.AuditEntry
+	sink      io.Writer // Optional persistent audit trail, one JSON entry per line written as it is recorded
+	now       func() time.Time
+	logger    *slog.Logger
+	mu        sync.RWMutex // ensures thread-safe access to sanctions and the audit trail
+}
+
+// NewModerationManager creates a new ModerationManager instance.
+func NewModerationManager() *ModerationManager {
+	return &ModerationManager{
+		sanctions: make(map[string]*models.Sanction),
+		now:       time.Now,
+		logger:    slog.Default(),
+	}
+}
+
+// SetAuditSink makes every future audit entry also be written to w as a line of JSON. Each entry is
+// written with a single unbuffered Write, so the trail survives a crash up to the last action.
+func (m *ModerationManager) SetAuditSink(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sink = w
+}
+
+// Ban bars a subject from connecting and playing. A zero duration makes the ban permanent.
+func (m *ModerationManager) Ban(actor string, subjectType models.SubjectType, subject, reason string, duration time.Duration) (*models.Sanction, error) {
+	return m.issue(models.SanctionBan, actor, subjectType, subject, reason, duration)
+}
+
+// Suspend temporarily bars a subject from connecting and playing.
+func (m *ModerationManager) Suspend(actor string, subjectType models.SubjectType, subject, reason string, duration time.Duration) (*models.Sanction, error) {
+	if duration <= 0 {
+		return nil, errors.New("suspension requires a positive duration")
+	}
+	return m.issue(models.SanctionSuspension, actor, subjectType, subject, reason, duration)
+}
+
+// Mute stops a subject from sending chat messages. A zero duration makes the mute permanent.
+func (m *ModerationManager) Mute(actor string, subjectType models.SubjectType, subject, reason string, duration time.Duration) (*models.Sanction, error) {
+	return m.issue(models.SanctionMute, actor, subjectType, subject, reason, duration)
+}
+
+// Lift removes an active sanction of the given kind from a subject.
+func (m *ModerationManager) Lift(actor string, kind models.SanctionKind, subjectType models.SubjectType, subject, reason string) error {
+	if actor == "" {
+		return errors.New("moderation actions require an actor")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := sanctionKey(kind, subjectType, subject)
+	if _, exists := m.sanctions[key]; !exists {
+		return models.ErrSanctionNotFound
+	}
+	delete(m.sanctions, key)
+
+	return m.record(models.AuditEntry{
+		Action:      models.AuditActionLift,
+		Kind:        kind,
+		SubjectType: subjectType,
+		Subject:     subject,
+		Actor:       actor,
+		Reason:      reason,
+		At:          m.now(),
+	})
+}
+
+// CheckAccess returns the active ban or suspension that bars the user, or nil if they may play.
+// When several apply, the one that ends last is returned.
+func (m *ModerationManager) CheckAccess(username, deviceID string) *models.Sanction {
+	return m.strongest(username, deviceID, models.SanctionBan, models.SanctionSuspension)
+}
+
+// CheckMute returns the active mute on the user, or nil if they may chat.
+func (m *ModerationManager) CheckMute(username, deviceID string) *models.Sanction {
+	return m.strongest(username, deviceID, models.SanctionMute)
+}
+
+// AuditLog returns a copy of every moderation action recorded so far.
+func (m *ModerationManager) AuditLog() []models.AuditEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries := make([]models.AuditEntry, len(m.audit))
+	copy(entries, m.audit)
+	return entries
+}
+
+func (m *ModerationManager) issue(kind models.SanctionKind, actor string, subjectType models.SubjectType, subject, reason string, duration time.Duration) (*models.Sanction, error) {
+	if actor == "" {
+		return nil, errors.New("moderation actions require an actor")
+	}
+	if subject == "" {
+		return nil, errors.New("moderation actions require a subject")
+	}
+	if subjectType != models.SubjectUsername && subjectType != models.SubjectDevice {
+		return nil, errors.New("unknown subject type")
+	}
+	if duration < 0 {
+		return nil, errors.New("duration cannot be negative")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	sanction := &models.Sanction{
+		Kind:        kind,
+		SubjectType: subjectType,
+		Subject:     subject,
+		Reason:      reason,
+		IssuedBy:    actor,
+		IssuedAt:    now,
+	}
+	entry := models.AuditEntry{
+		Action:      models.AuditActionIssue,
+		Kind:        kind,
+		SubjectType: subjectType,
+		Subject:     subject,
+		Actor:       actor,
+		Reason:      reason,
+		At:          now,
+	}
+	if duration > 0 {
+		sanction.ExpiresAt = now.Add(duration)
+		expiresAt := sanction.ExpiresAt
+		entry.ExpiresAt = &expiresAt
+	}
+
+	// A new sanction of the same kind replaces the previous one
+	m.sanctions[sanctionKey(kind, subjectType, subject)] = sanction
+	if err := m.record(entry); err != nil {
+		return sanction, err
+	}
+	return sanction, nil
+}
+
+// record appends an entry to the audit trail. The caller must hold m.mu.
+func (m *ModerationManager) record(entry models.AuditEntry) error {
+	m.audit = append(m.audit, entry)
+	m.logger.Info("moderation action", "action", entry.Action, "kind", entry.Kind, "subject_type", entry.SubjectType,
+		"subject", entry.Subject, "actor", entry.Actor, "reason", entry.Reason)
+	if m.sink == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := m.sink.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return nil
+}
+
+// strongest returns the active sanction of the given kinds that ends last, or nil if none apply.
+func (m *ModerationManager) strongest(username, deviceID string, kinds ...models.SanctionKind) *models.Sanction {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := m.now()
+	var found *models.Sanction
+	for _, kind := range kinds {
+		candidates := []string{sanctionKey(kind, models.SubjectUsername, username)}
+		if deviceID != "" {
+			candidates = append(candidates, sanctionKey(kind, models.SubjectDevice, deviceID))
+		}
+		for _, key := range candidates {
+			sanction, exists := m.sanctions[key]
+			if !exists || !sanction.IsActive(now) {
+				continue
+			}
+			if found == nil || outlasts(sanction, found) {
+				found = sanction
+			}
+		}
+	}
+	return found
+}
+
+// outlasts reports whether sanction a ends after sanction b.
+func outlasts(a, b *models.Sanction) bool {
+	if a.IsPermanent() {
+		return !b.IsPermanent()
+	}
+	return !b.IsPermanent() && a.ExpiresAt.After(b.ExpiresAt)
+}
+
+func sanctionKey(kind models.SanctionKind, subjectType models.SubjectType, subject string) string {
+	return string(kind) + "|" + string(subjectType) + "|" + subject
+}
@@ -0,0 +1,90 @@
+package managers
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"tictactoe/models"
+)
+
+func TestModerationBanAndSuspension(t *testing.T) {
+	moderationManager := NewModerationManager()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	moderationManager.now = func() time.Time { return now }
+
+	if sanction := moderationManager.CheckAccess("user1", "device1"); sanction != nil {
+		t.Fatalf("Expected no sanction, got %+v", sanction)
+	}
+
+	// A suspension on the device applies to any username using it
+	if _, err := moderationManager.Suspend("mod", models.SubjectDevice, "device1", "spam", time.Hour); err != nil {
+		t.Fatalf("Suspend failed: %v", err)
+	}
+	sanction := moderationManager.CheckAccess("user2", "device1")
+	if sanction == nil || sanction.Kind != models.SanctionSuspension {
+		t.Fatalf("Expected suspension, got %+v", sanction)
+	}
+	if !sanction.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("Expected suspension to end at %v, got %v", now.Add(time.Hour), sanction.ExpiresAt)
+	}
+
+	// A permanent ban outlasts the suspension
+	if _, err := moderationManager.Ban("mod", models.SubjectUsername, "user2", "abuse", 0); err != nil {
+		t.Fatalf("Ban failed: %v", err)
+	}
+	if sanction := moderationManager.CheckAccess("user2", "device1"); sanction == nil || !sanction.IsPermanent() {
+		t.Fatalf("Expected permanent ban, got %+v", sanction)
+	}
+
+	// The suspension expires on its own
+	now = now.Add(2 * time.Hour)
+	if sanction := moderationManager.CheckAccess("user1", "device1"); sanction != nil {
+		t.Errorf("Expected suspension to have expired, got %+v", sanction)
+	}
+
+	if err := moderationManager.Lift("mod", models.SanctionBan, models.SubjectUsername, "user2", "appeal"); err != nil {
+		t.Fatalf("Lift failed: %v", err)
+	}
+	if sanction := moderationManager.CheckAccess("user2", "device1"); sanction != nil {
+		t.Errorf("Expected ban to be lifted, got %+v", sanction)
+	}
+
+	audit := moderationManager.AuditLog()
+	if len(audit) != 3 {
+		t.Fatalf("Expected 3 audit entries, got %d", len(audit))
+	}
+	if audit[2].Action != models.AuditActionLift || audit[2].Actor != "mod" || audit[2].Reason != "appeal" {
+		t.Errorf("Unexpected lift audit entry: %+v", audit[2])
+	}
+}
+
+func TestModerationRequiresActor(t *testing.T) {
+	moderationManager := NewModerationManager()
+	if _, err := moderationManager.Mute("", models.SubjectUsername, "user1", "spam", time.Minute); err == nil {
+		t.Error("Expected an error for a missing actor")
+	}
+	if len(moderationManager.AuditLog()) != 0 {
+		t.Error("Expected rejected actions not to be audited")
+	}
+}
+
+func TestModerationAuditSinkWrittenPerEntry(t *testing.T) {
+	moderationManager := NewModerationManager()
+	var sink bytes.Buffer
+	moderationManager.SetAuditSink(&sink)
+
+	if _, err := moderationManager.Mute("mod", models.SubjectUsername, "user1", "spam", time.Minute); err != nil {
+		t.Fatalf("Mute failed: %v", err)
+	}
+
+	// Nothing waits for a flush, so the entry is on the sink as soon as the mute is issued
+	var entry models.AuditEntry
+	if err := json.Unmarshal(sink.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected one JSON audit entry on the sink, got %q: %v", sink.String(), err)
+	}
+	if entry.Action != models.AuditActionIssue || entry.Kind != models.SanctionMute || entry.Subject != "user1" {
+		t.Errorf("Unexpected audit entry: %+v", entry)
+	}
+}
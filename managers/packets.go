@@ -0,0 +1,277 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"tictactoe/codec"
+	"tictactoe/models"
+	"tictactoe/utils"
+	"time"
+)
+
+// packetRequest is one inbound packet being handled, along with what is known about the connection
+// it arrived on. Handlers only use this, so they can be tested without a WebSocket.
+type packetRequest struct {
+	id         string            // Request ID chosen by the client, echoed in errors
+	conn       models.Connection // Where replies are sent
+	user       *models.User      // Set once the connection has sent a connect packet
+	logger     *slog.Logger
+	rateKey    string                                                      // Who per-user rate limits are charged to
+	bind       func(user *models.User, version int, capabilities []string) // Binds the connection to a user
+	disconnect bool                                                        // Set by handlers to close the connection after replying
+	forwarded  bool                                                        // Set when another node forwarded the packet here
+}
+
+// packetHandler decodes, validates and handles one packet of a registered type.
+type packetHandler func(wsm *WebSocketManager, c codec.Codec, data []byte, req *packetRequest) error
+
+// packetHandlers maps every inbound packet type to its handler.
+var packetHandlers = map[string]packetHandler{}
+
+func init() {
+	registerPacket(utils.ConnectPacketType, (*WebSocketManager).handleConnect)
+	registerPacket(utils.PlayPacketType, (*WebSocketManager).handlePlay)
+	registerPacket(utils.MovePacketType, (*WebSocketManager).handleMove)
+	registerPacket(utils.ChatPacketType, (*WebSocketManager).handleChat)
+	registerPacket(utils.ResignPacketType, (*WebSocketManager).handleResign)
+	registerPacket(utils.SpectatePacketType, (*WebSocketManager).handleSpectate)
+}
+
+// registerPacket declares an inbound packet type. Packets of that type are decoded into P, which
+// may not contain unknown fields, and validated before handle is called.
+func registerPacket[P any, PP interface {
+	*P
+	models.Validator
+}](packetType string, handle func(wsm *WebSocketManager, req *packetRequest, packet PP) error) {
+	packetHandlers[packetType] = func(wsm *WebSocketManager, c codec.Codec, data []byte, req *packetRequest) error {
+		packet := PP(new(P))
+		if err := c.UnmarshalStrict(data, packet); err != nil {
+			return err
+		}
+		if err := packet.Validate(); err != nil {
+			return err
+		}
+		return handle(wsm, req, packet)
+	}
+}
+
+// dispatch routes a message to the handler registered for its type and returns the type.
+func (wsm *WebSocketManager) dispatch(c codec.Codec, data []byte, req *packetRequest) (string, error) {
+	header, err := c.PacketHeader(data)
+	req.id = header.RequestID
+	if err != nil {
+		req.logger.Debug("invalid packet format", "error", err)
+		return "", models.ErrInvalidPacket
+	}
+	handler, ok := packetHandlers[header.Type]
+	if !ok {
+		req.logger.Debug("unknown packet type", "type", header.Type)
+		return header.Type, models.ErrUnknownPacketType
+	}
+	if err := wsm.rateLimits.allowPacket(header.Type, req.rateKey, time.Now()); err != nil {
+		return header.Type, err
+	}
+	return header.Type, handler(wsm, c, data, req)
+}
+
+// replyWithError tells the client why its packet was not handled. Errors that are not meant for
+// clients are reported as undecodable packets or internal errors.
+func (wsm *WebSocketManager) replyWithError(req *packetRequest, packetType string, err error) {
+	var validationErr *models.ValidationError
+	var limitedErr *rateLimitedError
+	var cooldownErr *cooldownError
+	var clientErr *models.Error
+	switch {
+	case errors.As(err, &limitedErr):
+		wsm.sendRateLimited(req.conn, req.id, limitedErr.retryAfter)
+	case errors.As(err, &cooldownErr):
+		wsm.sendRetryAfter(req.conn, req.id, models.ErrCoolingDown, cooldownErr.retryAfter)
+	case errors.As(err, &validationErr):
+		req.logger.Debug("packet rejected", "type", packetType, "request_id", req.id, "error", err)
+		wsm.sendValidationError(req.conn, req.id, validationErr)
+	case errors.As(err, &clientErr):
+		wsm.sendError(req.conn, req.id, clientErr)
+	default:
+		req.logger.Debug("invalid packet format", "type", packetType, "request_id", req.id, "error", err)
+		wsm.sendError(req.conn, req.id, models.ErrInvalidPacket)
+	}
+}
+
+// handleConnect binds the connection to a user after checking for bans and negotiating the protocol.
+func (wsm *WebSocketManager) handleConnect(req *packetRequest, packet *models.ConnectPacket) error {
+	if sanction := wsm.moderationManager.CheckAccess(packet.Username, packet.DeviceID); sanction != nil {
+		// Banned users are told when the ban ends and disconnected
+		req.logger.Warn("rejected sanctioned user", "username", packet.Username, "device_id", packet.DeviceID, "kind", sanction.Kind)
+		wsm.sendBanned(req.conn, req.id, sanction)
+		req.disconnect = true
+		return nil
+	}
+	version, capabilities, ok := negotiateProtocol(packet.ProtocolVersion, packet.Capabilities)
+	if !ok {
+		// Clients that predate the handshake are told to upgrade and disconnected
+		req.logger.Info("rejected outdated client", "username", packet.Username, "protocol_version", packet.ProtocolVersion)
+		wsm.sendUpgradeRequired(req.conn, req.id)
+		req.disconnect = true
+		return nil
+	}
+	user := wsm.userManager.CreateUser(packet.Username, packet.DeviceID)
+	req.bind(user, version, capabilities)
+	req.logger.Info("user connected", "username", user.Username, "device_id", user.DeviceID, "protocol_version", version, "capabilities", capabilities)
+	wsm.sendWelcome(req.conn, req.id, version, capabilities)
+	wsm.sendUserStats(req.conn, req.id, user)
+	return nil
+}
+
+// handlePlay puts the user in the matchmaking queue and starts a game once an opponent is found.
+// The packet's username must be the one the connection connected as.
+func (wsm *WebSocketManager) handlePlay(req *packetRequest, packet *models.PlayPacket) error {
+	if req.user == nil {
+		return models.ErrNotRegistered
+	}
+	user := req.user
+	if packet.Username != user.Username {
+		req.logger.Warn("rejected play for another user", "username", user.Username, "requested", packet.Username)
+		var v models.ValidationError
+		v.Add("username", "must be the username the connection connected as")
+		return v.Err()
+	}
+	if sanction := wsm.moderationManager.CheckAccess(user.Username, user.DeviceID); sanction != nil {
+		req.logger.Warn("rejected play from sanctioned user", "username", user.Username, "kind", sanction.Kind)
+		wsm.sendBanned(req.conn, req.id, sanction)
+		return nil
+	}
+	if retryAfter := wsm.matchmakingCooldown(user, time.Now()); retryAfter > 0 {
+		req.logger.Info("rejected play from user cooling down", "username", user.Username, "retry_after", retryAfter)
+		return &cooldownError{retryAfter: retryAfter}
+	}
+	options := packet.Options()
+	if !slices.Contains(serverVariants, options.Variant) {
+		var v models.ValidationError
+		v.Add("variant", "must be one of %s", strings.Join(serverVariants, ", "))
+		return v.Err()
+	}
+	// Matchmaking can take longer than the read timeout, so it must not hold up the read loop,
+	// which answers the heartbeat
+	go wsm.findMatch(req, user, options)
+	return nil
+}
+
+// findMatch waits for an opponent and tells the player about their game, or that none was found.
+// It runs on its own goroutine and gives up if the connection closes first.
+func (wsm *WebSocketManager) findMatch(req *packetRequest, user *models.User, options models.GameOptions) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if c, ok := req.conn.(*client); ok {
+		go func() {
+			select {
+			case <-c.done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	game, err := wsm.matchmakingManager.RequestMatch(ctx, user, options)
+	var remote *remoteMatch
+	switch {
+	case errors.Is(err, ErrMatchmakingClosed):
+		wsm.replyWithError(req, utils.PlayPacketType, models.ErrShuttingDown)
+	case errors.Is(err, models.ErrAlreadyInGame), errors.Is(err, models.ErrAlreadyQueued):
+		wsm.replyWithError(req, utils.PlayPacketType, err)
+	case errors.As(err, &remote):
+		// Another node hosts the game and built this player's game start packet
+		req.logger.Info("game started on another node", "game_id", remote.start.GameID, "opponent", remote.start.Opponent)
+		remote.start.RequestID = req.id
+		req.conn.Send(remote.start)
+	case errors.Is(err, context.Canceled):
+		req.logger.Debug("matchmaking cancelled", "username", user.Username)
+	case err != nil:
+		req.logger.Error("matchmaking failed", "username", user.Username, "error", err)
+		wsm.replyWithError(req, utils.PlayPacketType, models.ErrMatchmakingFailed)
+	case game != nil:
+		wsm.cluster.hostGame(game)
+		req.logger.Info("game started", "game_id", game.ID, "players", []string{game.Players[0].Username, game.Players[1].Username})
+		// Game found, tell this player; the opponent's own request tells them, or their node does
+		wsm.sendGameStart(req.conn, req.id, game, user)
+	default:
+		// No match found within timeout, notify player
+		wsm.sendNoMatchFound(req.conn, req.id)
+	}
+}
+
+// handleMove applies a move and sends the new game state to both players.
+func (wsm *WebSocketManager) handleMove(req *packetRequest, packet *models.MovePacket) error {
+	if req.user == nil {
+		return models.ErrNotRegistered
+	}
+	// Validate and process the move, update game state
+	game, replayed, err := wsm.gameManager.UpdateGame(packet.GameID, req.user, packet.Row, packet.Col, packet.Ply)
+	if errors.Is(err, models.ErrGameNotFound) && wsm.cluster.forward(req, packet.GameID, packet) {
+		return nil // Another node hosts the game and replies
+	}
+	if err != nil {
+		req.logger.Debug("move rejected", "game_id", packet.GameID, "error", err)
+		return err
+	}
+	if replayed {
+		// The move was already applied; acknowledge the retry without telling the opponent again
+		req.logger.Debug("move replayed", "game_id", packet.GameID, "ply", *packet.Ply)
+		wsm.sendGameState(req.conn, req.id, game, req.user)
+		return nil
+	}
+
+	// Notify both players
+	wsm.notifyGameUpdate(game, req.user, req.id)
+	return nil
+}
+
+// handleChat relays a chat message to the user's opponent unless the user is muted.
+func (wsm *WebSocketManager) handleChat(req *packetRequest, packet *models.ChatPacket) error {
+	if req.user == nil {
+		return models.ErrNotRegistered
+	}
+	if sanction := wsm.moderationManager.CheckMute(req.user.Username, req.user.DeviceID); sanction != nil {
+		return models.ErrMuted
+	}
+	game, err := wsm.gameManager.GetGame(packet.GameID)
+	if errors.Is(err, models.ErrGameNotFound) && wsm.cluster.forward(req, packet.GameID, packet) {
+		return nil // Another node hosts the game and relays the message
+	}
+	if err != nil {
+		return err
+	}
+	return wsm.relayChat(game, req.user, req.conn, packet.Message)
+}
+
+// handleResign ends the user's game, making their opponent the winner.
+func (wsm *WebSocketManager) handleResign(req *packetRequest, packet *models.ResignPacket) error {
+	if req.user == nil {
+		return models.ErrNotRegistered
+	}
+	game, err := wsm.gameManager.Resign(packet.GameID, req.user)
+	if errors.Is(err, models.ErrGameNotFound) && wsm.cluster.forward(req, packet.GameID, packet) {
+		return nil // Another node hosts the game and replies
+	}
+	if err != nil {
+		return err
+	}
+	req.logger.Info("player resigned", "game_id", game.ID, "username", req.user.Username)
+	wsm.notifyGameUpdate(game, req.user, req.id)
+	return nil
+}
+
+// handleSpectate sends the game state to the connection and keeps it updated until the game ends.
+func (wsm *WebSocketManager) handleSpectate(req *packetRequest, packet *models.SpectatePacket) error {
+	if req.user == nil {
+		return models.ErrNotRegistered
+	}
+	game, err := wsm.gameManager.Spectate(packet.GameID, req.conn)
+	if err != nil {
+		return err
+	}
+	wsm.sendGameState(req.conn, req.id, game, nil)
+	return nil
+}
@@ -0,0 +1,303 @@
+package managers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"testing"
+
+	"tictactoe/codec"
+	"tictactoe/config"
+	"tictactoe/models"
+	"tictactoe/utils"
+)
+
+// fakeConn records the packets sent to it in place of a WebSocket client.
+type fakeConn struct {
+	packets []any
+}
+
+func (f *fakeConn) Send(packet any) error {
+	f.packets = append(f.packets, packet)
+	return nil
+}
+
+func newTestRequest() (*packetRequest, *fakeConn) {
+	conn := &fakeConn{}
+	return &packetRequest{conn: conn, logger: slog.Default()}, conn
+}
+
+// newTestManager returns a manager whose games seat the first player given as X.
+func newTestManager() *WebSocketManager {
+	gameManager := NewGameManager()
+	gameManager.Configure(config.Matchmaking{Seating: config.SeatingAlternate})
+	return NewWebSocketManager(NewUserManager(), gameManager, NewMatchmakingManager(), NewModerationManager())
+}
+
+func TestDispatchRejectsInvalidPackets(t *testing.T) {
+	wsm := newTestManager()
+
+	for name, tc := range map[string]struct {
+		message string
+		field   string
+	}{
+		"unknown field": {`{"type":"move","gameId":"g","row":1,"col":1,"cheat":true}`, "cheat"},
+		"wrong type":    {`{"type":"move","gameId":"g","row":"one","col":1}`, "row"},
+		"out of range":  {`{"type":"move","gameId":"g","row":1,"col":1,"ply":-1}`, "ply"},
+		"missing field": {`{"type":"chat","message":"hi"}`, "gameId"},
+		"server field":  {`{"type":"chat","gameId":"g","from":"admin","message":"hi"}`, "from"},
+	} {
+		req, _ := newTestRequest()
+		_, err := wsm.dispatch(codec.JSON, []byte(tc.message), req)
+		var validationErr *models.ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Errorf("%s: expected a validation error, got %v", name, err)
+			continue
+		}
+		if len(validationErr.Fields) != 1 || validationErr.Fields[0].Field != tc.field {
+			t.Errorf("%s: expected field %q to be rejected, got %v", name, tc.field, validationErr.Fields)
+		}
+	}
+}
+
+func TestDispatchUnknownPacketType(t *testing.T) {
+	wsm := newTestManager()
+	req, _ := newTestRequest()
+
+	packetType, err := wsm.dispatch(codec.JSON, []byte(`{"type":"teleport"}`), req)
+	if packetType != "teleport" || !errors.Is(err, models.ErrUnknownPacketType) {
+		t.Errorf("Expected unknown packet type error, got %q, %v", packetType, err)
+	}
+}
+
+func TestHandleConnectBindsUser(t *testing.T) {
+	wsm := newTestManager()
+	req, conn := newTestRequest()
+	var bound *models.User
+	req.bind = func(user *models.User, version int, capabilities []string) { bound = user }
+
+	err := wsm.handleConnect(req, &models.ConnectPacket{Username: "alice", ProtocolVersion: utils.ProtocolVersion})
+	if err != nil {
+		t.Fatalf("Expected connect to succeed, got %v", err)
+	}
+	if bound == nil || bound.Username != "alice" {
+		t.Fatalf("Expected the connection to be bound to alice, got %v", bound)
+	}
+	if len(conn.packets) != 2 {
+		t.Fatalf("Expected welcome and user stats packets, got %v", conn.packets)
+	}
+	if welcome, ok := conn.packets[0].(models.WelcomePacket); !ok || welcome.ProtocolVersion != utils.ProtocolVersion {
+		t.Errorf("Expected a welcome packet first, got %+v", conn.packets[0])
+	}
+}
+
+func TestHandleMoveRequiresConnect(t *testing.T) {
+	wsm := newTestManager()
+	req, _ := newTestRequest()
+
+	err := wsm.handleMove(req, &models.MovePacket{GameID: "g", Row: 0, Col: 0})
+	if !errors.Is(err, models.ErrNotRegistered) {
+		t.Errorf("Expected a move before connect to be rejected, got %v", err)
+	}
+}
+
+func TestHandlePlayOnlyForConnectedUser(t *testing.T) {
+	wsm := newTestManager()
+	wsm.userManager.CreateUser("alice", "")
+	req, conn := newTestRequest()
+
+	// Knowing a username is not enough to queue as that user
+	err := wsm.handlePlay(req, &models.PlayPacket{Username: "alice"})
+	if !errors.Is(err, models.ErrNotRegistered) {
+		t.Errorf("Expected play before connect to be rejected, got %v", err)
+	}
+
+	req.user = wsm.userManager.CreateUser("mallory", "")
+	err = wsm.handlePlay(req, &models.PlayPacket{Username: "alice"})
+	var validationErr *models.ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Fields[0].Field != "username" {
+		t.Errorf("Expected play as another user to be rejected, got %v", err)
+	}
+	if depth := wsm.matchmakingManager.QueueDepth(); depth != 0 || len(conn.packets) != 0 {
+		t.Errorf("Expected nobody to be queued, got %d waiting and replies %v", depth, conn.packets)
+	}
+}
+
+func TestMoveErrorCodes(t *testing.T) {
+	wsm := newTestManager()
+	alice := wsm.userManager.CreateUser("alice", "")
+	bob := wsm.userManager.CreateUser("bob", "")
+	game, _ := wsm.gameManager.CreateGame(alice, bob, models.GameOptions{})
+	alice.AddConnection(&fakeConn{})
+	bob.AddConnection(&fakeConn{})
+
+	moves := []struct {
+		player   *models.User
+		row, col int
+		code     models.ErrorCode
+	}{
+		{bob, 0, 0, models.ErrorCodeNotYourTurn},
+		{alice, 0, 0, ""},
+		{bob, 0, 0, models.ErrorCodeCellOccupied},
+		{bob, 3, 0, models.ErrorCodeOutOfBounds},
+		{bob, 0, -1, models.ErrorCodeOutOfBounds},
+		{wsm.userManager.CreateUser("carol", ""), 1, 1, models.ErrorCodeNotInGame},
+	}
+	for i, move := range moves {
+		req, _ := newTestRequest()
+		req.user = move.player
+		data := fmt.Sprintf(`{"type":"move","gameId":%q,"row":%d,"col":%d}`, game.ID, move.row, move.col)
+		_, err := wsm.dispatch(codec.JSON, []byte(data), req)
+		var clientErr *models.Error
+		switch {
+		case move.code == "" && err != nil:
+			t.Errorf("move %d: expected success, got %v", i, err)
+		case move.code != "" && (!errors.As(err, &clientErr) || clientErr.Code != move.code):
+			t.Errorf("move %d: expected %s, got %v", i, move.code, err)
+		}
+	}
+}
+
+func TestErrorPacketEchoesRequestID(t *testing.T) {
+	wsm := newTestManager()
+	req, conn := newTestRequest()
+
+	packetType, err := wsm.dispatch(codec.JSON, []byte(`{"type":"move","requestId":"r42","gameId":"missing","row":0,"col":0}`), req)
+	wsm.replyWithError(req, packetType, err)
+
+	if len(conn.packets) != 1 {
+		t.Fatalf("Expected one error packet, got %v", conn.packets)
+	}
+	errorPacket, ok := conn.packets[0].(models.ErrorPacket)
+	if !ok || errorPacket.Code != models.ErrorCodeNotRegistered || errorPacket.RequestID != "r42" {
+		t.Errorf("Expected NOT_REGISTERED for request r42, got %+v", conn.packets[0])
+	}
+}
+
+func TestMoveRetriesAreIdempotent(t *testing.T) {
+	wsm := newTestManager()
+	alice := wsm.userManager.CreateUser("alice", "")
+	bob := wsm.userManager.CreateUser("bob", "")
+	game, _ := wsm.gameManager.CreateGame(alice, bob, models.GameOptions{})
+	aliceConn, bobConn := &fakeConn{}, &fakeConn{}
+	alice.AddConnection(aliceConn)
+	bob.AddConnection(bobConn)
+
+	move := func(player *models.User, conn *fakeConn, requestID string, ply, row, col int) error {
+		req := &packetRequest{id: requestID, conn: conn, user: player, logger: slog.Default()}
+		return wsm.handleMove(req, &models.MovePacket{GameID: game.ID, Row: row, Col: col, Ply: &ply})
+	}
+
+	if err := move(alice, aliceConn, "a1", 0, 1, 1); err != nil {
+		t.Fatalf("Expected the first move to succeed, got %v", err)
+	}
+	// The same move resent after a network blip is acknowledged but not applied again
+	if err := move(alice, aliceConn, "a1-retry", 0, 1, 1); err != nil {
+		t.Fatalf("Expected the retry to be acknowledged, got %v", err)
+	}
+	if game, _ := wsm.gameManager.GetGame(game.ID); game.Ply() != 1 {
+		t.Errorf("Expected the retry not to be applied, game is at ply %d", game.Ply())
+	}
+	if len(bobConn.packets) != 1 {
+		t.Errorf("Expected the opponent to be told about the move once, got %d packets", len(bobConn.packets))
+	}
+	last := aliceConn.packets[len(aliceConn.packets)-1].(models.GameUpdatePacket)
+	if last.RequestID != "a1-retry" || last.Ply != 1 {
+		t.Errorf("Expected the retry to be answered with the game state at ply 1, got %+v", last)
+	}
+
+	// A different move at a consumed ply, or a move from the future, is stale
+	if err := move(alice, aliceConn, "a2", 0, 0, 0); !errors.Is(err, models.ErrStaleMove) {
+		t.Errorf("Expected a different move at ply 0 to be stale, got %v", err)
+	}
+	if err := move(bob, bobConn, "b1", 2, 0, 0); !errors.Is(err, models.ErrStaleMove) {
+		t.Errorf("Expected a move at a future ply to be stale, got %v", err)
+	}
+	if err := move(bob, bobConn, "b1", 1, 0, 0); err != nil {
+		t.Errorf("Expected bob's move at ply 1 to succeed, got %v", err)
+	}
+}
+
+func TestUserSessionsFanOut(t *testing.T) {
+	wsm := newTestManager()
+	wsm.ConfigureAbandonment(config.Abandonment{}) // Forfeit as soon as the last session closes
+	alice := wsm.userManager.CreateUser("alice", "")
+	bob := wsm.userManager.CreateUser("bob", "")
+	game, _ := wsm.gameManager.CreateGame(alice, bob, models.GameOptions{})
+	phone, desktop, bobConn := &fakeConn{}, &fakeConn{}, &fakeConn{}
+	alice.AddConnection(phone)
+	alice.AddConnection(desktop)
+	bob.AddConnection(bobConn)
+
+	req := &packetRequest{conn: phone, user: alice, logger: slog.Default()}
+	if err := wsm.handleMove(req, &models.MovePacket{GameID: game.ID, Row: 1, Col: 1}); err != nil {
+		t.Fatalf("Expected the move to succeed, got %v", err)
+	}
+	if len(phone.packets) != 1 || len(desktop.packets) != 1 || len(bobConn.packets) != 1 {
+		t.Errorf("Expected every session to get the game update, got %d, %d and %d packets", len(phone.packets), len(desktop.packets), len(bobConn.packets))
+	}
+
+	// Chat reaches the opponent and the sender's other sessions, but is not echoed back
+	if err := wsm.handleChat(req, &models.ChatPacket{GameID: game.ID, Message: "hi"}); err != nil {
+		t.Fatalf("Expected the chat to be relayed, got %v", err)
+	}
+	if len(phone.packets) != 1 || len(desktop.packets) != 2 || len(bobConn.packets) != 2 {
+		t.Errorf("Expected the chat on the desktop and bob's session only, got %d, %d and %d packets", len(phone.packets), len(desktop.packets), len(bobConn.packets))
+	}
+
+	// Closing one session keeps the game going; closing the last abandons it
+	alice.RemoveConnection(phone)
+	wsm.abandonActiveGame(alice)
+	if game, _ := wsm.gameManager.GetGame(game.ID); game.Status != utils.GameStateInProgress {
+		t.Fatalf("Expected the game to continue while alice has a session, got %s", game.Status)
+	}
+	alice.RemoveConnection(desktop)
+	wsm.abandonActiveGame(alice)
+	game, _ = wsm.gameManager.GetGame(game.ID)
+	if game.Status != utils.GameStateCompleted || game.Winner != "bob" {
+		t.Errorf("Expected bob to win once alice's last session closed, got %s %s", game.Status, game.Winner)
+	}
+}
+
+func TestSpectatorSeesResignation(t *testing.T) {
+	wsm := newTestManager()
+	alice := wsm.userManager.CreateUser("alice", "")
+	bob := wsm.userManager.CreateUser("bob", "")
+	carol := wsm.userManager.CreateUser("carol", "")
+	game, _ := wsm.gameManager.CreateGame(alice, bob, models.GameOptions{})
+	aliceConn, bobConn, carolConn := &fakeConn{}, &fakeConn{}, &fakeConn{}
+	alice.AddConnection(aliceConn)
+	bob.AddConnection(bobConn)
+
+	watch := &packetRequest{id: "w1", conn: carolConn, user: carol, logger: slog.Default()}
+	if err := wsm.handleSpectate(watch, &models.SpectatePacket{GameID: game.ID}); err != nil {
+		t.Fatalf("Expected carol to spectate, got %v", err)
+	}
+	if state := carolConn.packets[0].(models.GameUpdatePacket); state.RequestID != "w1" || state.Status != utils.GameStateInProgress {
+		t.Errorf("Expected the spectator to get the game state, got %+v", state)
+	}
+
+	// Only players may resign, and a resignation ends the game for everyone watching
+	resign := func(player *models.User, conn *fakeConn) error {
+		req := &packetRequest{id: "r1", conn: conn, user: player, logger: slog.Default()}
+		return wsm.handleResign(req, &models.ResignPacket{GameID: game.ID})
+	}
+	if err := resign(carol, carolConn); !errors.Is(err, models.ErrNotInGame) {
+		t.Errorf("Expected a spectator's resignation to be rejected, got %v", err)
+	}
+	if err := resign(alice, aliceConn); err != nil {
+		t.Fatalf("Expected alice to resign, got %v", err)
+	}
+	if update := carolConn.packets[len(carolConn.packets)-1].(models.GameUpdatePacket); update.Winner != "bob" {
+		t.Errorf("Expected the spectator to see bob win, got %+v", update)
+	}
+	if end := bobConn.packets[len(bobConn.packets)-1].(models.GameEndPacket); end.Outcome != "win" {
+		t.Errorf("Expected bob to be told they won, got %+v", end)
+	}
+	if err := resign(bob, bobConn); !errors.Is(err, models.ErrGameOver) {
+		t.Errorf("Expected resigning a finished game to fail, got %v", err)
+	}
+	if err := wsm.handleSpectate(watch, &models.SpectatePacket{GameID: game.ID}); !errors.Is(err, models.ErrGameOver) {
+		t.Errorf("Expected spectating a finished game to fail, got %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package managers
+
+import (
+	"slices"
+	"tictactoe/models"
+	"tictactoe/utils"
+)
+
+// serverCapabilities lists the optional protocol features this server supports.
+var serverCapabilities = []string{utils.CapabilityChat}
+
+// serverVariants lists the game variants this server can host.
+var serverVariants = []string{utils.VariantClassic}
+
+// negotiateProtocol picks the protocol version and capabilities for a connection. It reports false
+// if the client is too old to be served.
+func negotiateProtocol(clientVersion int, clientCapabilities []string) (int, []string, bool) {
+	if clientVersion < utils.MinProtocolVersion {
+		return 0, nil, false
+	}
+	version := min(clientVersion, utils.ProtocolVersion)
+
+	capabilities := []string{}
+	for _, capability := range serverCapabilities {
+		if slices.Contains(clientCapabilities, capability) {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	return version, capabilities, true
+}
+
+// sendWelcome tells the client what was negotiated and which limits the server enforces.
+func (wsm *WebSocketManager) sendWelcome(conn models.Connection, requestID string, version int, capabilities []string) {
+	welcomePacket := models.WelcomePacket{
+		BasePacket:      models.BasePacket{Type: utils.WelcomePacketType, RequestID: requestID},
+		ProtocolVersion: version,
+		Capabilities:    capabilities,
+		Variants:        serverVariants,
+		Limits: models.ServerLimits{
+			MaxMessageSize:       wsm.maxMessageSize,
+			PingIntervalMs:       wsm.pingInterval.Milliseconds(),
+			ReadTimeoutMs:        wsm.readTimeout.Milliseconds(),
+			MatchmakingTimeoutMs: wsm.matchmakingManager.timeout.Milliseconds(),
+		},
+	}
+	conn.Send(welcomePacket)
+}
+
+// sendUpgradeRequired tells a client that its protocol version is no longer supported.
+func (wsm *WebSocketManager) sendUpgradeRequired(conn models.Connection, requestID string) {
+	upgradePacket := models.UpgradeRequiredPacket{
+		BasePacket:         models.BasePacket{Type: utils.UpgradeRequiredType, RequestID: requestID},
+		MinProtocolVersion: utils.MinProtocolVersion,
+		MaxProtocolVersion: utils.ProtocolVersion,
+		Message:            "Your client is out of date, please update it to keep playing",
+	}
+	conn.Send(upgradePacket)
+}
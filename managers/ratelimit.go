@@ -0,0 +1,144 @@
+package managers
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"tictactoe/config"
+	"tictactoe/models"
+	"tictactoe/utils"
+	"time"
+)
+
+// limiterIdleTimeout is how long a keyed bucket may go unused before it is forgotten. A bucket idle
+// this long has refilled, so forgetting it changes nothing.
+const limiterIdleTimeout = 10 * time.Minute
+
+// tokenBucket holds up to burst tokens and is refilled at rate tokens per second. The zero value
+// is a full bucket.
+type tokenBucket struct {
+	tokens  float64
+	last    time.Time
+	started bool
+}
+
+// take removes a token if one is available. Otherwise it reports how long until one will be.
+func (b *tokenBucket) take(now time.Time, limit config.Bucket) (bool, time.Duration) {
+	if limit.Rate <= 0 {
+		return true, 0
+	}
+	burst := float64(limit.Burst)
+	if !b.started {
+		b.tokens, b.last, b.started = burst, now, true
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := (1 - b.tokens) / limit.Rate
+	return false, time.Duration(math.Ceil(wait * float64(time.Second)))
+}
+
+// keyedLimiter keeps a token bucket per key, such as a username or remote IP.
+type keyedLimiter struct {
+	limit     config.Bucket
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+func newKeyedLimiter(limit config.Bucket) *keyedLimiter {
+	return &keyedLimiter{limit: limit, buckets: make(map[string]*tokenBucket)}
+}
+
+// allow takes a token from the key's bucket. When none is left it reports how long until one will be.
+func (l *keyedLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	if l.limit.Rate <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > limiterIdleTimeout {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > limiterIdleTimeout {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{}
+		l.buckets[key] = b
+	}
+	return b.take(now, l.limit)
+}
+
+// rateLimits holds the server's rate limiters.
+type rateLimits struct {
+	connections   *keyedLimiter            // New connections per remote IP
+	packets       config.Bucket            // Inbound packets per connection, kept in each client
+	perUser       map[string]*keyedLimiter // Per-user budgets by packet type
+	maxViolations int                      // Limited packets in a row before disconnecting; 0 never does
+}
+
+func newRateLimits(cfg config.RateLimit) *rateLimits {
+	return &rateLimits{
+		connections: newKeyedLimiter(cfg.ConnectionsPerIP),
+		packets:     cfg.Packets,
+		perUser: map[string]*keyedLimiter{
+			utils.ChatPacketType: newKeyedLimiter(cfg.Chat),
+			utils.MovePacketType: newKeyedLimiter(cfg.Moves),
+			utils.PlayPacketType: newKeyedLimiter(cfg.Matchmaking),
+		},
+		maxViolations: cfg.MaxViolations,
+	}
+}
+
+// rateLimitedError is returned when a packet exceeds its budget. It unwraps to models.ErrRateLimited.
+type rateLimitedError struct {
+	scope      string // Which budget was exceeded, e.g. "chat" or "connection"
+	retryAfter time.Duration
+}
+
+func (e *rateLimitedError) Error() string {
+	return fmt.Sprintf("%s rate limit exceeded, retry after %v", e.scope, e.retryAfter)
+}
+
+func (e *rateLimitedError) Unwrap() error { return models.ErrRateLimited }
+
+// allowPacket charges a packet against the budget for its type, if it has one. key names who is
+// charged, usually the user who sent the packet.
+func (r *rateLimits) allowPacket(packetType, key string, now time.Time) error {
+	limiter, ok := r.perUser[packetType]
+	if !ok {
+		return nil
+	}
+	if ok, retryAfter := limiter.allow(key, now); !ok {
+		return &rateLimitedError{scope: packetType, retryAfter: retryAfter}
+	}
+	return nil
+}
+
+// rateKey returns who a connection's packets are charged to: its user once bound, and its remote
+// IP before that.
+func rateKey(c *client, user *models.User) string {
+	if user != nil {
+		return "user:" + user.Username
+	}
+	return "ip:" + c.remoteIP
+}
+
+// remoteIP returns the IP part of a request's remote address.
+func remoteIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
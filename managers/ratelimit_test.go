@@ -0,0 +1,59 @@
+package managers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"tictactoe/config"
+	"tictactoe/models"
+	"tictactoe/utils"
+)
+
+func TestTokenBucket(t *testing.T) {
+	limit := config.Bucket{Rate: 2, Burst: 3}
+	now := time.Now()
+	var b tokenBucket
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := b.take(now, limit); !ok {
+			t.Fatalf("Expected token %d of the burst to be available", i+1)
+		}
+	}
+	ok, retryAfter := b.take(now, limit)
+	if ok || retryAfter != 500*time.Millisecond {
+		t.Errorf("Expected an empty bucket to ask for 500ms, got %v, %v", ok, retryAfter)
+	}
+	if ok, _ := b.take(now.Add(500*time.Millisecond), limit); !ok {
+		t.Errorf("Expected a token to be refilled after 500ms")
+	}
+	if ok, _ := b.take(now.Add(time.Hour), config.Bucket{}); !ok {
+		t.Errorf("Expected a zero rate to be unlimited")
+	}
+}
+
+func TestPerUserBudgetsAreSeparate(t *testing.T) {
+	limits := newRateLimits(config.RateLimit{
+		Chat:  config.Bucket{Rate: 1, Burst: 1},
+		Moves: config.Bucket{Rate: 1, Burst: 1},
+	})
+	now := time.Now()
+
+	if err := limits.allowPacket(utils.ChatPacketType, "user:alice", now); err != nil {
+		t.Fatalf("Expected the first chat to be allowed, got %v", err)
+	}
+	err := limits.allowPacket(utils.ChatPacketType, "user:alice", now)
+	var limitedErr *rateLimitedError
+	if !errors.As(err, &limitedErr) || !errors.Is(err, models.ErrRateLimited) || limitedErr.retryAfter != time.Second {
+		t.Errorf("Expected the second chat to be rate limited for 1s, got %v", err)
+	}
+	if err := limits.allowPacket(utils.MovePacketType, "user:alice", now); err != nil {
+		t.Errorf("Expected moves to have their own budget, got %v", err)
+	}
+	if err := limits.allowPacket(utils.ChatPacketType, "user:bob", now); err != nil {
+		t.Errorf("Expected bob to have his own budget, got %v", err)
+	}
+	if err := limits.allowPacket(utils.ConnectPacketType, "user:alice", now); err != nil {
+		t.Errorf("Expected connect packets to be unlimited per user, got %v", err)
+	}
+}
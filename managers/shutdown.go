@@ -0,0 +1,111 @@
+package managers
+
+import (
+	"context"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"tictactoe/models"
+	"tictactoe/utils"
+	"time"
+)
+
+// gameDrainInterval is how often Shutdown checks whether in-progress games have finished.
+const gameDrainInterval = 250 * time.Millisecond
+
+// HandleHealthz reports that the process is alive.
+func (wsm *WebSocketManager) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok\n"))
+}
+
+// HandleReadyz reports whether the server is accepting new connections and matches.
+func (wsm *WebSocketManager) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	if wsm.shuttingDown.Load() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ready\n"))
+}
+
+// Shutdown stops new matches, tells every connected player the server is going away, optionally
+// waits for in-progress games to finish until ctx expires, and then closes every connection. It
+// returns once every connection is closed, closing any still open when ctx expires.
+func (wsm *WebSocketManager) Shutdown(ctx context.Context, waitForGames bool) {
+	if !wsm.shuttingDown.CompareAndSwap(false, true) {
+		return
+	}
+	wsm.logger.Info("shutdown started", "wait_for_games", waitForGames)
+
+	// Stop accepting new matches and release anyone waiting in the queue
+	wsm.matchmakingManager.Close()
+	// Players who left are not forfeited while the server goes down
+	wsm.leavers.stopAll()
+
+	wsm.broadcastShutdown(ctx)
+
+	if waitForGames {
+		wsm.waitForGames(ctx)
+	}
+
+	wsm.closeAllConnections(ctx)
+	close(wsm.heartbeatStop)
+	wsm.cluster.close()
+	wsm.logger.Info("shutdown complete")
+}
+
+// broadcastShutdown sends a serverShutdown packet to every connected client.
+func (wsm *WebSocketManager) broadcastShutdown(ctx context.Context) {
+	shutdownPacket := models.ServerShutdownPacket{
+		BasePacket: models.BasePacket{Type: utils.ServerShutdownPacketType},
+		Message:    "Server is shutting down",
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		shutdownPacket.Deadline = &deadline
+	}
+
+	for _, c := range wsm.clients.all() {
+		if err := c.Send(shutdownPacket); err != nil {
+			c.logger.Warn("failed to send server shutdown packet", "error", err)
+		}
+	}
+}
+
+// waitForGames blocks until no game is in progress or ctx expires.
+func (wsm *WebSocketManager) waitForGames(ctx context.Context) {
+	ticker := time.NewTicker(gameDrainInterval)
+	defer ticker.Stop()
+
+	for {
+		active := wsm.gameManager.ActiveGames()
+		if active == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			wsm.logger.Warn("shutdown deadline reached with games in progress", "active_games", active)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeAllConnections flushes every client's queued messages, sends a close frame and closes its
+// connection. It waits for every client to close until ctx expires, then closes the rest at once.
+func (wsm *WebSocketManager) closeAllConnections(ctx context.Context) {
+	clients := wsm.clients.all()
+	for _, c := range clients {
+		c.closeAfterFlush(websocket.CloseGoingAway, "server shutdown")
+	}
+	for i, c := range clients {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			wsm.logger.Warn("shutdown deadline reached with connections still flushing", "open_connections", len(clients)-i)
+			for _, c := range clients[i:] {
+				c.close()
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package managers
+
+import (
+	"github.com/gorilla/websocket"
+	"tictactoe/models"
+	"time"
+)
+
+// transport carries a client's outbound messages over a WebSocket, a Server-Sent Events stream or
+// long-polling. writeMessage and writeClose are only called by the client's writer goroutine.
+type transport interface {
+	// writeMessage delivers one encoded packet.
+	writeMessage(msg []byte) error
+	// writeClose tells the peer why the connection is closing, once the queue has been drained.
+	writeClose(notice models.CloseNotice) error
+	// ping asks the peer to show it is alive. It may be called concurrently with writes.
+	ping(deadline time.Time) error
+	// close releases the underlying connection.
+	close() error
+}
+
+// websocketTransport writes to a WebSocket connection.
+type websocketTransport struct {
+	conn         *websocket.Conn
+	messageType  int // Text or binary, depending on the negotiated codec
+	writeTimeout time.Duration
+}
+
+func newWebSocketTransport(conn *websocket.Conn, messageType int, writeTimeout time.Duration) *websocketTransport {
+	return &websocketTransport{conn: conn, messageType: messageType, writeTimeout: writeTimeout}
+}
+
+func (t *websocketTransport) writeMessage(msg []byte) error {
+	t.conn.SetWriteDeadline(time.Now().Add(t.writeTimeout))
+	return t.conn.WriteMessage(t.messageType, msg)
+}
+
+func (t *websocketTransport) writeClose(notice models.CloseNotice) error {
+	t.conn.SetWriteDeadline(time.Now().Add(t.writeTimeout))
+	return t.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(notice.Code, notice.Reason))
+}
+
+// ping sends a ping frame. WriteControl may be called concurrently with the writer goroutine.
+func (t *websocketTransport) ping(deadline time.Time) error {
+	return t.conn.WriteControl(websocket.PingMessage, nil, deadline)
+}
+
+func (t *websocketTransport) close() error {
+	return t.conn.Close()
+}
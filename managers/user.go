@@ -1,21 +1,28 @@
 package managers
 
 import (
-	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
 	"sync"
 	"tictactoe/models"
+	"time"
 )
 
-// UserManager manages user operations such as creation and retrieval.
+// UserManager manages user operations such as creation and retrieval. Users, their stats and their
+// abandonment streaks are held in this node's memory only; a game's results are recorded on the node
+// hosting it.
 type UserManager struct {
-	users map[string]*models.User
-	mu    sync.RWMutex // ensures thread-safe access to the users map
+	users  map[string]*models.User
+	logger *slog.Logger
+	mu     sync.RWMutex // ensures thread-safe access to the users map
 }
 
 // NewUserManager creates a new UserManager instance.
 func NewUserManager() *UserManager {
 	return &UserManager{
-		users: make(map[string]*models.User),
+		users:  make(map[string]*models.User),
+		logger: slog.Default(),
 	}
 }
 
@@ -32,6 +39,7 @@ func (m *UserManager) CreateUser(username, deviceID string) *models.User {
 	// Create a new user since one doesn't exist
 	newUser := models.NewUser(username, deviceID)
 	m.users[username] = newUser
+	m.logger.Info("user created", "username", username, "device_id", deviceID)
 	return newUser
 }
 
@@ -43,25 +51,129 @@ func (m *UserManager) GetUser(username string) (*models.User, error) {
 	if user, exists := m.users[username]; exists {
 		return user, nil
 	}
-	return nil, errors.New("user not found")
+	return nil, fmt.Errorf("user %s: %w", username, models.ErrUserNotFound)
 }
 
-// UpdateUserStats updates the statistics for a user.
-func (m *UserManager) UpdateUserStats(username string, won bool, draw bool) error {
+// RegisterUser creates a new user, failing if the username is already taken.
+func (m *UserManager) RegisterUser(username, deviceID string) (*models.User, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	if _, exists := m.users[username]; exists {
+		return nil, models.ErrUserExists
+	}
+	newUser := models.NewUser(username, deviceID)
+	m.users[username] = newUser
+	m.logger.Info("user registered", "username", username, "device_id", deviceID)
+	return newUser, nil
+}
+
+// UserStanding is a user's stats as of when they were read.
+type UserStanding struct {
+	Username string
+	Stats    models.UserStats
+}
+
+// Stats returns a copy of the user's stats.
+func (m *UserManager) Stats(username string) (models.UserStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	user, exists := m.users[username]
 	if !exists {
-		return errors.New("user not found")
+		return models.UserStats{}, fmt.Errorf("user %s: %w", username, models.ErrUserNotFound)
+	}
+	return user.Stats, nil
+}
+
+// Leaderboard returns the stats of up to limit users ordered by wins, then by fewest losses, then
+// by username.
+func (m *UserManager) Leaderboard(limit int) []UserStanding {
+	m.mu.RLock()
+	standings := make([]UserStanding, 0, len(m.users))
+	for _, user := range m.users {
+		standings = append(standings, UserStanding{Username: user.Username, Stats: user.Stats})
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(standings, func(i, j int) bool {
+		a, b := standings[i].Stats, standings[j].Stats
+		if a.Wins != b.Wins {
+			return a.Wins > b.Wins
+		}
+		if a.Losses != b.Losses {
+			return a.Losses < b.Losses
+		}
+		return standings[i].Username < standings[j].Username
+	})
+	if limit < len(standings) {
+		standings = standings[:limit]
+	}
+	return standings
+}
+
+// UpdateUserStats updates the statistics for a user who played a game as symbol, "X" or "O".
+func (m *UserManager) UpdateUserStats(username, symbol string, won bool, draw bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, exists := m.users[username]
+	if !exists {
+		return fmt.Errorf("user %s: %w", username, models.ErrUserNotFound)
 	}
 
 	if draw {
-		user.UpdateStats(false, true)
+		user.UpdateStats(symbol, false, true)
 	} else if won {
-		user.UpdateStats(true, false)
+		user.UpdateStats(symbol, true, false)
 	} else {
-		user.UpdateStats(false, false)
+		user.UpdateStats(symbol, false, false)
 	}
 	return nil
 }
+
+// SymbolBalance returns how many more games the user has played as X than as O, or 0 for an
+// unknown user.
+func (m *UserManager) SymbolBalance(username string) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, exists := m.users[username]
+	if !exists {
+		return 0
+	}
+	return user.Stats.SymbolBalance()
+}
+
+// RecordAbandonment charges the user with abandoning a game at now and returns how many games they
+// have abandoned since they were last forgiven. A streak whose latest abandonment is older than
+// forgiveAfter starts over; a zero forgiveAfter never forgives.
+func (m *UserManager) RecordAbandonment(username string, now time.Time, forgiveAfter time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, exists := m.users[username]
+	if !exists {
+		return 0, fmt.Errorf("user %s: %w", username, models.ErrUserNotFound)
+	}
+	if forgiveAfter > 0 && now.Sub(user.LastAbandonment) > forgiveAfter {
+		user.AbandonStreak = 0
+	}
+	user.Stats.Abandonments++
+	user.AbandonStreak++
+	user.LastAbandonment = now
+	return user.AbandonStreak, nil
+}
+
+// AbandonStreak returns how many games the user has abandoned since they were last forgiven, and
+// when the latest was.
+func (m *UserManager) AbandonStreak(username string) (int, time.Time) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, exists := m.users[username]
+	if !exists {
+		return 0, time.Time{}
+	}
+	return user.AbandonStreak, user.LastAbandonment
+}
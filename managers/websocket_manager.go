@@ -2,313 +2,553 @@ package managers
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gorilla/websocket"
+	"log/slog"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"tictactoe/backplane"
+	"tictactoe/codec"
+	"tictactoe/config"
 	"tictactoe/models" // Adjust this import path to match your project's structure
 	"tictactoe/utils"
+	"time"
 )
 
 // WebSocketManager manages WebSocket connections and messaging.
 type WebSocketManager struct {
-	clients            map[*websocket.Conn]*models.User // Maps connections to users
+	clients            *clientRegistry // Every open connection and the user it is bound to
 	userManager        *UserManager
 	gameManager        *GameManager
 	upgrader           websocket.Upgrader
-	register           chan *websocket.Conn
-	unregister         chan *websocket.Conn
 	matchmakingManager *MatchmakingManager
-	mu                 sync.Mutex // Protects the clients map
+	moderationManager  *ModerationManager
+	logger             *slog.Logger
+	packetLogLevel     slog.Level    // Level at which every inbound packet is logged
+	shuttingDown       atomic.Bool   // Set once Shutdown starts; new connections and matches are refused
+	maxMessageSize     int64         // Largest inbound message in bytes
+	readTimeout        time.Duration // Idle time allowed between inbound messages; 0 disables the deadline
+	writeTimeout       time.Duration // Time allowed for a single write
+	pingInterval       time.Duration // How often clients are pinged; 0 disables the heartbeat
+	sendQueueSize      int           // Outbound messages buffered per connection
+	slowConsumerPolicy string        // What to do when a send queue overflows, see config.SlowConsumer*
+	rateLimits         *rateLimits
+	abandonment        *abandonmentPolicy
+	leavers            *graceTimers              // Grace periods of users who left an in-progress game
+	onGameCompleted    []func(game *models.Game) // Told about every game that ends, once
+	cluster            *cluster                  // Shares presence, matchmaking and game events with other nodes
+	heartbeatOnce      sync.Once
+	heartbeatStop      chan struct{} // Closed by Shutdown to stop the heartbeat
 }
 
-// NewWebSocketManager creates a new instance and starts its main loop.
-func NewWebSocketManager(userManager *UserManager, gameManager *GameManager, matchmakingManager *MatchmakingManager) *WebSocketManager {
+// NewWebSocketManager creates a new instance.
+func NewWebSocketManager(userManager *UserManager, gameManager *GameManager, matchmakingManager *MatchmakingManager, moderationManager *ModerationManager) *WebSocketManager {
 	wsm := &WebSocketManager{
-		clients:            make(map[*websocket.Conn]*models.User),
+		clients:            newClientRegistry(),
 		userManager:        userManager,
 		gameManager:        gameManager,
 		upgrader:           websocket.Upgrader{},
-		register:           make(chan *websocket.Conn),
 		matchmakingManager: matchmakingManager,
-		unregister:         make(chan *websocket.Conn),
+		moderationManager:  moderationManager,
+		logger:             slog.Default(),
+		packetLogLevel:     slog.LevelDebug,
+		leavers:            newGraceTimers(),
+		heartbeatStop:      make(chan struct{}),
 	}
-	go wsm.run()
+	matchmakingManager.games = gameManager
+	gameManager.setSymbolBalance(userManager.SymbolBalance)
+	wsm.Configure(config.Default().WebSocket)
+	wsm.ConfigureRateLimits(config.Default().RateLimit)
+	wsm.ConfigureAbandonment(config.Default().Abandonment)
+	wsm.SetBackplane(backplane.NewMemory(), config.Default().Backplane) // Cannot fail on a new Memory
+	// Other nodes free the seats of players they matched into games hosted here
+	wsm.OnGameCompleted(func(game *models.Game) { wsm.cluster.gameEnded(game) })
 	return wsm
 }
 
-// run listens for register/unregister requests and manages clients.
-func (wsm *WebSocketManager) run() {
-	for {
-		select {
-		case conn := <-wsm.register:
-			// Register a new client
-			wsm.mu.Lock()
-			wsm.clients[conn] = nil // Initially, no user is associated with the connection
-			wsm.mu.Unlock()
-
-		case conn := <-wsm.unregister:
-			// Unregister a client
-			wsm.mu.Lock()
-			if _, ok := wsm.clients[conn]; ok {
-				delete(wsm.clients, conn)
-				conn.Close() // Close the WebSocket connection
+// Configure applies WebSocket settings. It must be called before connections are accepted.
+// Without allowed origins, only same-origin browser requests are accepted.
+func (wsm *WebSocketManager) Configure(cfg config.WebSocket) {
+	wsm.upgrader.ReadBufferSize = cfg.ReadBufferSize
+	wsm.upgrader.WriteBufferSize = cfg.WriteBufferSize
+	wsm.upgrader.HandshakeTimeout = time.Duration(cfg.HandshakeTimeout)
+	wsm.upgrader.EnableCompression = cfg.EnableCompression
+	wsm.upgrader.Subprotocols = codec.Subprotocols()
+	wsm.upgrader.CheckOrigin = nil
+	if len(cfg.AllowedOrigins) > 0 {
+		wsm.upgrader.CheckOrigin = wsm.allowOrigins(cfg.AllowedOrigins)
+	}
+	wsm.maxMessageSize = cfg.MaxMessageSize
+	wsm.readTimeout = time.Duration(cfg.ReadTimeout)
+	wsm.writeTimeout = time.Duration(cfg.WriteTimeout)
+	wsm.pingInterval = time.Duration(cfg.PingInterval)
+	wsm.sendQueueSize = cfg.SendQueueSize
+	wsm.slowConsumerPolicy = cfg.SlowConsumerPolicy
+}
+
+// ConfigureRateLimits applies rate limit budgets. It must be called before connections are accepted.
+func (wsm *WebSocketManager) ConfigureRateLimits(cfg config.RateLimit) {
+	wsm.rateLimits = newRateLimits(cfg)
+}
+
+// allowOrigins returns an origin check that accepts requests without an Origin header and
+// requests from any of the listed origins. "*" accepts every origin and a host starting with
+// "*." accepts every subdomain of the rest of the host.
+func (wsm *WebSocketManager) allowOrigins(origins []string) func(r *http.Request) bool {
+	type wildcard struct{ scheme, hostSuffix string }
+	exact := make(map[string]bool, len(origins))
+	var wildcards []wildcard
+	for _, origin := range origins {
+		origin = strings.ToLower(origin)
+		u, err := url.Parse(origin)
+		if err == nil && strings.HasPrefix(u.Host, "*.") {
+			wildcards = append(wildcards, wildcard{scheme: u.Scheme, hostSuffix: u.Host[1:]})
+			continue
+		}
+		exact[origin] = true
+	}
+
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" || exact["*"] || exact[strings.ToLower(origin)] {
+			return true
+		}
+		if u, err := url.Parse(strings.ToLower(origin)); err == nil {
+			for _, w := range wildcards {
+				if u.Scheme == w.scheme && strings.HasSuffix(u.Host, w.hostSuffix) {
+					return true
+				}
 			}
-			wsm.mu.Unlock()
 		}
+		wsm.logger.Warn("rejected websocket origin", "origin", origin, "remote_addr", r.RemoteAddr)
+		return false
 	}
 }
 
+// SetPacketLogLevel sets the level at which every inbound packet is logged with its type and latency.
+func (wsm *WebSocketManager) SetPacketLogLevel(level slog.Level) {
+	wsm.packetLogLevel = level
+}
+
+// ClientCount returns the number of open connections over every transport.
+func (wsm *WebSocketManager) ClientCount() int {
+	return wsm.clients.count()
+}
+
+// IsOnline reports whether the named user has an open connection to this node.
+func (wsm *WebSocketManager) IsOnline(username string) bool {
+	return len(wsm.clients.forUser(username)) > 0
+}
+
+// OnlineUsers returns the usernames of every user with an open connection to this node.
+func (wsm *WebSocketManager) OnlineUsers() []string {
+	return wsm.clients.usernames()
+}
+
+// UserConnections returns the named user's open connections to this node.
+func (wsm *WebSocketManager) UserConnections(username string) []models.Connection {
+	clients := wsm.clients.forUser(username)
+	conns := make([]models.Connection, len(clients))
+	for i, c := range clients {
+		conns[i] = c
+	}
+	return conns
+}
+
+// admit refuses new connections while shutting down or when the remote IP has used up its
+// connection budget, writing the response itself. It reports whether the connection may proceed.
+func (wsm *WebSocketManager) admit(w http.ResponseWriter, r *http.Request) bool {
+	if wsm.shuttingDown.Load() {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return false
+	}
+	if ok, retryAfter := wsm.rateLimits.connections.allow(remoteIP(r.RemoteAddr), time.Now()); !ok {
+		wsm.logger.Warn("connection rate limited", "remote_addr", r.RemoteAddr, "retry_after", retryAfter)
+		rateLimited.Inc("connect")
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		http.Error(w, "Too many connections", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
 func (wsm *WebSocketManager) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !wsm.admit(w, r) {
+		return
+	}
+
 	conn, err := wsm.upgrader.Upgrade(w, r, nil) // Upgrade the HTTP connection to a WebSocket connection
 	if err != nil {
-		// Log the error or send an HTTP error response
+		wsm.logger.Warn("websocket upgrade failed", "remote_addr", r.RemoteAddr, "error", err)
 		http.Error(w, "Could not upgrade to WebSocket", http.StatusInternalServerError)
 		return
 	}
 
+	// Bound how much a client can send in one message
+	conn.SetReadLimit(wsm.maxMessageSize)
+
+	logger := wsm.logger.With("conn_id", utils.GenerateConnectionID(), "remote_addr", r.RemoteAddr, "subprotocol", conn.Subprotocol())
+	connCodec := codec.ForSubprotocol(conn.Subprotocol())
+	c := newClient(newWebSocketTransport(conn, connCodec.MessageType(), wsm.writeTimeout), connCodec, wsm.sendQueueSize, wsm.slowConsumerPolicy, logger)
+	c.remoteIP = remoteIP(r.RemoteAddr)
+
+	// Keep the connection alive while the client answers pings
+	conn.SetPongHandler(wsm.handlePong(c, conn))
+	wsm.startHeartbeat()
+
 	// Register the new WebSocket connection with the manager
-	wsm.register <- conn
+	wsm.clients.add(c)
+	logger.Info("connection opened")
 
-	// Start a goroutine to handle messages from this connection
-	go wsm.handleMessages(conn)
+	// Start goroutines to write queued messages to and handle messages from this connection
+	go c.writePump()
+	go wsm.handleMessages(c, conn, logger)
+}
+
+// userFor returns the user bound to a client, or nil before it has sent a connect packet.
+func (wsm *WebSocketManager) userFor(c *client) *models.User {
+	return wsm.clients.userFor(c)
 }
 
-// handleMessages reads and processes messages from the connection.
 // handleMessages reads and processes messages from a specific WebSocket connection.
-func (wsm *WebSocketManager) handleMessages(conn *websocket.Conn) {
-	defer func() {
-		user := wsm.clients[conn]
-		if user != nil {
-			wsm.userManager.UpdateUserStats(user.Username, false, false) // Update stats for disconnects, if necessary
-		}
-		wsm.unregister <- conn
-		conn.Close()
-	}()
+func (wsm *WebSocketManager) handleMessages(c *client, conn *websocket.Conn, logger *slog.Logger) {
+	defer wsm.disconnect(c, logger)
 
 	for {
+		if wsm.readTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(wsm.readTimeout))
+		}
 		_, message, err := conn.ReadMessage()
 		if err != nil {
-			wsm.sendError(conn, "Failed to read message")
+			logger.Info("failed to read message", "error", err)
+			wsm.sendError(c, "", models.ErrReadFailed)
 			break
 		}
+		c.touch()
 
-		var basePacket models.BasePacket
-		if err := json.Unmarshal(message, &basePacket); err != nil {
-			wsm.sendError(conn, "Invalid packet format")
-			continue
+		if !wsm.handleMessage(c, logger, message) {
+			// Let the writer deliver the reply explaining why before the connection is closed
+			c.closeAfterFlush(websocket.ClosePolicyViolation, "")
+			select {
+			case <-c.done:
+			case <-time.After(wsm.writeTimeout):
+			}
+			break
 		}
+	}
+}
 
-		switch basePacket.Type {
-		case utils.ConnectPacketType:
-			var packet models.ConnectPacket
-			if err := json.Unmarshal(message, &packet); err != nil {
-				wsm.sendError(conn, "Invalid connect packet format")
-				continue
-			}
-			user := wsm.userManager.CreateUser(packet.Username, packet.DeviceID)
-			wsm.clients[conn] = user
-			user.SetConnection(conn)
-			wsm.sendUserStats(conn, user)
-
-		case utils.PlayPacketType:
-			var packet models.PlayPacket
-			if err := json.Unmarshal(message, &packet); err != nil {
-				wsm.sendError(conn, "Invalid play packet format")
-				continue
-			}
-			var err error
-			user, err := wsm.userManager.GetUser(packet.Username)
-			if err != nil {
-				wsm.sendError(conn, "User not registered")
-				continue
-			}
-			// Handle matchmaking and game initiation
-			game, err := wsm.matchmakingManager.RequestMatch(context.Background(), user)
-			if err != nil {
-				wsm.sendError(conn, "Error in matchmaking")
-				continue
-			}
-			if game != nil {
-				wsm.gameManager.games[game.ID] = game
-				// Game found, notify both players
-				wsm.notifyGameStart(game)
-			} else {
-				// No match found within timeout, notify player
-				wsm.sendNoMatchFound(conn)
-			}
+// disconnect closes and unregisters a client whose connection has gone away, and abandons its
+// user's game if it was their last session. Only the first call for a client does anything.
+func (wsm *WebSocketManager) disconnect(c *client, logger *slog.Logger) {
+	c.close()
+	user, lastSession, ok := wsm.clients.remove(c)
+	if !ok {
+		return // Already torn down
+	}
+	if lastSession {
+		wsm.cluster.userLeft(user)
+		wsm.abandonActiveGame(user)
+	}
+	logger.Info("connection closed")
+}
 
-		case utils.MovePacketType:
-			var packet models.MovePacket
-			if err := json.Unmarshal(message, &packet); err != nil {
-				wsm.sendError(conn, "Invalid move packet format")
-				continue
-			}
-			user, ok := wsm.clients[conn]
+// handleMessage processes a single inbound message from any transport and logs it. It returns
+// whether the connection should keep reading.
+func (wsm *WebSocketManager) handleMessage(c *client, logger *slog.Logger, message []byte) bool {
+	start := time.Now()
+	packetType, keepReading := wsm.handlePacket(c, logger, message)
+	attrs := []any{"type", packetType, "latency", time.Since(start)}
+	if user := wsm.userFor(c); user != nil {
+		attrs = append(attrs, "username", user.Username)
+	}
+	logger.Log(context.Background(), wsm.packetLogLevel, "packet handled", attrs...)
+	return keepReading
+}
+
+// handlePacket processes a single inbound message. It returns the packet type and whether the
+// connection should keep reading.
+func (wsm *WebSocketManager) handlePacket(c *client, logger *slog.Logger, message []byte) (string, bool) {
+	user := wsm.userFor(c)
+	req := &packetRequest{
+		conn:    c,
+		user:    user,
+		logger:  logger,
+		rateKey: rateKey(c, user),
+		bind: func(user *models.User, version int, capabilities []string) {
+			c.negotiated.Store(&negotiation{protocolVersion: version, capabilities: capabilities})
+			previous, previousLeft, ok := wsm.clients.bind(c, user)
 			if !ok {
-				wsm.sendError(conn, "User not registered")
-				continue
-			}
-			// Validate and process the move, update game state
-			game, err := wsm.gameManager.UpdateGame(packet.GameID, user, packet.Row, packet.Col)
-			if err != nil {
-				wsm.sendError(conn, "Invalid move or not your turn")
-				continue
+				return // The connection closed while the packet was handled
 			}
-
-			if game != nil {
-				// Game found, notify both players
-				wsm.notifyGameUpdate(game)
-			} else {
-				// No match found within timeout, notify player
-				wsm.sendNoMatchFound(conn)
+			if previousLeft {
+				wsm.cluster.userLeft(previous) // The connection switched users
 			}
+			wsm.cluster.userJoined(user)
+			wsm.userReturned(user)
+		},
+	}
+	var packetType string
+	var err error
+	if ok, retryAfter := c.packets.take(time.Now(), wsm.rateLimits.packets); ok {
+		packetType, err = wsm.dispatch(c.codec, message, req)
+	} else {
+		// Only the header is read so the rate limited reply still echoes the request ID
+		if header, headerErr := c.codec.PacketHeader(message); headerErr == nil {
+			req.id = header.RequestID
+		}
+		err = &rateLimitedError{scope: "connection", retryAfter: retryAfter}
+	}
+	packetsReceived.Inc(packetTypeLabel(packetType))
 
-		// Handle other packet types as necessary
-
-		default:
-			wsm.sendError(conn, "Unknown packet type")
+	// Persistent abusers are disconnected
+	var limitedErr *rateLimitedError
+	if errors.As(err, &limitedErr) {
+		rateLimited.Inc(limitedErr.scope)
+		c.violations++
+		if limit := wsm.rateLimits.maxViolations; limit > 0 && c.violations >= limit {
+			logger.Warn("disconnecting rate limited client", "violations", c.violations, "scope", limitedErr.scope)
+			req.disconnect = true
 		}
+	} else {
+		c.violations = 0
 	}
+
+	if err != nil {
+		wsm.replyWithError(req, packetType, err)
+	}
+	return packetType, !req.disconnect
 }
 
 // sendUserStats sends the user's stats back to the client.
-func (wsm *WebSocketManager) sendUserStats(conn *websocket.Conn, user *models.User) {
-	userStatsPacket := models.GameUpdatePacket{
-		BasePacket:  models.BasePacket{Type: utils.UserStatsPacketType},
-		GameID:      "",             // No game ID needed for user stats
-		Board:       [3][3]string{}, // Empty for user stats
-		CurrentTurn: false,          // Empty for user stats
-		Winner:      "",             // Empty for user stats, could include additional stats fields as needed
-		Status:      "started",
-	}
-	msg, err := json.Marshal(userStatsPacket)
+func (wsm *WebSocketManager) sendUserStats(conn models.Connection, requestID string, user *models.User) {
+	stats, err := wsm.userManager.Stats(user.Username)
 	if err != nil {
-		// Log error, handle failure to marshal packet
+		wsm.logger.Error("failed to read user stats", "username", user.Username, "error", err)
 		return
 	}
-	conn.WriteMessage(websocket.TextMessage, msg)
+	userStatsPacket := models.UserStatsPacket{
+		BasePacket: models.BasePacket{Type: utils.UserStatsPacketType, RequestID: requestID},
+		Stats:      newUserStatsResponse(user.Username, stats),
+	}
+	conn.Send(userStatsPacket)
 }
 
-// notifyGameStart notifies both players involved in a game that the game has started.
-func (wsm *WebSocketManager) notifyGameStart(game *models.Game) {
-	if len(game.Players) != 2 {
-		// Log error: Game must have exactly two players
+// sendGameStart tells a player that their game has started, in reply to their play request. Each
+// player's own RequestMatch returns the game, so each is told separately.
+func (wsm *WebSocketManager) sendGameStart(conn models.Connection, requestID string, game *models.Game, player *models.User) {
+	matchFoundPacket, ok := newMatchFoundPacket(game, player)
+	if !ok {
+		wsm.logger.Error("game must have exactly two players including the player", "game_id", game.ID, "players", len(game.Players))
 		return
 	}
+	matchFoundPacket.RequestID = requestID
+
+	// Send the packet to the player's WebSocket connection
+	if err := conn.Send(matchFoundPacket); err != nil {
+		wsm.logger.Warn("failed to send game start packet", "game_id", game.ID, "username", player.Username, "error", err)
+	}
+}
+
+// newMatchFoundPacket builds the game start packet for one of the game's players. It reports false
+// if player is not one of exactly two players.
+func newMatchFoundPacket(game *models.Game, player *models.User) (models.MatchFoundPacket, bool) {
+	if len(game.Players) != 2 {
+		return models.MatchFoundPacket{}, false
+	}
 
 	// Assign symbols and turns
 	symbols := []string{"X", "O"} // First player is "X", second player is "O"
-	for i, player := range game.Players {
+	for i, p := range game.Players {
+		if p != player {
+			continue
+		}
 		opponent := game.Players[1-i] // Get the other player as the opponent
-		matchFoundPacket := models.MatchFoundPacket{
+		return models.MatchFoundPacket{
 			BasePacket: models.BasePacket{Type: utils.GameStartPacketType},
 			GameID:     game.ID,
 			Opponent:   opponent.Username,
 			YourSymbol: symbols[i],                     // Assign "X" to the first player and "O" to the second
 			YourTurn:   game.CurrentTurn == symbols[i], // The first player ("X") starts the game
-		}
-
-		// Serialize the MatchFoundPacket to JSON
-		msg, err := json.Marshal(matchFoundPacket)
-		if err != nil {
-			// Log error: Failed to marshal MatchFoundPacket
-			continue
-		}
-		// Send the packet to the player's WebSocket connection
-		if err := player.SendMessage(msg); err != nil {
-			// Log error: Failed to send MatchFoundPacket
-		}
+			Variant:    game.Variant,
+			MoveTimeMs: game.MoveTime.Milliseconds(),
+		}, true
 	}
+	return models.MatchFoundPacket{}, false
 }
 
 // sendNoMatchFound notifies a player that no match was found within the timeout.
-func (wsm *WebSocketManager) sendNoMatchFound(conn *websocket.Conn) {
+func (wsm *WebSocketManager) sendNoMatchFound(conn models.Connection, requestID string) {
 	// Construct and send a packet indicating no match was found
 	noMatchPacket := models.BasePacket{
-		Type: utils.NoMatchFoundType,
+		Type:      utils.NoMatchFoundType,
+		RequestID: requestID,
 	}
-	msg, err := json.Marshal(noMatchPacket)
-	if err != nil {
-		// Log error, handle failure to marshal packet
-		return
+	conn.Send(noMatchPacket)
+}
+
+// sendError sends an error to the client, naming the request that caused it if known.
+func (wsm *WebSocketManager) sendError(conn models.Connection, requestID string, clientErr *models.Error) {
+	errorPacket := models.ErrorPacket{
+		BasePacket: models.BasePacket{Type: utils.ErrorPacketType, RequestID: requestID},
+		Code:       clientErr.Code,
+		Message:    clientErr.Message,
 	}
-	conn.WriteMessage(websocket.TextMessage, msg)
+	errorPacketsSent.Inc(string(clientErr.Code))
+	conn.Send(errorPacket)
 }
 
-// sendError sends an error message to the client.
-func (wsm *WebSocketManager) sendError(conn *websocket.Conn, errorMsg string) {
+// sendValidationError tells the client which fields of its packet were rejected.
+func (wsm *WebSocketManager) sendValidationError(conn models.Connection, requestID string, validationErr *models.ValidationError) {
 	errorPacket := models.ErrorPacket{
-		BasePacket: models.BasePacket{Type: utils.ErrorPacketType},
-		Message:    errorMsg,
+		BasePacket: models.BasePacket{Type: utils.ErrorPacketType, RequestID: requestID},
+		Code:       models.ErrorCodeValidationFailed,
+		Message:    "Invalid packet",
+		Errors:     validationErr.Fields,
 	}
-	msg, _ := json.Marshal(errorPacket)
-	conn.WriteMessage(websocket.TextMessage, msg)
+	errorPacketsSent.Inc(string(errorPacket.Code))
+	conn.Send(errorPacket)
 }
 
-// Add a new function to notify both players about the updated game state
-func (wsm *WebSocketManager) notifyGameUpdate(game *models.Game) {
+// sendRateLimited tells the client it is sending too fast and when it may send again.
+func (wsm *WebSocketManager) sendRateLimited(conn models.Connection, requestID string, retryAfter time.Duration) {
+	wsm.sendRetryAfter(conn, requestID, models.ErrRateLimited, retryAfter)
+}
+
+// sendRetryAfter tells the client its request was refused for now and when it may try again.
+func (wsm *WebSocketManager) sendRetryAfter(conn models.Connection, requestID string, clientErr *models.Error, retryAfter time.Duration) {
+	errorPacket := models.ErrorPacket{
+		BasePacket:   models.BasePacket{Type: utils.ErrorPacketType, RequestID: requestID},
+		Code:         clientErr.Code,
+		Message:      clientErr.Message,
+		RetryAfterMs: (retryAfter + time.Millisecond - 1).Milliseconds(), // Rounded up so it is never 0
+	}
+	errorPacketsSent.Inc(string(errorPacket.Code))
+	conn.Send(errorPacket)
+}
+
+// sendBanned tells the client that a ban or suspension bars them and when it ends.
+func (wsm *WebSocketManager) sendBanned(conn models.Connection, requestID string, sanction *models.Sanction) {
+	bannedPacket := models.BannedPacket{
+		BasePacket: models.BasePacket{Type: utils.BannedPacketType, RequestID: requestID},
+		Kind:       string(sanction.Kind),
+		Reason:     sanction.Reason,
+		Message:    "You are banned permanently",
+	}
+	if !sanction.IsPermanent() {
+		until := sanction.ExpiresAt
+		bannedPacket.Until = &until
+		bannedPacket.Message = fmt.Sprintf("You are banned until %s", until.UTC().Format(time.RFC3339))
+	}
+	conn.Send(bannedPacket)
+}
+
+// relayChat forwards a chat message from one player to the other players in the game, and to the
+// sender's other sessions so every device shows the conversation, on this node and every other.
+func (wsm *WebSocketManager) relayChat(game *models.Game, sender *models.User, from models.Connection, message string) error {
+	isPlayer := false
 	for _, player := range game.Players {
-		gameUpdatePacket := models.GameUpdatePacket{
-			BasePacket:  models.BasePacket{Type: "gameUpdate"},
-			GameID:      game.ID,
-			Board:       game.Board,                   // Send the updated board
-			CurrentTurn: game.IsPlayerCurrent(player), // Send the current turn
-			Winner:      game.Winner,                  // Send the winner, if any
-			Status:      game.Status,
+		if player == sender {
+			isPlayer = true
 		}
-		msg, err := json.Marshal(gameUpdatePacket)
-		if err != nil {
-			// Log error, handle failure to marshal packet
-			continue
+	}
+	if !isPlayer {
+		return models.ErrNotInGame
+	}
+
+	chatPacket := models.ChatPacket{
+		BasePacket: models.BasePacket{Type: utils.ChatPacketType},
+		GameID:     game.ID,
+		From:       sender.Username,
+		Message:    message,
+	}
+	for _, player := range game.Players {
+		for _, conn := range player.Connections() {
+			if conn == from {
+				continue
+			}
+			if c, ok := conn.(*client); ok && !c.hasCapability(utils.CapabilityChat) {
+				continue // This client cannot display chat
+			}
+			if err := conn.Send(chatPacket); err != nil {
+				wsm.logger.Warn("failed to relay chat message", "game_id", game.ID, "username", player.Username, "error", err)
+			}
 		}
-		player.SendMessage(msg)
-	}
-	if game.Status == utils.GameStateCompleted {
-		// The game has ended, send "gameEnd" packets to both players
-		var winner, loser *models.User
-		if game.Winner == game.Players[0].Username {
-			winner = game.Players[0]
-			loser = game.Players[1]
-		} else {
-			winner = game.Players[1]
-			loser = game.Players[0]
+		if err := wsm.cluster.deliver(player.Username, chatPacket); err != nil {
+			wsm.logger.Warn("failed to relay chat message to other nodes", "game_id", game.ID, "username", player.Username, "error", err)
 		}
+	}
+	return nil
+}
 
-		// Send "gameEnd" packet to the winner
-		wsm.sendGameEndWinPacket(winner, game.ID, winner.Username)
-
-		// Send "gameEnd" packet to the loser
-		wsm.sendGameEndLosePacket(loser, game.ID, winner.Username)
+// notifyGameUpdate sends the updated game state to both players and any spectators, and completes
+// the game if it has ended. The copy sent to mover, the player whose request changed the game,
+// carries requestID.
+func (wsm *WebSocketManager) notifyGameUpdate(game *models.Game, mover *models.User, requestID string) {
+	for _, player := range game.Players {
+		gameUpdatePacket := newGameUpdatePacket(game, player)
+		if player == mover {
+			gameUpdatePacket.RequestID = requestID
+		}
+		if err := wsm.sendToUser(player, gameUpdatePacket); err != nil {
+			wsm.logger.Warn("failed to send game update packet", "game_id", game.ID, "username", player.Username, "error", err)
+		}
+	}
+	for _, conn := range wsm.gameManager.Spectators(game.ID) {
+		if err := conn.Send(newGameUpdatePacket(game, nil)); errors.Is(err, ErrClientClosed) {
+			wsm.gameManager.StopSpectating(game.ID, conn)
+		}
 	}
+	wsm.completeGame(game)
 }
 
-func (wsm *WebSocketManager) sendGameEndWinPacket(player *models.User, gameID string, winner string) {
-	gameEndPacket := models.GameEndPacket{
-		BasePacket: models.BasePacket{Type: "gameEnd"},
-		GameID:     gameID,
-		Winner:     winner,
-		Outcome:    "win",
+// sendToUser sends a packet to every session of the user, on this node and every other.
+func (wsm *WebSocketManager) sendToUser(user *models.User, packet any) error {
+	var errs []error
+	if len(user.Connections()) > 0 {
+		errs = append(errs, user.SendPacket(packet))
 	}
-	msg, err := json.Marshal(gameEndPacket)
-	if err != nil {
-		// Log error, handle failure to marshal packet
-		return
+	errs = append(errs, wsm.cluster.deliver(user.Username, packet))
+	return errors.Join(errs...)
+}
+
+// sendGameState sends the current game state to one player in reply to their request.
+func (wsm *WebSocketManager) sendGameState(conn models.Connection, requestID string, game *models.Game, player *models.User) {
+	gameUpdatePacket := newGameUpdatePacket(game, player)
+	gameUpdatePacket.RequestID = requestID
+	conn.Send(gameUpdatePacket)
+}
+
+// newGameUpdatePacket builds the game state as seen by player.
+func newGameUpdatePacket(game *models.Game, player *models.User) models.GameUpdatePacket {
+	return models.GameUpdatePacket{
+		BasePacket:  models.BasePacket{Type: "gameUpdate"},
+		GameID:      game.ID,
+		Board:       game.Board,                   // Send the updated board
+		CurrentTurn: game.IsPlayerCurrent(player), // Send the current turn
+		Winner:      game.Winner,                  // Send the winner, if any
+		Status:      game.Status,
+		Ply:         game.Ply(),
 	}
-	player.SendMessage(msg)
 }
 
-func (wsm *WebSocketManager) sendGameEndLosePacket(player *models.User, gameID string, winner string) {
+// sendGameEnd tells a player how a game ended for them: "win", "lose" or "draw".
+func (wsm *WebSocketManager) sendGameEnd(player *models.User, game *models.Game, outcome string) {
 	gameEndPacket := models.GameEndPacket{
 		BasePacket: models.BasePacket{Type: "gameEnd"},
-		GameID:     gameID,
-		Winner:     winner,
-		Outcome:    "lose",
+		GameID:     game.ID,
+		Outcome:    outcome,
 	}
-	msg, err := json.Marshal(gameEndPacket)
-	if err != nil {
-		// Log error, handle failure to marshal packet
-		return
+	if outcome != outcomeDraw {
+		gameEndPacket.Winner = game.Winner
+	}
+	if err := wsm.sendToUser(player, gameEndPacket); err != nil {
+		wsm.logger.Warn("failed to send game end packet", "game_id", game.ID, "username", player.Username, "error", err)
 	}
-	player.SendMessage(msg)
 }
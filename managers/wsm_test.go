@@ -1,7 +1,10 @@
 package managers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -9,7 +12,10 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"tictactoe/codec"
+	"tictactoe/config"
 	"tictactoe/models"
+	"tictactoe/utils"
 )
 
 // Helper function to create a WebSocket connection and simulate a client
@@ -30,11 +36,12 @@ func createWebSocketConnection(t *testing.T, wsm *WebSocketManager) (*websocket.
 }
 
 func TestUserActions(t *testing.T) {
-	// Create instances of UserManager, GameManager, MatchmakingManager, and WebSocketManager
+	// Create instances of UserManager, GameManager, MatchmakingManager, ModerationManager, and WebSocketManager
 	userManager := NewUserManager()
 	gameManager := NewGameManager()
 	matchmakingManager := NewMatchmakingManager()
-	wsm := NewWebSocketManager(userManager, gameManager, matchmakingManager)
+	moderationManager := NewModerationManager()
+	wsm := NewWebSocketManager(userManager, gameManager, matchmakingManager, moderationManager)
 
 	// Create WebSocket connections for user1 and user2
 	conn1, server1 := createWebSocketConnection(t, wsm)
@@ -47,9 +54,10 @@ func TestUserActions(t *testing.T) {
 
 	// Simulate user1's actions
 	connectPacket1 := models.ConnectPacket{
-		BasePacket: models.BasePacket{Type: "connect"},
-		Username:   "user1",
-		DeviceID:   "device1",
+		BasePacket:      models.BasePacket{Type: "connect"},
+		Username:        "user1",
+		DeviceID:        "device1",
+		ProtocolVersion: utils.ProtocolVersion,
 	}
 	connectPacketJSON1, _ := json.Marshal(connectPacket1)
 	conn1.WriteMessage(websocket.TextMessage, connectPacketJSON1)
@@ -72,9 +80,10 @@ func TestUserActions(t *testing.T) {
 
 	// Simulate user2's actions
 	connectPacket2 := models.ConnectPacket{
-		BasePacket: models.BasePacket{Type: "connect"},
-		Username:   "user2",
-		DeviceID:   "device2",
+		BasePacket:      models.BasePacket{Type: "connect"},
+		Username:        "user2",
+		DeviceID:        "device2",
+		ProtocolVersion: utils.ProtocolVersion,
 	}
 	connectPacketJSON2, _ := json.Marshal(connectPacket2)
 	conn2.WriteMessage(websocket.TextMessage, connectPacketJSON2)
@@ -101,3 +110,362 @@ func TestUserActions(t *testing.T) {
 	// Wait for a brief moment to allow the WebSocketManager to process messages
 	time.Sleep(100 * time.Millisecond)
 }
+
+func TestProtocolHandshake(t *testing.T) {
+	wsm := NewWebSocketManager(NewUserManager(), NewGameManager(), NewMatchmakingManager(), NewModerationManager())
+
+	// A current client is welcomed with the capabilities both sides support
+	conn, server := createWebSocketConnection(t, wsm)
+	defer conn.Close()
+	defer server.Close()
+	conn.WriteJSON(models.ConnectPacket{
+		BasePacket:      models.BasePacket{Type: utils.ConnectPacketType},
+		Username:        "current",
+		ProtocolVersion: utils.ProtocolVersion + 1,
+		Capabilities:    []string{utils.CapabilityChat, "teleport"},
+	})
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var welcome models.WelcomePacket
+	if err := conn.ReadJSON(&welcome); err != nil || welcome.Type != utils.WelcomePacketType {
+		t.Fatalf("Expected welcome packet, got %+v (%v)", welcome, err)
+	}
+	if welcome.ProtocolVersion != utils.ProtocolVersion {
+		t.Errorf("Expected protocol version %d, got %d", utils.ProtocolVersion, welcome.ProtocolVersion)
+	}
+	if len(welcome.Capabilities) != 1 || welcome.Capabilities[0] != utils.CapabilityChat {
+		t.Errorf("Expected only the chat capability, got %v", welcome.Capabilities)
+	}
+	if welcome.Limits.MaxMessageSize != wsm.maxMessageSize {
+		t.Errorf("Expected max message size %d, got %d", wsm.maxMessageSize, welcome.Limits.MaxMessageSize)
+	}
+
+	// A client that predates versioning is told to upgrade and disconnected
+	oldConn, oldServer := createWebSocketConnection(t, wsm)
+	defer oldConn.Close()
+	defer oldServer.Close()
+	oldConn.WriteJSON(models.ConnectPacket{
+		BasePacket: models.BasePacket{Type: utils.ConnectPacketType},
+		Username:   "outdated",
+	})
+	oldConn.SetReadDeadline(time.Now().Add(time.Second))
+	var upgrade models.UpgradeRequiredPacket
+	if err := oldConn.ReadJSON(&upgrade); err != nil || upgrade.Type != utils.UpgradeRequiredType {
+		t.Fatalf("Expected upgradeRequired packet, got %+v (%v)", upgrade, err)
+	}
+	if upgrade.MinProtocolVersion != utils.MinProtocolVersion {
+		t.Errorf("Expected minimum protocol version %d, got %d", utils.MinProtocolVersion, upgrade.MinProtocolVersion)
+	}
+	if _, _, err := oldConn.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("Expected the connection to be closed, got %v", err)
+	}
+}
+
+func TestMsgpackSubprotocol(t *testing.T) {
+	wsm := NewWebSocketManager(NewUserManager(), NewGameManager(), NewMatchmakingManager(), NewModerationManager())
+	server := httptest.NewServer(http.HandlerFunc(wsm.HandleWebSocket))
+	defer server.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{codec.MsgpackSubprotocol}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to WebSocket server: %v", err)
+	}
+	defer conn.Close()
+	if conn.Subprotocol() != codec.MsgpackSubprotocol {
+		t.Fatalf("Expected the msgpack subprotocol to be negotiated, got %q", conn.Subprotocol())
+	}
+
+	connectPacket, _ := codec.Msgpack.Marshal(models.ConnectPacket{
+		BasePacket:      models.BasePacket{Type: utils.ConnectPacketType},
+		Username:        "mobile",
+		ProtocolVersion: utils.ProtocolVersion,
+	})
+	conn.WriteMessage(websocket.BinaryMessage, connectPacket)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	messageType, message, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to read welcome packet: %v", err)
+	}
+	if messageType != websocket.BinaryMessage {
+		t.Errorf("Expected a binary message, got type %d", messageType)
+	}
+	var welcome models.WelcomePacket
+	if err := codec.Msgpack.Unmarshal(message, &welcome); err != nil || welcome.Type != utils.WelcomePacketType {
+		t.Fatalf("Expected msgpack welcome packet, got %+v (%v)", welcome, err)
+	}
+}
+
+func TestShutdownNotifiesClients(t *testing.T) {
+	wsm := NewWebSocketManager(NewUserManager(), NewGameManager(), NewMatchmakingManager(), NewModerationManager())
+
+	conn, server := createWebSocketConnection(t, wsm)
+	defer conn.Close()
+	defer server.Close()
+
+	// Wait for the connection to be registered
+	for i := 0; i < 100 && wsm.ClientCount() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	clients := wsm.clients.all()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	wsm.Shutdown(ctx, false)
+
+	// Shutdown returns only once every connection is closed
+	for _, c := range clients {
+		select {
+		case <-c.done:
+		default:
+			t.Error("Expected every connection to be closed when Shutdown returns")
+		}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to read shutdown packet: %v", err)
+	}
+	var packet models.ServerShutdownPacket
+	if err := json.Unmarshal(message, &packet); err != nil || packet.Type != "serverShutdown" {
+		t.Fatalf("Expected serverShutdown packet, got %s", message)
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("Expected a going away close frame after the shutdown packet, got %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	wsm.HandleReadyz(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz to return 503 after shutdown, got %d", recorder.Code)
+	}
+}
+
+func TestOriginAllowList(t *testing.T) {
+	wsm := NewWebSocketManager(NewUserManager(), NewGameManager(), NewMatchmakingManager(), NewModerationManager())
+	cfg := config.Default().WebSocket
+	cfg.AllowedOrigins = []string{"https://play.example.com", "https://*.partner.com"}
+	wsm.Configure(cfg)
+
+	for origin, want := range map[string]bool{
+		"":                         true, // Non-browser clients send no Origin
+		"https://play.example.com": true,
+		"https://a.partner.com":    true,
+		"http://a.partner.com":     false,
+		"https://partner.com":      false,
+		"https://evil.com":         false,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if got := wsm.upgrader.CheckOrigin(r); got != want {
+			t.Errorf("CheckOrigin(%q) = %v, want %v", origin, got, want)
+		}
+	}
+}
+
+func TestHeartbeatReapsSilentConnections(t *testing.T) {
+	wsm := NewWebSocketManager(NewUserManager(), NewGameManager(), NewMatchmakingManager(), NewModerationManager())
+	cfg := config.Default().WebSocket
+	cfg.PingInterval = config.Duration(20 * time.Millisecond)
+	cfg.ReadTimeout = config.Duration(60 * time.Millisecond)
+	wsm.Configure(cfg)
+
+	// The client never reads, so it never answers pings
+	conn, server := createWebSocketConnection(t, wsm)
+	defer conn.Close()
+	defer server.Close()
+
+	for i := 0; i < 100 && wsm.ClientCount() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if wsm.ClientCount() != 1 {
+		t.Fatalf("Expected the connection to be registered")
+	}
+
+	for i := 0; i < 100 && wsm.ClientCount() != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if wsm.ClientCount() != 0 {
+		t.Errorf("Expected the silent connection to be reaped")
+	}
+}
+
+func TestShutdownStopsHeartbeat(t *testing.T) {
+	wsm := newTestManager()
+	cfg := config.Default().WebSocket
+	cfg.PingInterval = config.Duration(10 * time.Millisecond)
+	wsm.Configure(cfg)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		wsm.heartbeat()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	wsm.Shutdown(ctx, false)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("Expected the heartbeat to stop at shutdown")
+	}
+}
+
+func TestWaitingForMatchKeepsConnectionAlive(t *testing.T) {
+	wsm := newTestManager()
+	cfg := config.Default().WebSocket
+	cfg.PingInterval = config.Duration(20 * time.Millisecond)
+	cfg.ReadTimeout = config.Duration(60 * time.Millisecond)
+	wsm.Configure(cfg)
+	wsm.matchmakingManager.Configure(config.Matchmaking{Timeout: config.Duration(300 * time.Millisecond)})
+
+	conn, server := createWebSocketConnection(t, wsm)
+	defer conn.Close()
+	defer server.Close()
+	conn.WriteJSON(connectPacket("alice"))
+	readPacketOfType(t, conn, utils.UserStatsPacketType)
+
+	// Waiting for an opponent takes longer than the read timeout, but reading answers the pings
+	conn.WriteJSON(models.PlayPacket{BasePacket: models.BasePacket{Type: utils.PlayPacketType, RequestID: "p1"}, Username: "alice"})
+	if reply := readPacketOfType(t, conn, utils.NoMatchFoundType); reply["requestId"] != "p1" {
+		t.Errorf("Expected noMatchFound in reply to the play request, got %v", reply)
+	}
+	if wsm.ClientCount() != 1 {
+		t.Errorf("Expected the connection to stay open while waiting for a match")
+	}
+}
+
+func TestConnectSendsUserStats(t *testing.T) {
+	wsm := newTestManager()
+	alice := wsm.userManager.CreateUser("alice", "")
+	bob := wsm.userManager.CreateUser("bob", "")
+	game, _ := wsm.gameManager.CreateGame(alice, bob, models.GameOptions{})
+	completed := completions(wsm)
+	req, _ := newTestRequest()
+	req.user = bob
+	if err := wsm.handleResign(req, &models.ResignPacket{GameID: game.ID}); err != nil {
+		t.Fatalf("Expected bob to resign, got %v", err)
+	}
+	waitForCompletion(t, completed)
+
+	conn, server := createWebSocketConnection(t, wsm)
+	defer conn.Close()
+	defer server.Close()
+	conn.WriteJSON(connectPacket("alice"))
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var packet models.UserStatsPacket
+		if err := conn.ReadJSON(&packet); err != nil {
+			t.Fatalf("Failed to read userStats packet: %v", err)
+		}
+		if packet.Type != utils.UserStatsPacketType {
+			continue
+		}
+		if stats := packet.Stats; stats.Username != "alice" || stats.Wins != 1 || stats.AsX.Games != 1 || stats.AsX.Wins != 1 {
+			t.Errorf("Expected alice's win as X in her stats, got %+v", stats)
+		}
+		return
+	}
+}
+
+func TestSlowConsumerPolicy(t *testing.T) {
+	// Hand the server side of each connection to the test without starting a writer goroutine, so
+	// nothing drains the send queue
+	conns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Failed to upgrade: %v", err)
+			return
+		}
+		conns <- conn
+	}))
+	defer server.Close()
+
+	newSlowClient := func(policy string) *client {
+		url := "ws" + strings.TrimPrefix(server.URL, "http")
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("Failed to connect to WebSocket server: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return newClient(newWebSocketTransport(<-conns, codec.JSON.MessageType(), time.Second), codec.JSON, 1, policy, slog.Default())
+	}
+
+	dropper := newSlowClient(config.SlowConsumerDrop)
+	if err := dropper.Send("first"); err != nil {
+		t.Fatalf("Expected the first message to be queued, got %v", err)
+	}
+	if err := dropper.Send("second"); !errors.Is(err, ErrSendQueueFull) {
+		t.Fatalf("Expected ErrSendQueueFull, got %v", err)
+	}
+	if err := dropper.Send("third"); !errors.Is(err, ErrSendQueueFull) {
+		t.Fatalf("Expected the drop policy to keep the client open, got %v", err)
+	}
+
+	disconnector := newSlowClient(config.SlowConsumerDisconnect)
+	disconnector.Send("first")
+	if err := disconnector.Send("second"); !errors.Is(err, ErrSendQueueFull) {
+		t.Fatalf("Expected ErrSendQueueFull, got %v", err)
+	}
+	if err := disconnector.Send("third"); !errors.Is(err, ErrClientClosed) {
+		t.Fatalf("Expected the disconnect policy to close the client, got %v", err)
+	}
+}
+
+func TestRateLimitedClientsAreDisconnected(t *testing.T) {
+	wsm := NewWebSocketManager(NewUserManager(), NewGameManager(), NewMatchmakingManager(), NewModerationManager())
+	wsm.ConfigureRateLimits(config.RateLimit{
+		Packets:       config.Bucket{Rate: 0.001, Burst: 1},
+		MaxViolations: 2,
+	})
+
+	conn, server := createWebSocketConnection(t, wsm)
+	defer conn.Close()
+	defer server.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	// The first packet is within budget and answered normally
+	conn.WriteJSON(models.BasePacket{Type: "teleport"})
+	var reply models.ErrorPacket
+	if err := conn.ReadJSON(&reply); err != nil || reply.Code != models.ErrorCodeUnknownPacketType {
+		t.Fatalf("Expected UNKNOWN_PACKET_TYPE, got %+v (%v)", reply, err)
+	}
+
+	conn.WriteJSON(models.BasePacket{Type: "teleport", RequestID: "r2"})
+	reply = models.ErrorPacket{}
+	if err := conn.ReadJSON(&reply); err != nil || reply.Code != models.ErrorCodeRateLimited || reply.RequestID != "r2" {
+		t.Fatalf("Expected RATE_LIMITED for r2, got %+v (%v)", reply, err)
+	}
+	if reply.RetryAfterMs <= 0 {
+		t.Errorf("Expected a retry-after value, got %d", reply.RetryAfterMs)
+	}
+
+	// Another limited packet in a row reaches the violation limit
+	conn.WriteJSON(models.BasePacket{Type: "teleport"})
+	conn.ReadJSON(&reply)
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("Expected the abusive client to be disconnected, got %v", err)
+	}
+}
+
+func TestConnectionsRateLimitedPerIP(t *testing.T) {
+	wsm := NewWebSocketManager(NewUserManager(), NewGameManager(), NewMatchmakingManager(), NewModerationManager())
+	wsm.ConfigureRateLimits(config.RateLimit{ConnectionsPerIP: config.Bucket{Rate: 0.001, Burst: 1}})
+
+	conn, server := createWebSocketConnection(t, wsm)
+	defer conn.Close()
+	defer server.Close()
+
+	recorder := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r.RemoteAddr = "127.0.0.1:5555"
+	wsm.HandleWebSocket(recorder, r)
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 429 with Retry-After, got %d %v", recorder.Code, recorder.Header())
+	}
+}
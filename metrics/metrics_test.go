@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistryWritesTextFormat(t *testing.T) {
+	registry := NewRegistry()
+
+	counter := &CounterVec{name: "packets_total", help: "Packets.", labels: []string{"type"}, values: make(map[string]float64)}
+	registry.Register(counter)
+	counter.Inc("move")
+	counter.Inc("move")
+
+	histogram := &HistogramVec{name: "wait_seconds", help: "Wait.", buckets: []float64{1, 5}, series: make(map[string]*histogramSeries)}
+	registry.Register(histogram)
+	histogram.Observe(0.5)
+	histogram.Observe(3)
+
+	registry.Register(&GaugeFunc{name: "clients", help: "Clients.", fn: func() float64 { return 7 }})
+
+	var out strings.Builder
+	registry.WriteText(&out)
+	got := out.String()
+
+	for _, want := range []string{
+		"# TYPE clients gauge\nclients 7\n",
+		"# TYPE packets_total counter\npackets_total{type=\"move\"} 2\n",
+		"wait_seconds_bucket{le=\"1\"} 1\n",
+		"wait_seconds_bucket{le=\"5\"} 2\n",
+		"wait_seconds_bucket{le=\"+Inf\"} 2\n",
+		"wait_seconds_sum 3.5\n",
+		"wait_seconds_count 2\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package models
+
+import "time"
+
+// Request and response bodies of the REST API. Field names match the WebSocket packets so clients
+// can share their types.
+
+// CredentialsRequest is the body of the register and login endpoints.
+type CredentialsRequest struct {
+	Username string `json:"username"`
+	DeviceID string `json:"deviceId"`
+}
+
+// SessionResponse carries the token that authenticates later API requests.
+type SessionResponse struct {
+	Username  string    `json:"username"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// UserStatsResponse is a user's game statistics.
+type UserStatsResponse struct {
+	Username     string              `json:"username"`
+	Wins         int                 `json:"wins"`
+	Losses       int                 `json:"losses"`
+	Draws        int                 `json:"draws"`
+	Abandonments int                 `json:"abandonments"`
+	AsX          SymbolStatsResponse `json:"asX"`
+	AsO          SymbolStatsResponse `json:"asO"`
+}
+
+// SymbolStatsResponse is a user's game statistics for the games they played with one symbol.
+type SymbolStatsResponse struct {
+	Games   int     `json:"games"`
+	Wins    int     `json:"wins"`
+	Losses  int     `json:"losses"`
+	Draws   int     `json:"draws"`
+	WinRate float64 `json:"winRate"` // Share of games won, from 0 to 1
+}
+
+// MoveRequest is the body of the submit move endpoint. Ply works as in MovePacket.
+type MoveRequest struct {
+	Row int  `json:"row"`
+	Col int  `json:"col"`
+	Ply *int `json:"ply,omitempty"`
+}
+
+// GamePlayer is a player in a GameResponse.
+type GamePlayer struct {
+	Username string `json:"username"`
+	Symbol   string `json:"symbol"`
+}
+
+// GameMove is an applied move in a GameResponse.
+type GameMove struct {
+	Player string `json:"player"`
+	Row    int    `json:"row"`
+	Col    int    `json:"col"`
+}
+
+// GameResponse is the full state of a game as seen by an observer.
+type GameResponse struct {
+	GameID      string       `json:"gameId"`
+	Players     []GamePlayer `json:"players"`
+	Board       [3][3]string `json:"board"`
+	CurrentTurn string       `json:"currentTurn"` // "X" or "O"
+	Status      string       `json:"status"`
+	Winner      string       `json:"winner,omitempty"`
+	Ply         int          `json:"ply"`
+	Moves       []GameMove   `json:"moves"`
+}
+
+// GameListResponse lists games matching the request's filters.
+type GameListResponse struct {
+	Games []GameResponse `json:"games"`
+}
+
+// LeaderboardEntry is a ranked user in a LeaderboardResponse.
+type LeaderboardEntry struct {
+	Rank int `json:"rank"`
+	UserStatsResponse
+}
+
+// LeaderboardResponse lists the users with the most wins.
+type LeaderboardResponse struct {
+	Entries []LeaderboardEntry `json:"entries"`
+}
+
+// ErrorResponse is the body of every failed API request. It mirrors ErrorPacket.
+type ErrorResponse struct {
+	Code         ErrorCode    `json:"code"`
+	Message      string       `json:"message"`
+	Errors       []FieldError `json:"errors,omitempty"`
+	RetryAfterMs int64        `json:"retryAfterMs,omitempty"`
+}
+
+// SanctionRequest is the body of the issue sanction endpoint. Actor names the operator for the
+// audit trail.
+type SanctionRequest struct {
+	Actor       string       `json:"actor"`
+	Kind        SanctionKind `json:"kind"`
+	SubjectType SubjectType  `json:"subjectType"`
+	Subject     string       `json:"subject"`
+	Reason      string       `json:"reason"`
+	DurationMs  int64        `json:"durationMs,omitempty"` // Zero makes a ban or mute permanent
+}
+
+// LiftSanctionRequest is the body of the lift sanction endpoint.
+type LiftSanctionRequest struct {
+	Actor       string       `json:"actor"`
+	Kind        SanctionKind `json:"kind"`
+	SubjectType SubjectType  `json:"subjectType"`
+	Subject     string       `json:"subject"`
+	Reason      string       `json:"reason"`
+}
+
+// SanctionResponse is a sanction that was issued.
+type SanctionResponse struct {
+	Kind        SanctionKind `json:"kind"`
+	SubjectType SubjectType  `json:"subjectType"`
+	Subject     string       `json:"subject"`
+	Reason      string       `json:"reason"`
+	IssuedBy    string       `json:"issuedBy"`
+	IssuedAt    time.Time    `json:"issuedAt"`
+	ExpiresAt   *time.Time   `json:"expiresAt,omitempty"` // Nil for permanent sanctions
+}
@@ -0,0 +1,74 @@
+package models
+
+// ErrorCode is a machine-readable error identifier sent to clients in ErrorPacket, so they can
+// react to specific failures and localize the message.
+type ErrorCode string
+
+const (
+	ErrorCodeInvalidPacket     ErrorCode = "INVALID_PACKET"      // The packet could not be decoded
+	ErrorCodeUnknownPacketType ErrorCode = "UNKNOWN_PACKET_TYPE" // No handler exists for the packet type
+	ErrorCodeValidationFailed  ErrorCode = "VALIDATION_FAILED"   // One or more fields were rejected, see ErrorPacket.Errors
+	ErrorCodeReadFailed        ErrorCode = "READ_FAILED"         // The message could not be read from the connection
+	ErrorCodeNotRegistered     ErrorCode = "NOT_REGISTERED"      // The connection has not sent a connect packet
+	ErrorCodeGameNotFound      ErrorCode = "GAME_NOT_FOUND"
+	ErrorCodeNotInGame         ErrorCode = "NOT_IN_GAME" // The user is not a player in the game
+	ErrorCodeGameOver          ErrorCode = "GAME_OVER"   // The game is no longer in progress
+	ErrorCodeNotYourTurn       ErrorCode = "NOT_YOUR_TURN"
+	ErrorCodeOutOfBounds       ErrorCode = "OUT_OF_BOUNDS"
+	ErrorCodeCellOccupied      ErrorCode = "CELL_OCCUPIED"
+	ErrorCodeStaleMove         ErrorCode = "STALE_MOVE" // The move's ply does not match the game
+	ErrorCodeMuted             ErrorCode = "MUTED"
+	ErrorCodeRateLimited       ErrorCode = "RATE_LIMITED"
+	ErrorCodeMatchmakingFailed ErrorCode = "MATCHMAKING_FAILED"
+	ErrorCodeCoolingDown       ErrorCode = "COOLING_DOWN" // The user abandoned recent games and must wait, see ErrorPacket.RetryAfterMs
+	ErrorCodeAlreadyInGame     ErrorCode = "ALREADY_IN_GAME"
+	ErrorCodeAlreadyQueued     ErrorCode = "ALREADY_QUEUED" // The user is already waiting for an opponent
+	ErrorCodeShuttingDown      ErrorCode = "SHUTTING_DOWN"
+	ErrorCodeInternal          ErrorCode = "INTERNAL_ERROR"
+	ErrorCodeUnauthorized      ErrorCode = "UNAUTHORIZED" // The API request has no valid session token
+	ErrorCodeBanned            ErrorCode = "BANNED"
+	ErrorCodeUserExists        ErrorCode = "USER_EXISTS"
+	ErrorCodeUserNotFound      ErrorCode = "USER_NOT_FOUND"
+	ErrorCodeSanctionNotFound  ErrorCode = "SANCTION_NOT_FOUND"
+	ErrorCodeNotFound          ErrorCode = "NOT_FOUND" // No API endpoint matches the request
+	ErrorCodeMethodNotAllowed  ErrorCode = "METHOD_NOT_ALLOWED"
+)
+
+// Error is an error that can be reported to a client. Its message is shown to players, so it is
+// written as a sentence.
+type Error struct {
+	Code    ErrorCode
+	Message string
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// Errors reported to clients. Compare with errors.Is; callers may wrap them with more context.
+var (
+	ErrInvalidPacket     = &Error{ErrorCodeInvalidPacket, "Invalid packet format"}
+	ErrUnknownPacketType = &Error{ErrorCodeUnknownPacketType, "Unknown packet type"}
+	ErrReadFailed        = &Error{ErrorCodeReadFailed, "Failed to read message"}
+	ErrNotRegistered     = &Error{ErrorCodeNotRegistered, "User not registered"}
+	ErrGameNotFound      = &Error{ErrorCodeGameNotFound, "Game not found"}
+	ErrNotInGame         = &Error{ErrorCodeNotInGame, "Not a player in this game"}
+	ErrGameOver          = &Error{ErrorCodeGameOver, "Game is not in progress"}
+	ErrNotYourTurn       = &Error{ErrorCodeNotYourTurn, "Not your turn"}
+	ErrOutOfBounds       = &Error{ErrorCodeOutOfBounds, "Move out of bounds"}
+	ErrCellOccupied      = &Error{ErrorCodeCellOccupied, "Cell already occupied"}
+	ErrStaleMove         = &Error{ErrorCodeStaleMove, "Move is out of date"}
+	ErrMuted             = &Error{ErrorCodeMuted, "You are muted"}
+	ErrRateLimited       = &Error{ErrorCodeRateLimited, "Too many requests"}
+	ErrMatchmakingFailed = &Error{ErrorCodeMatchmakingFailed, "Error in matchmaking"}
+	ErrCoolingDown       = &Error{ErrorCodeCoolingDown, "You left recent games and must wait before playing again"}
+	ErrAlreadyInGame     = &Error{ErrorCodeAlreadyInGame, "Already playing a game"}
+	ErrAlreadyQueued     = &Error{ErrorCodeAlreadyQueued, "Already looking for a game"}
+	ErrShuttingDown      = &Error{ErrorCodeShuttingDown, "Server is shutting down"}
+	ErrInternal          = &Error{ErrorCodeInternal, "Internal server error"}
+	ErrUnauthorized      = &Error{ErrorCodeUnauthorized, "Missing or invalid session token"}
+	ErrBanned            = &Error{ErrorCodeBanned, "You are banned"}
+	ErrUserExists        = &Error{ErrorCodeUserExists, "Username is already taken"}
+	ErrUserNotFound      = &Error{ErrorCodeUserNotFound, "User not found"}
+	ErrSanctionNotFound  = &Error{ErrorCodeSanctionNotFound, "Sanction not found"}
+	ErrNotFound          = &Error{ErrorCodeNotFound, "Not found"}
+	ErrMethodNotAllowed  = &Error{ErrorCodeMethodNotAllowed, "Method not allowed"}
+)
@@ -1,32 +1,49 @@
 package models
 
 import (
-	"errors"
-	"sync"
+	"slices"
 	"tictactoe/utils"
+	"time"
 )
 
 // Game represents a single game session between two players.
 type Game struct {
-	ID          string       // Unique identifier for the game
-	Players     []*User      // Slice of pointers to User structs representing the players
-	Board       [3][3]string // 3x3 board for Tic-Tac-Toe, each cell can be "X", "O", or empty
-	CurrentTurn string       // Indicates whose turn it is - "X" or "O"
-	Status      string       // Current status of the game, e.g., "waiting", "in_progress", "completed"
-	Winner      string       // Winner of the game, if applicable - "X", "O", or "draw"
-	mu          sync.Mutex
+	ID          string        // Unique identifier for the game
+	Players     []*User       // Slice of pointers to User structs representing the players
+	Board       [3][3]string  // 3x3 board for Tic-Tac-Toe, each cell can be "X", "O", or empty
+	CurrentTurn string        // Indicates whose turn it is - "X" or "O"
+	Status      string        // Current status of the game, e.g., "waiting", "in_progress", "completed"
+	Winner      string        // Winner of the game, if applicable - "X", "O", or "draw"
+	Moves       []Move        // Moves applied so far, in order; the next move's ply is len(Moves)
+	Variant     string        // Rules the game is played by, see utils.VariantClassic
+	MoveTime    time.Duration // Time each player has for each move; 0 uses the server's move timeout
 }
 
-// NewGame initializes a new Game instance with two players.
-func NewGame(player1, player2 *User) *Game {
-	gameID := utils.GenerateGameID() // Assuming generateGameID is a function that generates a unique game ID
-	return &Game{
-		ID:          gameID,
-		Players:     []*User{player1, player2},
-		Board:       [3][3]string{},
-		CurrentTurn: "X", // By default, the first player ("X") starts the game
-		Status:      utils.GameStateInProgress,
-	}
+// GameOptions are what a player asks for when looking for a game. Only players asking for the
+// same options are matched.
+type GameOptions struct {
+	Variant  string        // Rules to play by, see utils.VariantClassic
+	MoveTime time.Duration // Time each player has for each move; 0 uses the server's move timeout
+}
+
+// Move is a single applied move.
+type Move struct {
+	Player string // Username of the player who made the move
+	Row    int
+	Col    int
+}
+
+// Snapshot returns a copy of the game that later changes to g do not affect. Players are shared.
+func (g *Game) Snapshot() *Game {
+	snapshot := *g
+	snapshot.Players = slices.Clone(g.Players)
+	snapshot.Moves = slices.Clone(g.Moves)
+	return &snapshot
+}
+
+// Ply returns the number of moves applied so far, which is the ply the next move must carry.
+func (g *Game) Ply() int {
+	return len(g.Moves)
 }
 
 func (g *Game) IsPlayerCurrent(player *User) bool {
@@ -42,35 +59,69 @@ func (g *Game) IsPlayerCurrent(player *User) bool {
 	return playerSymbol == g.CurrentTurn
 }
 
+// Symbol returns the symbol player plays, "X" or "O", or "" if they are not part of the game.
+func (g *Game) Symbol(player *User) string {
+	switch {
+	case len(g.Players) != 2:
+		return ""
+	case g.Players[0] == player:
+		return "X"
+	case g.Players[1] == player:
+		return "O"
+	}
+	return ""
+}
+
+// CurrentPlayer returns the player whose turn it is.
+func (g *Game) CurrentPlayer() *User {
+	if g.CurrentTurn == "X" {
+		return g.Players[0]
+	}
+	return g.Players[1]
+}
+
+// Opponent returns the other player in the game, or nil if player is not part of it.
+func (g *Game) Opponent(player *User) *User {
+	if len(g.Players) != 2 {
+		return nil
+	}
+	if g.Players[0] == player {
+		return g.Players[1]
+	}
+	if g.Players[1] == player {
+		return g.Players[0]
+	}
+	return nil
+}
+
 func (g *Game) IsValidMove(row, col int) error {
 
 	if row < 0 || row >= 3 || col < 0 || col >= 3 {
-		return errors.New("move out of bounds")
+		return ErrOutOfBounds
 	}
 
 	if g.Board[row][col] != "" {
-		return errors.New("cell already occupied")
+		return ErrCellOccupied
 	}
 
 	return nil
 }
 
 func (g *Game) UpdateWinState(player *User) {
-	g.mu.Lock()
-	defer g.mu.Unlock()
 	g.Winner = player.Username
 	g.Status = utils.GameStateCompleted
 }
 
 func (g *Game) UpdateDrawState() {
-	g.mu.Lock()
-	defer g.mu.Unlock()
 	g.Status = utils.GameStateCompleted
 	g.Winner = utils.GameStateDraw
 }
 
 func (g *Game) UpdateBoard(row, col int, turn string) {
-	g.mu.Lock()
-	defer g.mu.Unlock()
 	g.Board[row][col] = turn
 }
+
+// RecordMove appends a move to the game's history.
+func (g *Game) RecordMove(player *User, row, col int) {
+	g.Moves = append(g.Moves, Move{Player: player.Username, Row: row, Col: col})
+}
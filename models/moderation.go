@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+// SanctionKind identifies the type of moderation restriction.
+type SanctionKind string
+
+const (
+	SanctionBan        SanctionKind = "ban"        // Blocks connecting and playing, usually permanently
+	SanctionSuspension SanctionKind = "suspension" // Temporary ban with a fixed end time
+	SanctionMute       SanctionKind = "mute"       // Blocks sending chat messages
+)
+
+// SubjectType identifies what a sanction is applied to.
+type SubjectType string
+
+const (
+	SubjectUsername SubjectType = "username"
+	SubjectDevice   SubjectType = "device"
+)
+
+// Audit actions recorded for moderation changes.
+const (
+	AuditActionIssue = "issue"
+	AuditActionLift  = "lift"
+)
+
+// Sanction is a moderation restriction applied to a username or device ID.
+type Sanction struct {
+	Kind        SanctionKind
+	SubjectType SubjectType
+	Subject     string    // Username or device ID the sanction applies to
+	Reason      string    // Reason given by the moderator
+	IssuedBy    string    // Moderator who issued the sanction
+	IssuedAt    time.Time // When the sanction was issued
+	ExpiresAt   time.Time // When the sanction ends; zero for permanent sanctions
+}
+
+// IsPermanent reports whether the sanction never expires.
+func (s *Sanction) IsPermanent() bool {
+	return s.ExpiresAt.IsZero()
+}
+
+// IsActive reports whether the sanction is in effect at the given time.
+func (s *Sanction) IsActive(now time.Time) bool {
+	return s.IsPermanent() || now.Before(s.ExpiresAt)
+}
+
+// AuditEntry records a single moderation action.
+type AuditEntry struct {
+	Action      string       `json:"action"` // "issue" or "lift"
+	Kind        SanctionKind `json:"kind"`
+	SubjectType SubjectType  `json:"subjectType"`
+	Subject     string       `json:"subject"`
+	Actor       string       `json:"actor"`
+	Reason      string       `json:"reason"`
+	At          time.Time    `json:"at"`
+	ExpiresAt   *time.Time   `json:"expiresAt,omitempty"` // Nil for permanent sanctions and lifts
+}
@@ -1,21 +1,41 @@
 package models
 
+import (
+	"encoding/json"
+	"tictactoe/utils"
+	"time"
+)
+
 // BasePacket defines the basic structure of all packets with a common Type field.
 type BasePacket struct {
-	Type string `json:"type"`
+	Type      string `json:"type"`
+	RequestID string `json:"requestId,omitempty"` // Chosen by the client and echoed in errors caused by the packet
 }
 
 // ConnectPacket is sent by the client to establish a user session.
 type ConnectPacket struct {
 	BasePacket
-	Username string `json:"username"`
-	DeviceID string `json:"deviceId"`
+	Username        string   `json:"username"`
+	DeviceID        string   `json:"deviceId"`
+	ProtocolVersion int      `json:"protocolVersion"` // Newest protocol version the client speaks; 0 for clients that predate versioning
+	Capabilities    []string `json:"capabilities"`    // Optional features the client supports, e.g. "chat"
 }
 
 // PlayPacket is sent by the client to request starting or joining a game.
 type PlayPacket struct {
 	BasePacket
-	Username string `json:"username"`
+	Username   string `json:"username"`
+	Variant    string `json:"variant,omitempty"`    // Defaults to classic
+	MoveTimeMs int64  `json:"moveTimeMs,omitempty"` // Time allowed for each move; omitted uses the server's move timeout
+}
+
+// Options returns the game options the player asked for.
+func (p *PlayPacket) Options() GameOptions {
+	options := GameOptions{Variant: p.Variant, MoveTime: time.Duration(p.MoveTimeMs) * time.Millisecond}
+	if options.Variant == "" {
+		options.Variant = utils.VariantClassic
+	}
+	return options
 }
 
 // MovePacket is sent by the client when making a move in a game.
@@ -24,6 +44,33 @@ type MovePacket struct {
 	GameID string `json:"gameId"`
 	Row    int    `json:"row"`
 	Col    int    `json:"col"`
+	Ply    *int   `json:"ply,omitempty"` // Number of moves the client has seen; makes resending the move safe
+}
+
+// WelcomePacket is sent by the server in reply to a ConnectPacket to state what was negotiated.
+type WelcomePacket struct {
+	BasePacket
+	ProtocolVersion int          `json:"protocolVersion"` // Version both sides will speak
+	Capabilities    []string     `json:"capabilities"`    // Capabilities supported by both sides
+	Variants        []string     `json:"variants"`        // Game variants the server can host
+	Limits          ServerLimits `json:"limits"`
+}
+
+// ServerLimits describes the limits the server enforces on a connection.
+type ServerLimits struct {
+	MaxMessageSize       int64 `json:"maxMessageSize"`       // Largest inbound message in bytes
+	PingIntervalMs       int64 `json:"pingIntervalMs"`       // How often the server pings; 0 if it does not
+	ReadTimeoutMs        int64 `json:"readTimeoutMs"`        // Idle time after which the connection is closed; 0 if never
+	MatchmakingTimeoutMs int64 `json:"matchmakingTimeoutMs"` // How long a play request waits for an opponent
+}
+
+// UpgradeRequiredPacket is sent by the server when the client's protocol version is too old. The
+// connection is closed after it is sent.
+type UpgradeRequiredPacket struct {
+	BasePacket
+	MinProtocolVersion int    `json:"minProtocolVersion"`
+	MaxProtocolVersion int    `json:"maxProtocolVersion"`
+	Message            string `json:"message"`
 }
 
 // GameUpdatePacket is sent by the server to inform clients about the current game state.
@@ -34,6 +81,13 @@ type GameUpdatePacket struct {
 	CurrentTurn bool         `json:"currentTurn"`
 	Winner      string       `json:"winner,omitempty"` // Empty if the game is ongoing
 	Status      string       `json:"status"`
+	Ply         int          `json:"ply"` // Number of moves applied; the ply to send with the next move
+}
+
+// UserStatsPacket is sent by the server after connecting with the user's game statistics.
+type UserStatsPacket struct {
+	BasePacket
+	Stats UserStatsResponse `json:"stats"`
 }
 
 // MatchFoundPacket is sent by the server to notify the client that a match has been found.
@@ -43,18 +97,81 @@ type MatchFoundPacket struct {
 	Opponent   string `json:"opponent"`
 	YourSymbol string `json:"yourSymbol"` // "X" or "O"
 	YourTurn   bool   `json:"yourTurn"`
+	Variant    string `json:"variant"`
+	MoveTimeMs int64  `json:"moveTimeMs,omitempty"` // Time allowed for each move, if the players chose it
 }
 
 // ErrorPacket is sent by the server in response to errors.
 type ErrorPacket struct {
 	BasePacket
-	Message string `json:"message"`
+	Code    ErrorCode    `json:"code"`
+	Message string       `json:"message"`          // English description; clients may localize by code instead
+	Errors  []FieldError `json:"errors,omitempty"` // Rejected fields, when a packet failed validation
+	// Milliseconds to wait before sending again, when rate limited
+	RetryAfterMs int64 `json:"retryAfterMs,omitempty"`
 }
 
 // GameEndPacket is sent by the server in response to game end.
 type GameEndPacket struct {
 	BasePacket
 	GameID  string `json:"gameId"`
-	Winner  string `json:"winner"`
+	Winner  string `json:"winner"`  // Username of the winner; empty for a draw
 	Outcome string `json:"outcome"` // Possible values: "win", "lose", "draw"
 }
+
+// BannedPacket is sent by the server when a ban or suspension bars the user.
+type BannedPacket struct {
+	BasePacket
+	Kind    string     `json:"kind"` // "ban" or "suspension"
+	Reason  string     `json:"reason"`
+	Until   *time.Time `json:"until,omitempty"` // Omitted for permanent bans
+	Message string     `json:"message"`
+}
+
+// ChatPacket is sent by the client to message their opponent, and relayed by the server to the opponent.
+type ChatPacket struct {
+	BasePacket
+	GameID  string `json:"gameId"`
+	From    string `json:"from,omitempty"` // Set by the server when relaying
+	Message string `json:"message"`
+}
+
+// ResignPacket is sent by the client to concede a game, making their opponent the winner.
+type ResignPacket struct {
+	BasePacket
+	GameID string `json:"gameId"`
+}
+
+// SpectatePacket is sent by the client to watch a game. The server replies with the game state and
+// sends every later update until the game ends.
+type SpectatePacket struct {
+	BasePacket
+	GameID string `json:"gameId"`
+}
+
+// ServerShutdownPacket is sent by the server to every client when it begins shutting down.
+type ServerShutdownPacket struct {
+	BasePacket
+	Message  string     `json:"message"`
+	Deadline *time.Time `json:"deadline,omitempty"` // When remaining connections will be closed, if known
+}
+
+// CloseNotice tells an HTTP transport client why its session ended. Code is a WebSocket close
+// code, so clients handle every transport alike.
+type CloseNotice struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// TransportSession identifies an SSE or long-poll session. Clients post packets to /send and poll
+// /poll with it as the session query parameter.
+type TransportSession struct {
+	SessionID string `json:"sessionId"`
+}
+
+// PollResponse carries the packets queued for a long-poll session since its last poll, and why
+// the session ended once it has.
+type PollResponse struct {
+	Packets []json.RawMessage `json:"packets"`
+	Close   *CloseNotice      `json:"close,omitempty"`
+}
@@ -1,18 +1,31 @@
 package models
 
 import (
-	"github.com/gorilla/websocket"
+	"errors"
+	"slices"
 	"sync"
+	"time"
 )
 
 // User represents a player or user in the system.
 type User struct {
-	Username    string          // Unique identifier for the user
-	DeviceID    string          // Device identifier for the user, if applicable
-	Conn        *websocket.Conn // WebSocket connection for real-time communication
-	CurrentGame *Game           // Pointer to the current game the user is part of, if any
-	Stats       UserStats       // User's game statistics
-	mu          sync.Mutex      // Mutex for synchronizing writes
+	Username    string       // Unique identifier for the user
+	DeviceID    string       // Device identifier for the user, if applicable
+	CurrentGame *Game        // Pointer to the current game the user is part of, if any
+	Stats       UserStats    // User's game statistics
+	conns       []Connection // Every open session, such as a phone and a desktop
+	mu          sync.Mutex   // Protects conns
+
+	// Games abandoned since the user was last forgiven, and when the latest was; they set how long
+	// the user must wait before matchmaking again
+	AbandonStreak   int
+	LastAbandonment time.Time
+}
+
+// Connection is the outbound side of one of a user's sessions, over whatever transport. It encodes
+// packets with the codec negotiated for the session.
+type Connection interface {
+	Send(packet any) error
 }
 
 // UserStats holds the statistics related to game outcomes for the user.
@@ -20,6 +33,35 @@ type UserStats struct {
 	Wins   int // Number of games won by the user
 	Losses int // Number of games lost by the user
 	Draws  int // Number of games that ended in a draw
+	// Number of games the user forfeited by leaving or not moving; these also count as losses
+	Abandonments int
+	AsX          SymbolStats // Outcomes of the games the user played as X, who moves first
+	AsO          SymbolStats // Outcomes of the games the user played as O
+}
+
+// SymbolStats holds the outcomes of the games a user played with one symbol.
+type SymbolStats struct {
+	Wins   int
+	Losses int
+	Draws  int
+}
+
+// Games returns the number of games played with the symbol.
+func (s SymbolStats) Games() int {
+	return s.Wins + s.Losses + s.Draws
+}
+
+// WinRate returns the share of games played with the symbol that were won, or 0 if there were none.
+func (s SymbolStats) WinRate() float64 {
+	if s.Games() == 0 {
+		return 0
+	}
+	return float64(s.Wins) / float64(s.Games())
+}
+
+// SymbolBalance returns how many more games the user has played as X than as O.
+func (s UserStats) SymbolBalance() int {
+	return s.AsX.Games() - s.AsO.Games()
 }
 
 // NewUser initializes a new User instance.
@@ -31,25 +73,65 @@ func NewUser(username, deviceID string) *User {
 	}
 }
 
-func (u *User) SetConnection(conn *websocket.Conn) {
-	u.Conn = conn
+// AddConnection adds a session to the user. Adding a session twice has no effect.
+func (u *User) AddConnection(conn Connection) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !slices.Contains(u.conns, conn) {
+		u.conns = append(u.conns, conn)
+	}
 }
 
-// UpdateStats updates the user's game statistics based on the game outcome.
-func (u *User) UpdateStats(won bool, draw bool) {
+// RemoveConnection removes a session from the user and returns how many remain.
+func (u *User) RemoveConnection(conn Connection) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if i := slices.Index(u.conns, conn); i >= 0 {
+		u.conns = slices.Delete(u.conns, i, i+1)
+	}
+	return len(u.conns)
+}
+
+// Connections returns a snapshot of the user's open sessions.
+func (u *User) Connections() []Connection {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return slices.Clone(u.conns)
+}
+
+// UpdateStats updates the user's game statistics based on the outcome of a game they played as
+// symbol, "X" or "O".
+func (u *User) UpdateStats(symbol string, won bool, draw bool) {
+	bySymbol := &u.Stats.AsO
+	if symbol == "X" {
+		bySymbol = &u.Stats.AsX
+	}
 	if draw {
 		u.Stats.Draws++
+		bySymbol.Draws++
 		return
 	}
 	if won {
 		u.Stats.Wins++
+		bySymbol.Wins++
 	} else {
 		u.Stats.Losses++
+		bySymbol.Losses++
 	}
 }
 
-func (u *User) SendMessage(msg []byte) error {
-	u.mu.Lock()
-	defer u.mu.Unlock()
-	return u.Conn.WriteMessage(websocket.TextMessage, msg)
+// SendPacket queues a packet on every one of the user's sessions. It fails if the user has no
+// session or any session could not take the packet.
+func (u *User) SendPacket(packet any) error {
+	conns := u.Connections()
+	if len(conns) == 0 {
+		return errors.New("user is not connected")
+	}
+	var errs []error
+	for _, conn := range conns {
+		if err := conn.Send(packet); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validator is implemented by inbound packets that check their own field values.
+type Validator interface {
+	Validate() error
+}
+
+// FieldError describes why a single packet field was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every rejected field of a packet.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+// Add records a rejected field.
+func (e *ValidationError) Add(field, format string, args ...any) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Err returns e if any field was rejected, and nil otherwise.
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		parts[i] = f.Field + ": " + f.Message
+	}
+	return "invalid packet: " + strings.Join(parts, "; ")
+}
+
+// Limits on client-supplied strings.
+const (
+	MaxUsernameLength    = 32
+	MaxDeviceIDLength    = 128
+	MaxChatMessageLength = 500
+	MaxVariantLength     = 32
+	MaxReasonLength      = 500
+)
+
+// Limits on the time control a player may ask for.
+const (
+	MinMoveTimeMs = 1000
+	MaxMoveTimeMs = 10 * 60 * 1000
+)
+
+// Validate checks the connect packet's fields.
+func (p *ConnectPacket) Validate() error {
+	var v ValidationError
+	requireString(&v, "username", p.Username, MaxUsernameLength)
+	if utf8.RuneCountInString(p.DeviceID) > MaxDeviceIDLength {
+		v.Add("deviceId", "must be at most %d characters", MaxDeviceIDLength)
+	}
+	if p.ProtocolVersion < 0 {
+		v.Add("protocolVersion", "must not be negative")
+	}
+	return v.Err()
+}
+
+// Validate checks the play packet's fields.
+func (p *PlayPacket) Validate() error {
+	var v ValidationError
+	requireString(&v, "username", p.Username, MaxUsernameLength)
+	if utf8.RuneCountInString(p.Variant) > MaxVariantLength {
+		v.Add("variant", "must be at most %d characters", MaxVariantLength)
+	}
+	if p.MoveTimeMs != 0 && (p.MoveTimeMs < MinMoveTimeMs || p.MoveTimeMs > MaxMoveTimeMs) {
+		v.Add("moveTimeMs", "must be between %d and %d", MinMoveTimeMs, MaxMoveTimeMs)
+	}
+	return v.Err()
+}
+
+// Validate checks the move packet's fields.
+func (p *MovePacket) Validate() error {
+	var v ValidationError
+	if p.GameID == "" {
+		v.Add("gameId", "is required")
+	}
+	if p.Ply != nil && *p.Ply < 0 {
+		v.Add("ply", "must not be negative")
+	}
+	return v.Err()
+}
+
+// Validate checks the chat packet's fields. From is set by the server, so clients may not send it.
+func (p *ChatPacket) Validate() error {
+	var v ValidationError
+	if p.GameID == "" {
+		v.Add("gameId", "is required")
+	}
+	if p.From != "" {
+		v.Add("from", "is set by the server")
+	}
+	requireString(&v, "message", p.Message, MaxChatMessageLength)
+	return v.Err()
+}
+
+// Validate checks the resign packet's fields.
+func (p *ResignPacket) Validate() error {
+	var v ValidationError
+	if p.GameID == "" {
+		v.Add("gameId", "is required")
+	}
+	return v.Err()
+}
+
+// Validate checks the spectate packet's fields.
+func (p *SpectatePacket) Validate() error {
+	var v ValidationError
+	if p.GameID == "" {
+		v.Add("gameId", "is required")
+	}
+	return v.Err()
+}
+
+// Validate checks the register and login request's fields. The device ID is required because it
+// is what proves a login comes from the user's device.
+func (r *CredentialsRequest) Validate() error {
+	var v ValidationError
+	requireString(&v, "username", r.Username, MaxUsernameLength)
+	requireString(&v, "deviceId", r.DeviceID, MaxDeviceIDLength)
+	return v.Err()
+}
+
+// Validate checks the move request's fields.
+func (r *MoveRequest) Validate() error {
+	var v ValidationError
+	if r.Ply != nil && *r.Ply < 0 {
+		v.Add("ply", "must not be negative")
+	}
+	return v.Err()
+}
+
+// Validate checks the sanction request's fields.
+func (r *SanctionRequest) Validate() error {
+	var v ValidationError
+	validateSanctionTarget(&v, r.Actor, r.Kind, r.SubjectType, r.Subject)
+	if utf8.RuneCountInString(r.Reason) > MaxReasonLength {
+		v.Add("reason", "must be at most %d characters", MaxReasonLength)
+	}
+	switch {
+	case r.DurationMs < 0:
+		v.Add("durationMs", "must not be negative")
+	case r.DurationMs == 0 && r.Kind == SanctionSuspension:
+		v.Add("durationMs", "is required for a suspension")
+	}
+	return v.Err()
+}
+
+// Validate checks the lift sanction request's fields.
+func (r *LiftSanctionRequest) Validate() error {
+	var v ValidationError
+	validateSanctionTarget(&v, r.Actor, r.Kind, r.SubjectType, r.Subject)
+	if utf8.RuneCountInString(r.Reason) > MaxReasonLength {
+		v.Add("reason", "must be at most %d characters", MaxReasonLength)
+	}
+	return v.Err()
+}
+
+func validateSanctionTarget(v *ValidationError, actor string, kind SanctionKind, subjectType SubjectType, subject string) {
+	requireString(v, "actor", actor, MaxUsernameLength)
+	switch kind {
+	case SanctionBan, SanctionSuspension, SanctionMute:
+	default:
+		v.Add("kind", "must be ban, suspension or mute")
+	}
+	switch subjectType {
+	case SubjectUsername:
+		requireString(v, "subject", subject, MaxUsernameLength)
+	case SubjectDevice:
+		requireString(v, "subject", subject, MaxDeviceIDLength)
+	default:
+		v.Add("subjectType", "must be username or device")
+	}
+}
+
+func requireString(v *ValidationError, field, value string, maxLength int) {
+	switch {
+	case strings.TrimSpace(value) == "":
+		v.Add(field, "is required")
+	case utf8.RuneCountInString(value) > maxLength:
+		v.Add(field, "must be at most %d characters", maxLength)
+	}
+}
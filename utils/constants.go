@@ -1,15 +1,39 @@
 package utils
 
 const (
-	ConnectPacketType   = "connect"
-	PlayPacketType      = "play"
-	MovePacketType      = "move"
-	GameStartPacketType = "gameStart"
-	UserStatsPacketType = "userStats"
-	NoMatchFoundType    = "noMatchFound"
-	ErrorPacketType     = "error"
-	GameStateWaiting    = "waiting"
-	GameStateInProgress = "in_progress"
-	GameStateCompleted  = "completed"
-	GameStateDraw       = "draw"
+	ConnectPacketType        = "connect"
+	PlayPacketType           = "play"
+	MovePacketType           = "move"
+	GameStartPacketType      = "gameStart"
+	UserStatsPacketType      = "userStats"
+	NoMatchFoundType         = "noMatchFound"
+	ErrorPacketType          = "error"
+	BannedPacketType         = "banned"
+	ChatPacketType           = "chat"
+	ResignPacketType         = "resign"
+	SpectatePacketType       = "spectate"
+	ServerShutdownPacketType = "serverShutdown"
+	WelcomePacketType        = "welcome"
+	UpgradeRequiredType      = "upgradeRequired"
+	GameStateWaiting         = "waiting"
+	GameStateInProgress      = "in_progress"
+	GameStateCompleted       = "completed"
+	GameStateDraw            = "draw"
+)
+
+// Protocol versions spoken by the server. Clients older than MinProtocolVersion must upgrade;
+// newer clients are answered with ProtocolVersion.
+const (
+	ProtocolVersion    = 1
+	MinProtocolVersion = 1
+)
+
+// Optional protocol features negotiated in the connect handshake.
+const (
+	CapabilityChat = "chat"
+)
+
+// Game variants the server can host.
+const (
+	VariantClassic = "classic"
 )
@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"github.com/google/uuid"
 )
 
@@ -8,3 +10,22 @@ import (
 func GenerateGameID() string {
 	return uuid.New().String()
 }
+
+// GenerateConnectionID creates a unique identifier used to correlate a connection's log lines.
+func GenerateConnectionID() string {
+	return uuid.New().String()
+}
+
+// GenerateNodeID creates a name for a server node that was not given one.
+func GenerateNodeID() string {
+	return "node-" + uuid.New().String()[:8]
+}
+
+// GenerateSessionToken creates an unguessable token that authenticates REST API requests.
+func GenerateSessionToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}